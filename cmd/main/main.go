@@ -3,52 +3,95 @@ package main
 import (
 	"context"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
 	"log"
 	"usershards/internal/config"
+	"usershards/internal/health"
 	"usershards/internal/logger"
+	"usershards/internal/metrics"
 	"usershards/internal/profile"
+	"usershards/internal/saga"
 	"usershards/internal/shard"
+	"usershards/internal/tracing"
 )
 
 func main() {
 	err := run()
 	if err != nil {
-		logger.Logger.Fatal(err)
+		logger.L().Fatal("failed to run", zap.Error(err))
 	}
 }
 
 func run() error {
 	profile.StartPprof()
 
-	logger.InitLogger()
-	defer logger.Logger.Sync()
-
 	conf, err := config.LoadConfig("config.yaml")
 	if err != nil {
 		return err
 	}
 
+	err = logger.Init(conf.Logging)
+	if err != nil {
+		return err
+	}
+	defer logger.L().Sync()
+
 	ctx := context.Background()
 
+	shutdownTracing, err := tracing.Init(ctx, conf.Tracing)
+	if err != nil {
+		return err
+	}
+	defer shutdownTracing(ctx)
+
 	shardManager, err := shard.NewShardManager(ctx, conf)
 	if err != nil {
 		return err
 	}
 	defer shardManager.Close()
 
-	// Initialize Temporal client
-	temporalClient, err := client.Dial(client.Options{})
+	// Initialize Temporal client, the callers' traces go on through the workflows
+	interceptors, err := tracing.ClientInterceptors()
+	if err != nil {
+		return err
+	}
+	temporalClient, err := client.Dial(client.Options{
+		Interceptors:       interceptors,
+		ContextPropagators: []workflow.ContextPropagator{logger.NewContextPropagator()},
+		Logger:             logger.NewTemporalLogger(logger.L()),
+	})
 	if err != nil {
 		log.Fatal("Unable to create Temporal client", err)
 	}
 	defer temporalClient.Close()
-	logger.Logger.Info("Connected to Temporal successfully")
+	logger.L().Info("Connected to Temporal successfully")
+
+	err = metrics.RegisterStuckSagas(temporalClient, conf.Metrics.StuckAfter, saga.SagaWorkflowTypes...)
+	if err != nil {
+		return err
+	}
+	metricsServer := metrics.Serve(conf.Metrics.Addr)
+	if metricsServer != nil {
+		defer metricsServer.Close()
+	}
+
+	checker := health.NewChecker(conf.Health.Timeout)
+	checker.AddShards(shardManager)
+	checker.Add("temporal", health.TemporalCheck(temporalClient))
+	healthServer := checker.Serve(conf.Health.Addr)
+	if healthServer != nil {
+		defer healthServer.Close()
+	}
 
 	//userService := services.NewUserService(shardManager, temporalClient)
 	//simpleService := services.NewSimpleService()
 	//
-	//worker, _ := saga.NewWorker(temporalClient, simpleService, userService)
-	//defer worker.Stop()
+	//userWorker, transferWorker := saga.NewWorker(temporalClient, userSaga)
+	//defer userWorker.Stop()
+	//defer transferWorker.Stop()
+	//checker.Add("worker.user", userWorker.Check)
+	//checker.Add("worker.transfer", transferWorker.Check)
 	//
 	//tctx, cancel := context.WithTimeout(ctx, time.Second*10)
 	//defer cancel()
@@ -5,4 +5,28 @@ import "errors"
 var (
 	ErrCompensationCompleted = errors.New("compensation is completed")
 	ErrUserIsBlocked         = errors.New("user is blocked")
+	ErrInvalidCursor         = errors.New("invalid cursor")
+	ErrTransferNotFound      = errors.New("transfer not found")
+	ErrInsufficientFunds     = errors.New("insufficient funds")
+	ErrHoldNotFound          = errors.New("hold not found")
+	ErrHoldNotActive         = errors.New("hold is not active")
+	ErrLimitExceeded         = errors.New("transfer limit exceeded")
+	ErrCurrencyMismatch      = errors.New("currencies differ and no conversion rate is given")
+	ErrInvalidCurrency       = errors.New("invalid currency")
+	ErrAmountOverflow        = errors.New("amount is out of range")
+	ErrRateNotFound          = errors.New("exchange rate not found")
+	ErrQuoteNotFound         = errors.New("quote not found")
+	ErrQuoteExpired          = errors.New("quote is expired")
+	ErrQuoteUsed             = errors.New("quote is already used")
+	ErrPaymentNotFound       = errors.New("payment not found")
+	ErrPaymentDeclined       = errors.New("payment is declined by provider")
+	ErrScheduleNotFound      = errors.New("schedule not found")
+	ErrRefundNotAllowed      = errors.New("transfer can't be refunded")
+	ErrRefundExceedsTransfer = errors.New("refund exceeds the transfer amount")
+	ErrInvalidSplit          = errors.New("invalid split transfer")
+	ErrInvalidWebhook        = errors.New("invalid webhook")
+	ErrWebhookNotFound       = errors.New("webhook not found")
+	ErrDeliveryNotFound      = errors.New("webhook delivery not found")
+	ErrAccountNotFound       = errors.New("account event stream not found")
+	ErrEscrowNotFound        = errors.New("escrow not found")
 )
@@ -0,0 +1,130 @@
+package cdc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"time"
+)
+
+// LSN is a position in the write-ahead log of a shard.
+type LSN uint64
+
+func (l LSN) String() string {
+	return fmt.Sprintf("%X/%X", uint32(l>>32), uint32(l))
+}
+
+func (l LSN) MarshalText() ([]byte, error) {
+	return []byte(l.String()), nil
+}
+
+func (l *LSN) UnmarshalText(text []byte) error {
+	var hi, lo uint32
+	if _, err := fmt.Sscanf(string(text), "%X/%X", &hi, &lo); err != nil {
+		return fmt.Errorf("invalid lsn %q: %w", text, err)
+	}
+	*l = LSN(uint64(hi)<<32 | uint64(lo))
+	return nil
+}
+
+type Op string
+
+const OpInsert Op = "insert"
+const OpUpdate Op = "update"
+const OpDelete Op = "delete"
+const OpTruncate Op = "truncate"
+
+// Change is one row change of a committed transaction. The changes of a transaction share the commit LSN
+// and go in the order they were made, so (Shard, LSN, Seq) identifies a change.
+type Change struct {
+	Shard       int            `json:"shard"`
+	LSN         LSN            `json:"lsn"`
+	Seq         int            `json:"seq"`
+	XID         uint32         `json:"xid"`
+	Table       string         `json:"table"`
+	Op          Op             `json:"op"`
+	Before      map[string]any `json:"before,omitempty"` // the old row of updates and deletes
+	After       map[string]any `json:"after,omitempty"`  // the new row of inserts and updates
+	CommittedAt time.Time      `json:"committed_at"`
+}
+
+// Sink receives the changes of one committed transaction at a time. After a restart the changes after
+// the last checkpoint are published again, so a sink must tolerate duplicates.
+type Sink interface {
+	Publish(ctx context.Context, changes []Change) error
+}
+
+// WriterSink writes the changes as JSON lines.
+type WriterSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{encoder: json.NewEncoder(w)}
+}
+
+// NewFileSink appends the changes to the file, "" or "-" means stdout.
+func NewFileSink(path string) (*WriterSink, error) {
+	if path == "" || path == "-" {
+		return NewWriterSink(os.Stdout), nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cdc file: %w", err)
+	}
+	return NewWriterSink(file), nil
+}
+
+func (s *WriterSink) Publish(ctx context.Context, changes []Change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, change := range changes {
+		if err := s.encoder.Encode(change); err != nil {
+			return fmt.Errorf("failed to write change: %w", err)
+		}
+	}
+	return nil
+}
+
+// MemorySink keeps the changes in memory, for tests.
+type MemorySink struct {
+	mu      sync.Mutex
+	changes []Change
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) Publish(ctx context.Context, changes []Change) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.changes = append(s.changes, changes...)
+	return nil
+}
+
+// Changes returns the changes of the table in the order they were published, all changes if table is "".
+func (s *MemorySink) Changes(table string) []Change {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if table == "" {
+		return slices.Clone(s.changes)
+	}
+
+	var changes []Change
+	for _, change := range s.changes {
+		if change.Table == table {
+			changes = append(changes, change)
+		}
+	}
+	return changes
+}
@@ -0,0 +1,319 @@
+package cdc
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgproto3"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.uber.org/zap"
+	"strings"
+	"sync"
+	"time"
+	"usershards/internal/config"
+	"usershards/internal/logger"
+	"usershards/internal/shard"
+)
+
+const defaultSlot = "usershards_cdc"
+const defaultPublication = "usershards_cdc"
+const defaultStatusInterval = 10 * time.Second
+
+// restartInterval is the pause before a shard is streamed again after an error.
+const restartInterval = 5 * time.Second
+
+// Tables are the tables in the change feed.
+var Tables = []string{"users", "transaction"}
+
+// Consumer streams the row changes of Tables from every user shard by logical replication with pgoutput.
+// Every shard has its own replication slot, and the position after the last published transaction is
+// checkpointed on the shard, so a restarted consumer goes on from there.
+type Consumer struct {
+	shardManager   *shard.ShardManager
+	sink           Sink
+	slot           string
+	publication    string
+	statusInterval time.Duration
+}
+
+// NewConsumer creates the consumer, zero fields of conf mean the defaults.
+func NewConsumer(shardManager *shard.ShardManager, sink Sink, conf config.CDC) *Consumer {
+	c := &Consumer{
+		shardManager:   shardManager,
+		sink:           sink,
+		slot:           conf.Slot,
+		publication:    conf.Publication,
+		statusInterval: conf.StatusInterval,
+	}
+	if c.slot == "" {
+		c.slot = defaultSlot
+	}
+	if c.publication == "" {
+		c.publication = defaultPublication
+	}
+	if c.statusInterval <= 0 {
+		c.statusInterval = defaultStatusInterval
+	}
+
+	return c
+}
+
+// Setup creates the publication and the replication slot on every shard which doesn't have them yet.
+// The changes are captured from the moment the slot is created.
+func (c *Consumer) Setup(ctx context.Context) error {
+	for shardID, userDB := range c.shardManager.UserShards {
+		var exists bool
+		const selectPublication = `SELECT EXISTS (SELECT 1 FROM pg_publication WHERE pubname = $1)`
+		err := userDB.QueryRow(ctx, selectPublication, c.publication).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to select publication on shard %d: %w", shardID, err)
+		}
+		if !exists {
+			query := fmt.Sprintf("CREATE PUBLICATION %s FOR TABLE %s, %s",
+				pgx.Identifier{c.publication}.Sanitize(),
+				pgx.Identifier{Tables[0]}.Sanitize(), pgx.Identifier{Tables[1]}.Sanitize())
+			if _, err = userDB.Exec(ctx, query); err != nil {
+				return fmt.Errorf("failed to create publication on shard %d: %w", shardID, err)
+			}
+		}
+
+		const selectSlot = `SELECT EXISTS (SELECT 1 FROM pg_replication_slots WHERE slot_name = $1)`
+		err = userDB.QueryRow(ctx, selectSlot, c.slotName(shardID)).Scan(&exists)
+		if err != nil {
+			return fmt.Errorf("failed to select replication slot on shard %d: %w", shardID, err)
+		}
+		if !exists {
+			const createSlot = `SELECT pg_create_logical_replication_slot($1, 'pgoutput')`
+			if _, err = userDB.Exec(ctx, createSlot, c.slotName(shardID)); err != nil {
+				return fmt.Errorf("failed to create replication slot on shard %d: %w", shardID, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Drop removes the replication slots and the checkpoints, so the shards stop keeping the WAL for the consumer.
+// The consumer must be stopped.
+func (c *Consumer) Drop(ctx context.Context) error {
+	for shardID, userDB := range c.shardManager.UserShards {
+		const dropSlot = `SELECT pg_drop_replication_slot(slot_name) FROM pg_replication_slots WHERE slot_name = $1`
+		if _, err := userDB.Exec(ctx, dropSlot, c.slotName(shardID)); err != nil {
+			return fmt.Errorf("failed to drop replication slot on shard %d: %w", shardID, err)
+		}
+		const deleteCheckpoint = `DELETE FROM cdc_checkpoints WHERE slot = $1`
+		if _, err := userDB.Exec(ctx, deleteCheckpoint, c.slotName(shardID)); err != nil {
+			return fmt.Errorf("failed to delete checkpoint on shard %d: %w", shardID, err)
+		}
+	}
+
+	return nil
+}
+
+// Checkpoint returns the position after the last published transaction of the shard, 0 if there was none.
+func (c *Consumer) Checkpoint(ctx context.Context, shardID int) (LSN, error) {
+	userDB, err := c.userDB(shardID)
+	if err != nil {
+		return 0, err
+	}
+
+	var lsn int64
+	const query = `SELECT lsn FROM cdc_checkpoints WHERE slot = $1`
+	err = userDB.QueryRow(ctx, query, c.slotName(shardID)).Scan(&lsn)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("failed to select checkpoint: %w", err)
+	}
+
+	return LSN(lsn), nil
+}
+
+// Run streams every shard until ctx is done, a failed stream is restarted from its checkpoint.
+func (c *Consumer) Run(ctx context.Context) {
+	wg := sync.WaitGroup{}
+	for shardID := range c.shardManager.UserShards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			for {
+				err := c.stream(ctx, shardID)
+				if ctx.Err() != nil {
+					return
+				}
+				logger.L().Error("failed to stream changes", zap.Int("shard", shardID), zap.Error(err))
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-time.After(restartInterval):
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func (c *Consumer) stream(ctx context.Context, shardID int) error {
+	userDB, err := c.userDB(shardID)
+	if err != nil {
+		return err
+	}
+	checkpoint, err := c.Checkpoint(ctx, shardID)
+	if err != nil {
+		return err
+	}
+
+	// the replication connection goes to the same database as the pool, but it speaks the replication protocol
+	connConfig := userDB.Config().ConnConfig.Config.Copy()
+	connConfig.RuntimeParams["replication"] = "database"
+	conn, err := pgconn.ConnectConfig(ctx, connConfig)
+	if err != nil {
+		return fmt.Errorf("failed to connect for replication: %w", err)
+	}
+	defer conn.Close(context.Background())
+
+	err = c.startReplication(ctx, conn, shardID, checkpoint)
+	if err != nil {
+		return err
+	}
+
+	// the server may drop the WAL up to flushed. It is ahead of the checkpoint when the transactions after it
+	// have nothing to publish, otherwise the skipped WAL would be kept until the next published transaction.
+	flushed := checkpoint
+	decoder := newDecoder(shardID)
+	nextStatus := time.Now().Add(c.statusInterval)
+	for {
+		if !time.Now().Before(nextStatus) {
+			if err = sendStandbyStatus(ctx, conn, flushed); err != nil {
+				return err
+			}
+			nextStatus = time.Now().Add(c.statusInterval)
+		}
+
+		receiveCtx, cancel := context.WithDeadline(ctx, nextStatus)
+		msg, err := conn.ReceiveMessage(receiveCtx)
+		cancel()
+		if err != nil {
+			if pgconn.Timeout(err) && ctx.Err() == nil {
+				continue
+			}
+			return fmt.Errorf("failed to receive replication message: %w", err)
+		}
+
+		switch msg := msg.(type) {
+		case *pgproto3.CopyData:
+			if len(msg.Data) == 0 {
+				continue
+			}
+			switch msg.Data[0] {
+			case 'k':
+				if len(msg.Data) != 18 {
+					return fmt.Errorf("malformed keepalive message")
+				}
+				// nothing is in progress, so everything the server has sent so far is processed
+				if walEnd := LSN(binary.BigEndian.Uint64(msg.Data[1:9])); !decoder.inTx && walEnd > flushed {
+					flushed = walEnd
+				}
+				// the server asks for the status when the last byte is set
+				if msg.Data[17] == 1 {
+					nextStatus = time.Time{}
+				}
+			case 'w':
+				if len(msg.Data) < 25 {
+					return fmt.Errorf("malformed wal data message")
+				}
+				changes, endLSN, committed, err := decoder.decode(msg.Data[25:])
+				if err != nil {
+					return fmt.Errorf("failed to decode wal data: %w", err)
+				}
+				if !committed {
+					continue
+				}
+				// a transaction without changes of Tables isn't checkpointed, the checkpoint itself is one,
+				// but its WAL is processed all the same
+				if len(changes) == 0 {
+					flushed = max(flushed, endLSN)
+					continue
+				}
+				if err = c.sink.Publish(ctx, changes); err != nil {
+					return fmt.Errorf("failed to publish changes: %w", err)
+				}
+				if err = c.saveCheckpoint(ctx, userDB, shardID, endLSN); err != nil {
+					return err
+				}
+				flushed = max(flushed, endLSN)
+			}
+		case *pgproto3.ErrorResponse:
+			return pgconn.ErrorResponseToPgError(msg)
+		case *pgproto3.CopyDone:
+			return fmt.Errorf("replication stream of shard %d ended", shardID)
+		}
+	}
+}
+
+func (c *Consumer) startReplication(ctx context.Context, conn *pgconn.PgConn, shardID int, start LSN) error {
+	// publication_names is a string literal with the comma separated identifiers in it
+	publication := strings.ReplaceAll(pgx.Identifier{c.publication}.Sanitize(), "'", "''")
+	query := fmt.Sprintf("START_REPLICATION SLOT %s LOGICAL %s (proto_version '1', publication_names '%s')",
+		pgx.Identifier{c.slotName(shardID)}.Sanitize(), start, publication)
+	conn.Frontend().Send(&pgproto3.Query{String: query})
+	if err := conn.Frontend().Flush(); err != nil {
+		return fmt.Errorf("failed to start replication: %w", err)
+	}
+
+	for {
+		msg, err := conn.ReceiveMessage(ctx)
+		if err != nil {
+			return fmt.Errorf("failed to start replication: %w", err)
+		}
+		switch msg := msg.(type) {
+		case *pgproto3.CopyBothResponse:
+			return nil
+		case *pgproto3.ErrorResponse:
+			return fmt.Errorf("failed to start replication: %w", pgconn.ErrorResponseToPgError(msg))
+		}
+	}
+}
+
+// sendStandbyStatus tells the server that everything before lsn is processed, so it may drop that WAL.
+func sendStandbyStatus(ctx context.Context, conn *pgconn.PgConn, lsn LSN) error {
+	data := make([]byte, 0, 34)
+	data = append(data, 'r')
+	data = binary.BigEndian.AppendUint64(data, uint64(lsn)) // written
+	data = binary.BigEndian.AppendUint64(data, uint64(lsn)) // flushed
+	data = binary.BigEndian.AppendUint64(data, uint64(lsn)) // applied
+	data = binary.BigEndian.AppendUint64(data, uint64(time.Since(postgresEpoch).Microseconds()))
+	data = append(data, 0)
+
+	conn.Frontend().Send(&pgproto3.CopyData{Data: data})
+	if err := conn.Frontend().Flush(); err != nil {
+		return fmt.Errorf("failed to send standby status: %w", err)
+	}
+	return nil
+}
+
+func (c *Consumer) saveCheckpoint(ctx context.Context, userDB *pgxpool.Pool, shardID int, lsn LSN) error {
+	const query = `INSERT INTO cdc_checkpoints (slot, lsn, updated_at) VALUES ($1, $2, $3)
+				   ON CONFLICT (slot) DO UPDATE SET lsn = excluded.lsn, updated_at = excluded.updated_at`
+	_, err := userDB.Exec(ctx, query, c.slotName(shardID), int64(lsn), time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to save checkpoint: %w", err)
+	}
+	return nil
+}
+
+// slotName is unique per shard, the slots of the shards on one server share a namespace.
+func (c *Consumer) slotName(shardID int) string {
+	return fmt.Sprintf("%s_%d", c.slot, shardID)
+}
+
+func (c *Consumer) userDB(shardID int) (*pgxpool.Pool, error) {
+	userDB, ok := c.shardManager.UserShards[shardID]
+	if !ok {
+		return nil, fmt.Errorf("user shard %d not found", shardID)
+	}
+	return userDB, nil
+}
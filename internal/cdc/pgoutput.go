@@ -0,0 +1,276 @@
+package cdc
+
+import (
+	"encoding/binary"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5/pgtype"
+	"time"
+)
+
+// postgresEpoch is the zero time of the replication protocol, the times are microseconds since it.
+var postgresEpoch = time.Date(2000, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+func postgresTime(micros int64) time.Time {
+	return postgresEpoch.Add(time.Duration(micros) * time.Microsecond)
+}
+
+type column struct {
+	name    string
+	typeOID uint32
+}
+
+type relation struct {
+	name    string
+	columns []column
+}
+
+// decoder turns the messages of the pgoutput plugin, protocol version 1, into changes.
+// The relations are sent before their first change in every replication session, so the decoder
+// lives as long as the session.
+type decoder struct {
+	shard     int
+	typeMap   *pgtype.Map
+	relations map[uint32]relation
+
+	lsn         LSN
+	xid         uint32
+	committedAt time.Time
+	changes     []Change
+	inTx        bool // between Begin and Commit
+}
+
+func newDecoder(shard int) *decoder {
+	return &decoder{
+		shard:     shard,
+		typeMap:   pgtype.NewMap(),
+		relations: make(map[uint32]relation),
+	}
+}
+
+// decode handles one message. When the message commits a transaction it returns the changes
+// of the transaction and the position after it.
+func (d *decoder) decode(data []byte) ([]Change, LSN, bool, error) {
+	r := &reader{data: data}
+	msgType := r.byte()
+
+	switch msgType {
+	case 'B':
+		d.lsn = LSN(r.uint64())
+		d.committedAt = postgresTime(int64(r.uint64()))
+		d.xid = r.uint32()
+		d.changes = nil
+		d.inTx = true
+	case 'C':
+		r.byte() // flags
+		r.uint64()
+		endLSN := LSN(r.uint64())
+		if r.err != nil {
+			return nil, 0, false, r.err
+		}
+		changes := d.changes
+		d.changes = nil
+		d.inTx = false
+		return changes, endLSN, true, nil
+	case 'R':
+		relationID := r.uint32()
+		namespace := r.string()
+		name := r.string()
+		r.byte() // replica identity
+		rel := relation{name: name}
+		if namespace != "public" {
+			rel.name = namespace + "." + name
+		}
+		for n := int(r.uint16()); n > 0 && r.err == nil; n-- {
+			r.byte() // flags
+			col := column{name: r.string(), typeOID: r.uint32()}
+			r.uint32() // type modifier
+			rel.columns = append(rel.columns, col)
+		}
+		d.relations[relationID] = rel
+	case 'I':
+		rel, err := d.relation(r.uint32())
+		if err != nil {
+			return nil, 0, false, err
+		}
+		change := d.newChange(rel, OpInsert)
+		if r.byte() != 'N' {
+			return nil, 0, false, fmt.Errorf("malformed insert of %s", rel.name)
+		}
+		if change.After, err = d.tuple(r, rel); err != nil {
+			return nil, 0, false, err
+		}
+		d.changes = append(d.changes, change)
+	case 'U':
+		rel, err := d.relation(r.uint32())
+		if err != nil {
+			return nil, 0, false, err
+		}
+		change := d.newChange(rel, OpUpdate)
+		kind := r.byte()
+		if kind == 'K' || kind == 'O' {
+			if change.Before, err = d.tuple(r, rel); err != nil {
+				return nil, 0, false, err
+			}
+			kind = r.byte()
+		}
+		if kind != 'N' {
+			return nil, 0, false, fmt.Errorf("malformed update of %s", rel.name)
+		}
+		if change.After, err = d.tuple(r, rel); err != nil {
+			return nil, 0, false, err
+		}
+		d.changes = append(d.changes, change)
+	case 'D':
+		rel, err := d.relation(r.uint32())
+		if err != nil {
+			return nil, 0, false, err
+		}
+		change := d.newChange(rel, OpDelete)
+		r.byte() // 'K' or 'O'
+		if change.Before, err = d.tuple(r, rel); err != nil {
+			return nil, 0, false, err
+		}
+		d.changes = append(d.changes, change)
+	case 'T':
+		n := int(r.uint32())
+		r.byte() // options
+		for ; n > 0 && r.err == nil; n-- {
+			rel, err := d.relation(r.uint32())
+			if err != nil {
+				return nil, 0, false, err
+			}
+			d.changes = append(d.changes, d.newChange(rel, OpTruncate))
+		}
+	default:
+		// origins, types and logical messages don't change rows
+	}
+
+	return nil, 0, false, r.err
+}
+
+func (d *decoder) relation(relationID uint32) (relation, error) {
+	rel, ok := d.relations[relationID]
+	if !ok {
+		return rel, fmt.Errorf("unknown relation %d", relationID)
+	}
+	return rel, nil
+}
+
+func (d *decoder) newChange(rel relation, op Op) Change {
+	return Change{
+		Shard:       d.shard,
+		LSN:         d.lsn,
+		Seq:         len(d.changes),
+		XID:         d.xid,
+		Table:       rel.name,
+		Op:          op,
+		CommittedAt: d.committedAt,
+	}
+}
+
+// tuple reads the row, the unchanged TOASTed values are left out.
+func (d *decoder) tuple(r *reader, rel relation) (map[string]any, error) {
+	n := int(r.uint16())
+	if n > len(rel.columns) {
+		return nil, fmt.Errorf("%s has %d columns, got %d", rel.name, len(rel.columns), n)
+	}
+
+	row := make(map[string]any, n)
+	for i := 0; i < n && r.err == nil; i++ {
+		col := rel.columns[i]
+		switch r.byte() {
+		case 'n':
+			row[col.name] = nil
+		case 'u':
+		case 't':
+			value, err := d.value(col, r.bytes(int(r.uint32())))
+			if err != nil {
+				return nil, fmt.Errorf("failed to decode %s.%s: %w", rel.name, col.name, err)
+			}
+			row[col.name] = value
+		default:
+			return nil, fmt.Errorf("malformed row of %s", rel.name)
+		}
+	}
+
+	return row, r.err
+}
+
+func (d *decoder) value(col column, data []byte) (any, error) {
+	t, ok := d.typeMap.TypeForOID(col.typeOID)
+	if !ok {
+		return string(data), nil
+	}
+
+	value, err := t.Codec.DecodeValue(d.typeMap, col.typeOID, pgtype.TextFormatCode, data)
+	if err != nil {
+		return nil, err
+	}
+	if u, ok := value.([16]byte); ok {
+		return uuid.UUID(u).String(), nil
+	}
+	return value, nil
+}
+
+// reader reads the big endian fields of a message, after the first short read it returns zeros.
+type reader struct {
+	data []byte
+	err  error
+}
+
+func (r *reader) bytes(n int) []byte {
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(r.data) {
+		r.err = fmt.Errorf("unexpected end of message")
+		return nil
+	}
+	b := r.data[:n]
+	r.data = r.data[n:]
+	return b
+}
+
+func (r *reader) byte() byte {
+	if b := r.bytes(1); b != nil {
+		return b[0]
+	}
+	return 0
+}
+
+func (r *reader) uint16() uint16 {
+	if b := r.bytes(2); b != nil {
+		return binary.BigEndian.Uint16(b)
+	}
+	return 0
+}
+
+func (r *reader) uint32() uint32 {
+	if b := r.bytes(4); b != nil {
+		return binary.BigEndian.Uint32(b)
+	}
+	return 0
+}
+
+func (r *reader) uint64() uint64 {
+	if b := r.bytes(8); b != nil {
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+func (r *reader) string() string {
+	if r.err != nil {
+		return ""
+	}
+	for i, b := range r.data {
+		if b == 0 {
+			s := string(r.data[:i])
+			r.data = r.data[i+1:]
+			return s
+		}
+	}
+	r.err = fmt.Errorf("unterminated string")
+	return ""
+}
@@ -3,6 +3,9 @@ package config
 import (
 	"fmt"
 	"os"
+	"slices"
+	"time"
+	"usershards/internal/models"
 
 	"gopkg.in/yaml.v3"
 )
@@ -12,6 +15,107 @@ type Config struct {
 		UserShards  map[int]string `yaml:"user-shards"`  // Номер шарда -> адрес
 		EmailShards map[int]string `yaml:"email-shards"` // Номер шарда -> адрес
 	} `yaml:"db"`
+	Limits models.TransferLimits `yaml:"limits"` // Лимиты по умолчанию, переопределяются для пользователя
+	Fees   Fees                  `yaml:"fees"`
+	FX     FX                    `yaml:"fx"`
+
+	SystemAccounts map[models.SystemAccount]int `yaml:"system-accounts"` // Системный счет -> номер шарда
+	Campaigns      []models.Campaign            `yaml:"campaigns"`       // Бонусы новым пользователям, по порядку
+	Payments       Payments                     `yaml:"payments"`
+	Credit         Credit                       `yaml:"credit"`
+	Interest       Interest                     `yaml:"interest"`
+	Outbox         Outbox                       `yaml:"outbox"`
+	Webhooks       Webhooks                     `yaml:"webhooks"`
+	CDC            CDC                          `yaml:"cdc"`
+	Storage        Storage                      `yaml:"storage"`
+	Metrics        Metrics                      `yaml:"metrics"`
+	Tracing        Tracing                      `yaml:"tracing"`
+	Logging        Logging                      `yaml:"logging"`
+	Health         Health                       `yaml:"health"`
+}
+
+// Health настройки эндпоинтов /healthz и /readyz
+type Health struct {
+	Addr    string        `yaml:"addr"`    // Адрес эндпоинтов, пусто - не отдаются
+	Timeout time.Duration `yaml:"timeout"` // Сколько ждать всех проверок готовности
+}
+
+// Logging настройки логов сервиса
+type Logging struct {
+	Level  string `yaml:"level"`  // debug, info, warn или error, пусто - info
+	Format string `yaml:"format"` // json или console, пусто - json
+}
+
+// Tracing настройки экспорта трейсов OpenTelemetry
+type Tracing struct {
+	Exporter    string  `yaml:"exporter"`     // otlp, file или пусто - трейсы не пишутся
+	Endpoint    string  `yaml:"endpoint"`     // host:port коллектора для otlp по HTTP
+	Insecure    bool    `yaml:"insecure"`     // Отправлять в коллектор без TLS
+	File        string  `yaml:"file"`         // Куда писать спаны построчно в JSON для file, пусто - stdout
+	SampleRatio float64 `yaml:"sample-ratio"` // Доля записываемых новых трейсов, 0 - все
+}
+
+// Metrics настройки эндпоинта /metrics для Prometheus
+type Metrics struct {
+	Addr       string        `yaml:"addr"`        // Адрес эндпоинта, пусто - метрики не отдаются
+	StuckAfter time.Duration `yaml:"stuck-after"` // Сага, которая идет дольше, считается зависшей
+}
+
+// Storage режим хранения счетов
+type Storage struct {
+	Mode          models.StorageMode `yaml:"mode"`           // rows - только строки users, events - еще и поток account_events
+	SnapshotEvery int64              `yaml:"snapshot-every"` // Через сколько событий счета сохранять его снимок
+}
+
+// CDC настройки потока изменений users и transaction через логическую репликацию шардов
+type CDC struct {
+	Slot           string        `yaml:"slot"`            // Префикс слотов репликации, к нему добавляется номер шарда
+	Publication    string        `yaml:"publication"`     // Публикация на каждом шарде
+	File           string        `yaml:"file"`            // Куда писать изменения построчно в JSON, пусто - stdout
+	StatusInterval time.Duration `yaml:"status-interval"` // Как часто сообщать серверу обработанную позицию
+}
+
+// Webhooks настройки доставки событий на адреса интеграторов
+type Webhooks struct {
+	Timeout     time.Duration `yaml:"timeout"`      // Сколько ждать ответа на одну попытку
+	MaxAttempts int           `yaml:"max-attempts"` // Сколько раз пробовать доставить, между попытками пауза растет вдвое
+}
+
+// Outbox настройки публикации доменных событий из таблиц outbox шардов
+type Outbox struct {
+	File      string        `yaml:"file"`       // Куда писать события построчно в JSON, пусто - stdout
+	BatchSize int           `yaml:"batch-size"` // Сколько событий шарда публиковать за раз
+	Interval  time.Duration `yaml:"interval"`   // Как часто опрашивать шарды
+}
+
+// Credit настройки кредитных линий, лимит задается для каждого пользователя отдельно
+type Credit struct {
+	InterestBasisPoints int64 `yaml:"interest-basis-points"` // Годовая ставка на отрицательный баланс, 1 б.п. = 0.01%
+}
+
+// Payments настройки пополнений и выводов через платежного провайдера
+type Payments struct {
+	CallbackTimeout time.Duration `yaml:"callback-timeout"` // Сколько ждать ответа провайдера, потом платеж отменяется
+}
+
+// Interest настройки процентов на положительный баланс, проценты платит системный treasury
+type Interest struct {
+	Rates []models.InterestRate `yaml:"rates"` // Ставка первой ступени, в которую попал баланс; пусто - без процентов
+}
+
+// Fees настройки комиссий за переводы
+type Fees struct {
+	RevenueAccount   int64            `yaml:"revenue-account"`   // Пользователь для комиссий, по умолчанию системный fee-revenue
+	InternalAccounts []int64          `yaml:"internal-accounts"` // Переводы с этих счетов и на них без комиссии
+	Rules            []models.FeeRule `yaml:"rules"`             // Комиссии всех правил суммируются
+}
+
+// FX настройки обмена валют
+type FX struct {
+	RatesFile         string        `yaml:"rates-file"`          // Файл курсов для fx.StaticRateSource
+	SpreadBasisPoints int64         `yaml:"spread-basis-points"` // Спред к курсу ЦБ, 1 б.п. = 0.01%
+	QuoteTTL          time.Duration `yaml:"quote-ttl"`           // Сколько действует зафиксированный курс
+	TreasuryAccount   int64         `yaml:"treasury-account"`    // Пользователь для спреда, по умолчанию системный treasury
 }
 
 func LoadConfig(path string) (*Config, error) {
@@ -24,5 +128,38 @@ func LoadConfig(path string) (*Config, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse config: %w", err)
 	}
+	if config.SystemAccounts == nil {
+		config.SystemAccounts = make(map[models.SystemAccount]int)
+	}
+	for _, account := range models.SystemAccounts {
+		// системные счета без настройки живут на нулевом шарде
+		shardID := config.SystemAccounts[account]
+		if _, ok := config.DB.UserShards[shardID]; !ok {
+			return nil, fmt.Errorf("system-accounts.%s: user shard %d not found", account, shardID)
+		}
+		config.SystemAccounts[account] = shardID
+	}
+	for account := range config.SystemAccounts {
+		if !slices.Contains(models.SystemAccounts, account) {
+			return nil, fmt.Errorf("system-accounts: unknown account %s", account)
+		}
+	}
+	switch config.Storage.Mode {
+	case "":
+		config.Storage.Mode = models.StorageModeRows
+	case models.StorageModeRows, models.StorageModeEvents:
+	default:
+		return nil, fmt.Errorf("storage.mode: unknown mode %s", config.Storage.Mode)
+	}
+	campaignIDs := make(map[string]struct{}, len(config.Campaigns))
+	for _, campaign := range config.Campaigns {
+		if campaign.ID == "" || campaign.Amount <= 0 {
+			return nil, fmt.Errorf("campaigns: id and positive amount are required")
+		}
+		if _, ok := campaignIDs[campaign.ID]; ok {
+			return nil, fmt.Errorf("campaigns: duplicate id %s", campaign.ID)
+		}
+		campaignIDs[campaign.ID] = struct{}{}
+	}
 	return config, nil
 }
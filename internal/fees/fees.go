@@ -0,0 +1,79 @@
+package fees
+
+import (
+	"usershards/internal/config"
+	"usershards/internal/models"
+)
+
+const basisPointsInWhole = 10_000
+
+// Calculator computes transfer fees from the configured rules.
+type Calculator struct {
+	rules            []models.FeeRule
+	revenueAccount   int64
+	internalAccounts map[int64]struct{}
+}
+
+func NewCalculator(conf config.Fees) *Calculator {
+	internalAccounts := make(map[int64]struct{}, len(conf.InternalAccounts)+1)
+	for _, accountID := range conf.InternalAccounts {
+		internalAccounts[accountID] = struct{}{}
+	}
+	// paying fees to ourselves makes no sense
+	internalAccounts[conf.RevenueAccount] = struct{}{}
+
+	return &Calculator{
+		rules:            conf.Rules,
+		revenueAccount:   conf.RevenueAccount,
+		internalAccounts: internalAccounts,
+	}
+}
+
+// Calculate returns the fee for the transfer. The fee is waived when either side is an internal account.
+func (c *Calculator) Calculate(from, to, amount int64) models.Fee {
+	fee := models.Fee{AccountID: c.revenueAccount}
+	if amount <= 0 || c.IsInternal(from) || c.IsInternal(to) {
+		return fee
+	}
+
+	for _, rule := range c.rules {
+		fee.Amount += ruleFee(rule, amount)
+	}
+
+	return fee
+}
+
+func (c *Calculator) IsInternal(accountID int64) bool {
+	_, ok := c.internalAccounts[accountID]
+	return ok
+}
+
+func ruleFee(rule models.FeeRule, amount int64) int64 {
+	switch rule.Type {
+	case models.FeeRuleTypeFlat:
+		return rule.Amount
+	case models.FeeRuleTypePercent:
+		return percentFee(rule, amount)
+	case models.FeeRuleTypeTiered:
+		for _, tier := range rule.Tiers {
+			if tier.UpTo == 0 || amount < tier.UpTo {
+				return ruleFee(tier.FeeRule, amount)
+			}
+		}
+	}
+
+	return 0
+}
+
+func percentFee(rule models.FeeRule, amount int64) int64 {
+	// round half up to the kopeck
+	fee := (amount*rule.BasisPoints + basisPointsInWhole/2) / basisPointsInWhole
+	if rule.Min > 0 && fee < rule.Min {
+		fee = rule.Min
+	}
+	if rule.Max > 0 && fee > rule.Max {
+		fee = rule.Max
+	}
+
+	return fee
+}
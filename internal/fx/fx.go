@@ -0,0 +1,77 @@
+package fx
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"usershards/internal/apperrors"
+	"usershards/internal/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+// RateSource provides mid-market exchange rates.
+type RateSource interface {
+	MidRate(ctx context.Context, from, to models.Currency) (models.Rate, error)
+}
+
+type pair struct {
+	from models.Currency
+	to   models.Currency
+}
+
+// StaticRateSource serves rates loaded once from a yaml file:
+//
+//	rates:
+//	  - from: USD
+//	    to: RUB
+//	    rate: "92.5"
+//
+// The opposite direction is derived when it is not listed.
+type StaticRateSource struct {
+	rates map[pair]models.Rate
+}
+
+func NewStaticRateSource(path string) (*StaticRateSource, error) {
+	file, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rates file: %w", err)
+	}
+
+	var content struct {
+		Rates []struct {
+			From models.Currency `yaml:"from"`
+			To   models.Currency `yaml:"to"`
+			Rate string          `yaml:"rate"`
+		} `yaml:"rates"`
+	}
+	if err = yaml.Unmarshal(file, &content); err != nil {
+		return nil, fmt.Errorf("failed to parse rates file: %w", err)
+	}
+
+	source := &StaticRateSource{rates: make(map[pair]models.Rate, len(content.Rates)*2)}
+	for _, r := range content.Rates {
+		if !r.From.Valid() || !r.To.Valid() {
+			return nil, fmt.Errorf("invalid currency pair %s/%s", r.From, r.To)
+		}
+		rate, err := models.ParseRate(r.Rate)
+		if err != nil {
+			return nil, err
+		}
+
+		source.rates[pair{from: r.From, to: r.To}] = rate
+		if _, ok := source.rates[pair{from: r.To, to: r.From}]; !ok {
+			source.rates[pair{from: r.To, to: r.From}] = rate.Inverse()
+		}
+	}
+
+	return source, nil
+}
+
+func (s *StaticRateSource) MidRate(_ context.Context, from, to models.Currency) (models.Rate, error) {
+	rate, ok := s.rates[pair{from: from, to: to}]
+	if !ok {
+		return 0, fmt.Errorf("%w: %s/%s", apperrors.ErrRateNotFound, from, to)
+	}
+	return rate, nil
+}
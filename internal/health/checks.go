@@ -0,0 +1,56 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"go.temporal.io/sdk/client"
+	"usershards/internal/shard"
+)
+
+// AddShards adds the checks of every shard pool of the manager and of the migrations of every shard.
+// The components are named like db.users.0 and migrations.users.0.
+func (c *Checker) AddShards(sm *shard.ShardManager) {
+	for shardID, pool := range sm.UserShards {
+		c.Add(fmt.Sprintf("db.users.%d", shardID), PoolCheck(pool))
+		c.Add(fmt.Sprintf("migrations.users.%d", shardID), MigrationsCheck(pool, len(shard.UserMigrations)))
+	}
+	for shardID, pool := range sm.EmailShards {
+		c.Add(fmt.Sprintf("db.emails.%d", shardID), PoolCheck(pool))
+		c.Add(fmt.Sprintf("migrations.emails.%d", shardID), MigrationsCheck(pool, len(shard.EmailMigrations)))
+	}
+}
+
+// PoolCheck fails when the pool can't get a working connection to its shard.
+func PoolCheck(pool *pgxpool.Pool) Check {
+	return func(ctx context.Context) error {
+		return pool.Ping(ctx)
+	}
+}
+
+// MigrationsCheck fails when the shard's schema is behind the expected version, so an instance
+// doesn't get traffic before its migrations. A newer schema is fine, the old instances of a rolling
+// deploy keep serving after the new ones have migrated the shard.
+func MigrationsCheck(pool *pgxpool.Pool, expected int) Check {
+	return func(ctx context.Context) error {
+		version, err := shard.MigrationVersion(ctx, pool)
+		if err != nil {
+			return err
+		}
+		if version < expected {
+			return fmt.Errorf("migration version %d, expected %d", version, expected)
+		}
+		return nil
+	}
+}
+
+// TemporalCheck fails when the Temporal server doesn't answer its health check.
+func TemporalCheck(temporalClient client.Client) Check {
+	return func(ctx context.Context) error {
+		_, err := temporalClient.CheckHealth(ctx, &client.CheckHealthRequest{})
+		if err != nil {
+			return fmt.Errorf("failed to check temporal health: %w", err)
+		}
+		return nil
+	}
+}
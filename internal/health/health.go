@@ -0,0 +1,131 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"net/http"
+	"sync"
+	"time"
+	"usershards/internal/logger"
+)
+
+const StatusOK = "ok"
+const StatusFail = "fail"
+
+const defaultTimeout = 5 * time.Second
+
+// Check tells whether a component works, an error makes the instance not ready.
+type Check func(ctx context.Context) error
+
+type ComponentReport struct {
+	Status   string `json:"status"`
+	Error    string `json:"error,omitempty"`
+	Duration string `json:"duration"`
+}
+
+// Report is the answer of /readyz, the instance is ready when all its components are.
+type Report struct {
+	Status     string                     `json:"status"`
+	Components map[string]ComponentReport `json:"components,omitempty"`
+}
+
+// Checker runs the checks of the components, all of them at once and each within the timeout.
+type Checker struct {
+	mu      sync.RWMutex
+	checks  map[string]Check
+	timeout time.Duration
+}
+
+func NewChecker(timeout time.Duration) *Checker {
+	if timeout <= 0 {
+		timeout = defaultTimeout
+	}
+	return &Checker{checks: make(map[string]Check), timeout: timeout}
+}
+
+// Add adds the check of the component, the check of the same name is replaced.
+func (c *Checker) Add(name string, check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks[name] = check
+}
+
+func (c *Checker) Check(ctx context.Context) Report {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	ctx, cancel := context.WithTimeout(ctx, c.timeout)
+	defer cancel()
+
+	report := Report{Status: StatusOK, Components: make(map[string]ComponentReport, len(c.checks))}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, check := range c.checks {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			start := time.Now()
+			err := check(ctx)
+			component := ComponentReport{Status: StatusOK, Duration: time.Since(start).String()}
+			if err != nil {
+				component.Status = StatusFail
+				component.Error = err.Error()
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			report.Components[name] = component
+			if err != nil {
+				report.Status = StatusFail
+			}
+		}()
+	}
+	wg.Wait()
+
+	return report
+}
+
+// Handler serves /healthz, which only tells that the process is alive, and /readyz, which is 503
+// with the failed components when any of them fails.
+func (c *Checker) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", func(w http.ResponseWriter, r *http.Request) {
+		writeJSON(w, http.StatusOK, Report{Status: StatusOK})
+	})
+	mux.HandleFunc("/readyz", func(w http.ResponseWriter, r *http.Request) {
+		report := c.Check(r.Context())
+		code := http.StatusOK
+		if report.Status != StatusOK {
+			code = http.StatusServiceUnavailable
+		}
+		writeJSON(w, code, report)
+	})
+	return mux
+}
+
+// Serve exposes /healthz and /readyz on addr in the background, an empty addr turns them off.
+func (c *Checker) Serve(addr string) *http.Server {
+	if addr == "" {
+		return nil
+	}
+
+	server := &http.Server{Addr: addr, Handler: c.Handler(), ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.L().Error("health server failed", zap.Error(err))
+		}
+	}()
+
+	return server
+}
+
+func writeJSON(w http.ResponseWriter, code int, report Report) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	if err := json.NewEncoder(w).Encode(report); err != nil {
+		logger.L().Error("failed to write health report", zap.Error(err))
+	}
+}
@@ -2,7 +2,9 @@ package id
 
 import (
 	"math/rand"
+	"strconv"
 	"time"
+	"usershards/internal/metrics"
 )
 
 // Начало отсчета времени (01.01.2024)
@@ -19,6 +21,7 @@ func GenerateUserID(shardID int) int64 {
 
 	// Генерируем случайный счетчик (20 бит)
 	counter := rand.Int63n(1 << 20) // 0 - 1048575
+	metrics.GeneratedIDs.WithLabelValues(strconv.Itoa(shardID)).Inc()
 
 	// Собираем 64-битный ID
 	return (now << 22) | (int64(shardID) << 20) | counter
@@ -38,3 +41,13 @@ func ParseUserID(userID int64) (time.Time, int, int64) {
 
 	return createdAt, int(shardID), counter
 }
+
+// SystemAccountID возвращает ID системного счета. Timestamp у него нулевой,
+// поэтому он не пересекается с ID пользователей
+func SystemAccountID(shardID int, number int64) int64 {
+	if shardID < 0 || shardID > 3 {
+		panic("Shard ID должен быть от 0 до 3")
+	}
+
+	return (int64(shardID) << 20) | number
+}
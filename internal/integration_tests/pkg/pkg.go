@@ -7,10 +7,16 @@ import (
 	"usershards/internal/saga"
 
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
 	"usershards/internal/config"
+	"usershards/internal/fx"
 	"usershards/internal/logger"
+	"usershards/internal/outbox"
+	"usershards/internal/payments"
 	"usershards/internal/services"
 	"usershards/internal/shard"
+	"usershards/internal/tracing"
 )
 
 // TestDeps хранит зависимости для тестов
@@ -19,22 +25,35 @@ type TestDeps struct {
 	TemporalClient client.Client
 	UserService    *services.UserService
 	UserSaga       *saga.UserSagaWorkflow
+	Payments       *payments.FakeProvider
+	Events         *outbox.MemorySink
+	Outbox         *outbox.Relay // not running, the tests publish by Outbox.PublishPending
+	UserWorker     *saga.Worker
+	TransferWorker *saga.Worker
 }
 
 type Setup struct {
 	SetupUserService func(shardManager *shard.ShardManager) *services.UserService
+	Logger           *zap.Logger               // replaces the logger from the config, the workers log to it too
+	Config           func(conf *config.Config) // changes the config loaded from config.yaml for the test
 }
 
 // SetupTest инициализирует зависимости для тестирования
 func SetupTest(t *testing.T, setupSet Setup) *TestDeps {
 	t.Helper()
-	logger.InitLogger()
-
 	const configPath = "../pkg/config.yaml"
 	conf, err := config.LoadConfig(configPath)
 	if err != nil {
 		t.Fatalf("failed to load config: %v", err)
 	}
+	if setupSet.Config != nil {
+		setupSet.Config(conf)
+	}
+	if setupSet.Logger != nil {
+		logger.Set(setupSet.Logger)
+	} else if err := logger.Init(conf.Logging); err != nil {
+		t.Fatalf("failed to init logger: %v", err)
+	}
 
 	ctx := context.Background()
 
@@ -50,17 +69,38 @@ func SetupTest(t *testing.T, setupSet Setup) *TestDeps {
 	}
 
 	// Initialize Temporal client
-	temporalClient, err := client.Dial(client.Options{})
+	interceptors, err := tracing.ClientInterceptors()
+	if err != nil {
+		t.Fatalf("failed to create tracing interceptors: %v", err)
+	}
+	temporalClient, err := client.Dial(client.Options{
+		Interceptors:       interceptors,
+		ContextPropagators: []workflow.ContextPropagator{logger.NewContextPropagator()},
+		Logger:             logger.NewTemporalLogger(logger.L()),
+	})
 	if err != nil {
 		t.Fatal("Unable to create Temporal client", err)
 	}
-	logger.Logger.Info("Connected to Temporal successfully")
+	logger.L().Info("Connected to Temporal successfully")
 
-	userService := services.NewUserService(shardManager)
-	userSaga := saga.NewUserSagaWorkflow(userService, temporalClient)
+	rates, err := fx.NewStaticRateSource(conf.FX.RatesFile)
+	if err != nil {
+		t.Fatalf("failed to load rates: %v", err)
+	}
+
+	userService := services.NewUserService(shardManager, conf, rates)
+	if err := userService.EnsureSystemAccounts(ctx); err != nil {
+		t.Fatalf("failed to create system accounts: %v", err)
+	}
+	paymentProvider := payments.NewFakeProvider()
+	userSaga := saga.NewUserSagaWorkflow(userService, temporalClient, paymentProvider)
 
 	w1, w2 := saga.NewWorker(temporalClient, userSaga)
 
+	events := outbox.NewMemorySink()
+	sink := outbox.NewFanoutSink(events, outbox.SinkFunc(userSaga.DispatchWebhooks))
+	relay := outbox.NewRelay(shardManager, sink, conf.Outbox.BatchSize, conf.Outbox.Interval)
+
 	// Очищаем ресурсы после теста
 	t.Cleanup(func() {
 		t.Log("Cleaning up temporal client")
@@ -78,5 +118,13 @@ func SetupTest(t *testing.T, setupSet Setup) *TestDeps {
 		TemporalClient: temporalClient,
 		UserService:    userService,
 		UserSaga:       userSaga,
+		Payments:       paymentProvider,
+		Events:         events,
+		Outbox:         relay,
+		UserWorker:     w1,
+		TransferWorker: w2,
 	}
 }
+
+// WelcomeBonus is paid by the welcome campaign from config.yaml to every phone outside of +7999.
+const WelcomeBonus = 1000_00
@@ -0,0 +1,76 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"usershards/internal/config"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+)
+
+func TestAccountEvents(t *testing.T) {
+	// the shared config keeps the rows, the streams are written in the events mode only
+	deps := pkg.SetupTest(t, pkg.Setup{
+		Config: func(conf *config.Config) {
+			conf.Storage = config.Storage{Mode: models.StorageModeEvents, SnapshotEvery: 3}
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2 and transfer from user1 to user2 twice
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79134001111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79134001112", "test2@test.ru")
+	require.NoError(t, err)
+
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, 10_00))
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, 20_00))
+
+	// step 2: the streams start with the opening and the projections match the rows
+	for _, userID := range []int64{userID1, userID2, deps.UserService.SystemAccount(models.SystemAccountFeeRevenue)} {
+		user, err := deps.UserService.GetUserByID(ctx, userID)
+		require.NoError(t, err)
+
+		state, err := deps.UserService.GetAccountState(ctx, userID)
+		require.NoError(t, err)
+		require.Equal(t, user.Balance, state.Balances[models.DefaultCurrency])
+		require.False(t, state.IsBlocked)
+
+		balances, err := deps.UserService.GetBalances(ctx, userID)
+		require.NoError(t, err)
+		require.Equal(t, models.NewMoney(user.Balance, models.DefaultCurrency), balances[0])
+
+		events, err := deps.UserService.ListAccountEvents(ctx, userID, 0, 0)
+		require.NoError(t, err)
+		require.Equal(t, models.AccountEventTypeOpened, events[0].Type)
+		require.Equal(t, int64(len(events)), state.Version)
+	}
+
+	events, err := deps.UserService.ListAccountEvents(ctx, userID2, 0, 0)
+	require.NoError(t, err)
+	require.Equal(t, []models.AccountEventType{
+		models.AccountEventTypeOpened,
+		models.AccountEventTypeCredited,
+		models.AccountEventTypeCredited,
+		models.AccountEventTypeCredited,
+	}, []models.AccountEventType{events[0].Type, events[1].Type, events[2].Type, events[3].Type})
+	require.Equal(t, int64(20_00), events[3].Amount)
+
+	// step 3: blocking user1 is in its stream
+	require.NoError(t, deps.UserService.MarkUserAsBlocked(ctx, userID1))
+
+	state, err := deps.UserService.GetAccountState(ctx, userID1)
+	require.NoError(t, err)
+	require.True(t, state.IsBlocked)
+
+	// step 4: the state replayed from the first event is the same as the one from the snapshot
+	rebuilt, err := deps.UserService.RebuildAccountState(ctx, userID1)
+	require.NoError(t, err)
+	require.Equal(t, state.Version, rebuilt.Version)
+	require.Equal(t, state.Balances, rebuilt.Balances)
+	require.Equal(t, state.IsBlocked, rebuilt.IsBlocked)
+}
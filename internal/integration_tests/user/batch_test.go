@@ -0,0 +1,110 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+	"usershards/internal/saga"
+)
+
+func TestBatchTransfer(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create the sender and recipients, one of them is blocked
+	senderID, err := deps.UserSaga.CreateUser(ctx, "+79133971110", "sender@test.ru")
+	require.NoError(t, err)
+
+	const recipientsCount = 5
+	items := make([]saga.BatchItem, 0, recipientsCount)
+	for i := range recipientsCount {
+		userID, err := deps.UserSaga.CreateUser(ctx, fmt.Sprintf("+7913397112%d", i), fmt.Sprintf("test%d@test.ru", i))
+		require.NoError(t, err)
+		items = append(items, saga.BatchItem{To: userID, Amount: 10_00})
+	}
+	require.NoError(t, deps.UserService.MarkUserAsBlocked(ctx, items[2].To))
+
+	// step 2: pay everybody two at a time
+	report, err := deps.UserSaga.BatchTransfer(ctx, senderID, items, 2)
+	require.NoError(t, err)
+	require.Equal(t, int64(recipientsCount*10_00), report.Total)
+	require.Equal(t, recipientsCount-1, report.Completed)
+	require.Equal(t, 1, report.Failed)
+	require.Equal(t, saga.BatchItemStatusFailed, report.Items[2].Status)
+	require.NotEmpty(t, report.Items[2].Error)
+
+	// step 3: the sender paid the completed items only and nothing stays on hold
+	sender, err := deps.UserService.GetUserByID(ctx, senderID)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus-(recipientsCount-1)*10_00), sender.Balance)
+	require.Equal(t, sender.Balance, sender.AvailableBalance)
+
+	recipient, err := deps.UserService.GetUserByID(ctx, items[0].To)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus+10_00), recipient.Balance)
+}
+
+func TestBatchTransfer_InsufficientFunds(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create the sender and the recipient
+	senderID, err := deps.UserSaga.CreateUser(ctx, "+79133971110", "sender@test.ru")
+	require.NoError(t, err)
+
+	recipientID, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	// step 2: the total is more than the sender has, so nothing is paid
+	items := []saga.BatchItem{
+		{To: recipientID, Amount: pkg.WelcomeBonus},
+		{To: recipientID, Amount: 1_00},
+	}
+	_, err = deps.UserSaga.BatchTransfer(ctx, senderID, items, 0)
+	require.Error(t, err)
+
+	recipient, err := deps.UserService.GetUserByID(ctx, recipientID)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus), recipient.Balance)
+}
+
+func TestBatchTransfer_LimitExceeded(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create the sender with limits and the recipient
+	senderID, err := deps.UserSaga.CreateUser(ctx, "+79133971110", "sender@test.ru")
+	require.NoError(t, err)
+
+	recipientID, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	require.NoError(t, deps.UserService.SetUserLimits(ctx, senderID, models.TransferLimits{Single: 50_00, Daily: 80_00}))
+
+	// step 2: every item is checked against the limits, the item over the single limit and the one
+	// over the daily limit fail
+	items := []saga.BatchItem{
+		{To: recipientID, Amount: 60_00},
+		{To: recipientID, Amount: 50_00},
+		{To: recipientID, Amount: 40_00},
+	}
+	report, err := deps.UserSaga.BatchTransfer(ctx, senderID, items, 1)
+	require.NoError(t, err)
+	require.Equal(t, 1, report.Completed)
+	require.Equal(t, 2, report.Failed)
+	require.Equal(t, saga.BatchItemStatusFailed, report.Items[0].Status)
+	require.Equal(t, saga.BatchItemStatusCompleted, report.Items[1].Status)
+	require.Equal(t, saga.BatchItemStatusFailed, report.Items[2].Status)
+
+	sender, err := deps.UserService.GetUserByID(ctx, senderID)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus-50_00), sender.Balance)
+	require.Equal(t, sender.Balance, sender.AvailableBalance)
+}
@@ -0,0 +1,46 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"sync"
+	"testing"
+	"time"
+	"usershards/internal/integration_tests/pkg"
+)
+
+func TestCampaigns_BudgetIsNotExceeded(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create users targeted by the limited campaign concurrently,
+	// its budget covers two bonuses of 500 rubles
+	const usersCount = 4
+	userIDs := make([]int64, usersCount)
+	errs := make([]error, usersCount)
+	wg := sync.WaitGroup{}
+	for i := range usersCount {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			userIDs[i], errs[i] = deps.UserSaga.CreateUser(ctx, fmt.Sprintf("+7999000000%d", i), fmt.Sprintf("test%d@test.ru", i))
+		}()
+	}
+	wg.Wait()
+
+	// step 2: two users got the limited bonus and the others fell back to the welcome one
+	bonuses := make(map[int64]int)
+	for i := range usersCount {
+		require.NoError(t, errs[i])
+		user, err := deps.UserService.GetUserByID(ctx, userIDs[i])
+		require.NoError(t, err)
+		bonuses[user.Balance]++
+	}
+	require.Equal(t, map[int64]int{500_00: 2, pkg.WelcomeBonus: 2}, bonuses)
+
+	spent, err := deps.UserService.GetCampaignSpent(ctx, "limited")
+	require.NoError(t, err)
+	require.Equal(t, int64(1000_00), spent)
+}
@@ -0,0 +1,124 @@
+package user
+
+import (
+	"context"
+	"fmt"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"usershards/internal/cdc"
+	"usershards/internal/config"
+	"usershards/internal/id"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+)
+
+func TestCDC(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	conf := config.CDC{Slot: "usershards_cdc_test", StatusInterval: time.Second}
+	start := func(sink cdc.Sink) (*cdc.Consumer, func()) {
+		consumer := cdc.NewConsumer(deps.ShardManager, sink, conf)
+		runCtx, stop := context.WithCancel(ctx)
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			consumer.Run(runCtx)
+		}()
+		return consumer, func() {
+			stop()
+			<-done
+		}
+	}
+
+	// step 1: create the replication slots, the changes are captured from now on
+	consumer := cdc.NewConsumer(deps.ShardManager, cdc.NewMemorySink(), conf)
+	require.NoError(t, consumer.Setup(ctx))
+	t.Cleanup(func() {
+		require.NoError(t, consumer.Drop(context.Background()))
+	})
+
+	// step 2: create user1 and user2 and transfer from user1 to user2
+	sink := cdc.NewMemorySink()
+	_, stop := start(sink)
+
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133991111", "test1@test.ru")
+	require.NoError(t, err)
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133991112", "test2@test.ru")
+	require.NoError(t, err)
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, 10_00))
+
+	transfers := func(sink *cdc.MemorySink) []cdc.Change {
+		var changes []cdc.Change
+		for _, change := range sink.Changes("transaction") {
+			if change.Op == cdc.OpInsert && change.After["from_id"] == userID1 && change.After["to_id"] == userID2 {
+				changes = append(changes, change)
+			}
+		}
+		return changes
+	}
+
+	// step 3: the inserted user and the transfer rows are streamed with their values
+	require.Eventually(t, func() bool {
+		return len(transfers(sink)) > 0
+	}, 10*time.Second, 100*time.Millisecond)
+
+	var inserted, updated bool
+	for _, change := range sink.Changes("users") {
+		if change.After["id"] != userID1 {
+			continue
+		}
+		switch change.Op {
+		case cdc.OpInsert:
+			inserted = true
+		case cdc.OpUpdate:
+			// the old row is there as the tables have the full replica identity
+			require.Equal(t, userID1, change.Before["id"])
+			updated = true
+		}
+	}
+	require.True(t, inserted)
+	require.True(t, updated)
+
+	// step 4: the consumer stops after the checkpoint of user1's shard
+	stop()
+	_, shardID, _ := id.ParseUserID(userID1)
+	checkpoint, err := consumer.Checkpoint(ctx, shardID)
+	require.NoError(t, err)
+	require.NotZero(t, checkpoint)
+
+	// step 5: the restarted consumer goes on from the checkpoint with the transfer made while it was stopped
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, 20_00))
+
+	resumed := cdc.NewMemorySink()
+	_, stop = start(resumed)
+	defer stop()
+
+	require.Eventually(t, func() bool {
+		return len(transfers(resumed)) > 0
+	}, 10*time.Second, 100*time.Millisecond)
+
+	for _, change := range resumed.Changes("") {
+		if change.Shard == shardID {
+			require.GreaterOrEqual(t, change.LSN, checkpoint)
+		}
+	}
+	for _, change := range transfers(resumed) {
+		require.Equal(t, int32(20_00), change.After["amount"])
+	}
+
+	// step 6: the changes of the other tables aren't published, but the slot lets their WAL go all the same
+	require.NoError(t, deps.UserService.SetUserLimits(ctx, userID1, models.TransferLimits{Single: 100_00}))
+	userDB := deps.ShardManager.UserShards[shardID]
+	var walLSN string
+	require.NoError(t, userDB.QueryRow(ctx, `SELECT pg_current_wal_lsn()::text`).Scan(&walLSN))
+
+	require.Eventually(t, func() bool {
+		var flushed bool
+		const query = `SELECT confirmed_flush_lsn >= $1::pg_lsn FROM pg_replication_slots WHERE slot_name = $2`
+		err := userDB.QueryRow(ctx, query, walLSN, fmt.Sprintf("%s_%d", conf.Slot, shardID)).Scan(&flushed)
+		return err == nil && flushed
+	}, 15*time.Second, 100*time.Millisecond)
+}
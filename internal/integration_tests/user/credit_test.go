@@ -0,0 +1,84 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+)
+
+func TestCreditLine(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 with a credit line and user2
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	const creditLimit = 100_00
+	require.NoError(t, deps.UserService.SetCreditLimit(ctx, userID1, creditLimit))
+
+	user1, err := deps.UserService.GetUserByID(ctx, userID1)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus+creditLimit), user1.AvailableBalance)
+
+	// step 2: the balance goes below zero within the limit, but not beyond it
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, pkg.WelcomeBonus+50_00))
+	require.Error(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, 60_00))
+
+	user1, err = deps.UserService.GetUserByID(ctx, userID1)
+	require.NoError(t, err)
+	require.Equal(t, int64(-50_00), user1.Balance)
+
+	// step 3: the daily interest is charged once a day and credited to the treasury,
+	// 36.5% a year of 50.00 is 0.05 a day
+	date := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, deps.UserSaga.ChargeCreditInterest(ctx, date))
+	require.NoError(t, deps.UserSaga.ChargeCreditInterest(ctx, date))
+
+	user1, err = deps.UserService.GetUserByID(ctx, userID1)
+	require.NoError(t, err)
+	require.Equal(t, int64(-50_05), user1.Balance)
+
+	treasury, err := deps.UserService.GetUserByID(ctx, deps.UserService.SystemAccount(models.SystemAccountTreasury))
+	require.NoError(t, err)
+	require.Equal(t, int64(5), treasury.Balance)
+
+	page, err := deps.UserService.ListTransactions(ctx, userID1, "", 1, models.TransactionFilter{})
+	require.NoError(t, err)
+	require.Len(t, page.Transactions, 1)
+	require.Equal(t, models.TransactionTypeInterestCharge, page.Transactions[0].Type)
+
+	// step 4: user2 goes below zero later the same day, the next run charges them and settles
+	// their charge too, 36.5% a year of 30.00 is 0.03 a day
+	require.NoError(t, deps.UserService.SetCreditLimit(ctx, userID2, creditLimit))
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID2, userID1, 2*pkg.WelcomeBonus+50_00+30_00))
+	require.NoError(t, deps.UserSaga.ChargeCreditInterest(ctx, date))
+
+	user2, err := deps.UserService.GetUserByID(ctx, userID2)
+	require.NoError(t, err)
+	require.Equal(t, int64(-30_03), user2.Balance)
+
+	treasury, err = deps.UserService.GetUserByID(ctx, deps.UserService.SystemAccount(models.SystemAccountTreasury))
+	require.NoError(t, err)
+	require.Equal(t, int64(8), treasury.Balance)
+
+	// step 5: user2 has no credit line anymore, the debt doesn't bear interest the next day
+	require.NoError(t, deps.UserService.SetCreditLimit(ctx, userID2, 0))
+	require.NoError(t, deps.UserSaga.ChargeCreditInterest(ctx, date.AddDate(0, 0, 1)))
+
+	user2, err = deps.UserService.GetUserByID(ctx, userID2)
+	require.NoError(t, err)
+	require.Equal(t, int64(-30_03), user2.Balance)
+
+	treasury, err = deps.UserService.GetUserByID(ctx, deps.UserService.SystemAccount(models.SystemAccountTreasury))
+	require.NoError(t, err)
+	require.Equal(t, int64(8), treasury.Balance)
+	requireLedgerBalanced(t, ctx, deps)
+}
@@ -0,0 +1,57 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"math"
+	"testing"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+)
+
+func TestTransferCurrency(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2 with some money
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	// step 2: different currencies without a rate are rejected before the saga starts
+	err = deps.UserSaga.TransferCurrency(ctx, userID1, userID2, models.NewMoney(100_00, models.CurrencyRUB),
+		models.CurrencyUSD, 0)
+	require.ErrorIs(t, err, apperrors.ErrCurrencyMismatch)
+
+	// step 3: user1 has no dollars
+	err = deps.UserSaga.TransferCurrency(ctx, userID1, userID2, models.NewMoney(1_00, models.CurrencyUSD),
+		models.CurrencyUSD, 0)
+	require.Error(t, err)
+
+	// step 4: 100 rubles become 1.10 dollars on a new USD account of user2
+	const rate = models.Rate(11_000) // 0.011
+	err = deps.UserSaga.TransferCurrency(ctx, userID1, userID2, models.NewMoney(100_00, models.CurrencyRUB),
+		models.CurrencyUSD, rate)
+	require.NoError(t, err)
+
+	balances, err := deps.UserService.GetBalances(ctx, userID1)
+	require.NoError(t, err)
+	require.Equal(t, []models.Money{models.NewMoney(pkg.WelcomeBonus-100_00, models.CurrencyRUB)}, balances)
+
+	balances, err = deps.UserService.GetBalances(ctx, userID2)
+	require.NoError(t, err)
+	require.Equal(t, []models.Money{
+		models.NewMoney(pkg.WelcomeBonus, models.CurrencyRUB),
+		models.NewMoney(1_10, models.CurrencyUSD),
+	}, balances)
+
+	// step 5: the converted amount which doesn't fit in int64 is rejected before the saga starts
+	err = deps.UserSaga.TransferCurrency(ctx, userID1, userID2, models.NewMoney(100_00, models.CurrencyRUB),
+		models.CurrencyUSD, models.Rate(math.MaxInt64))
+	require.ErrorIs(t, err, apperrors.ErrAmountOverflow)
+}
@@ -0,0 +1,107 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+)
+
+func TestEscrow_ReleaseAndDispute(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create buyer and seller with some money
+	buyerID, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	sellerID, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	// step 2: the escrow debits the buyer, the seller is not paid yet
+	const amount = 100_00
+	escrowID, err := deps.UserSaga.Escrow(ctx, buyerID, sellerID, amount, time.Minute)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		status, err := deps.UserSaga.GetEscrowStatus(ctx, escrowID)
+		return err == nil && status == models.EscrowStatusHeld
+	}, time.Second*10, time.Millisecond*200)
+
+	buyer, err := deps.UserService.GetUserByID(ctx, buyerID)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus-amount), buyer.Balance)
+
+	// step 3: only the buyer can release the escrow, release pays the seller
+	_, err = deps.UserSaga.ReleaseEscrow(ctx, escrowID, sellerID)
+	require.ErrorIs(t, err, apperrors.ErrEscrowNotFound)
+
+	status, err := deps.UserSaga.ReleaseEscrow(ctx, escrowID, buyerID)
+	require.NoError(t, err)
+	require.Equal(t, models.EscrowStatusReleased, status)
+
+	seller, err := deps.UserService.GetUserByID(ctx, sellerID)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus+amount), seller.Balance)
+
+	// step 4: only the parties can dispute the escrow, dispute returns the money to the buyer
+	escrowID, err = deps.UserSaga.Escrow(ctx, buyerID, sellerID, amount, time.Minute)
+	require.NoError(t, err)
+
+	otherID, err := deps.UserSaga.CreateUser(ctx, "+79133971113", "test3@test.ru")
+	require.NoError(t, err)
+	_, err = deps.UserSaga.DisputeEscrow(ctx, escrowID, otherID)
+	require.ErrorIs(t, err, apperrors.ErrEscrowNotFound)
+
+	status, err = deps.UserSaga.DisputeEscrow(ctx, escrowID, sellerID)
+	require.NoError(t, err)
+	require.Equal(t, models.EscrowStatusRefunded, status)
+
+	buyer, err = deps.UserService.GetUserByID(ctx, buyerID)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus-amount), buyer.Balance)
+
+	escrow, err := deps.UserService.GetUserByID(ctx, deps.UserService.SystemAccount(models.SystemAccountEscrow))
+	require.NoError(t, err)
+	require.Equal(t, int64(0), escrow.Balance)
+}
+
+func TestEscrow_Expire(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create buyer and seller
+	buyerID, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	sellerID, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	// step 2: the escrow which is not disputed in time is paid to the seller
+	const amount = 100_00
+	escrowID, err := deps.UserSaga.Escrow(ctx, buyerID, sellerID, amount, time.Second*3)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		status, err := deps.UserSaga.GetEscrowStatus(ctx, escrowID)
+		return err == nil && status == models.EscrowStatusReleased
+	}, time.Second*15, time.Millisecond*500)
+
+	seller, err := deps.UserService.GetUserByID(ctx, sellerID)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus+amount), seller.Balance)
+
+	// step 3: the buyer without money can't fund the escrow
+	escrowID, err = deps.UserSaga.Escrow(ctx, buyerID, sellerID, pkg.WelcomeBonus, time.Minute)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		status, err := deps.UserSaga.GetEscrowStatus(ctx, escrowID)
+		return err == nil && status == models.EscrowStatusFailed
+	}, time.Second*30, time.Millisecond*500)
+}
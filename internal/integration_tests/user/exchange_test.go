@@ -0,0 +1,68 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+)
+
+func TestExchange(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user with some money
+	treasuryAccount := deps.UserService.SystemAccount(models.SystemAccountTreasury)
+	userID, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	// step 2: quote 100 rubles to dollars, mid rate is 1/92.5 and the spread is 1%
+	quote, err := deps.UserService.QuoteFX(ctx, userID, models.NewMoney(100_00, models.CurrencyRUB), models.CurrencyUSD)
+	require.NoError(t, err)
+	require.Equal(t, models.NewMoney(1_07, models.CurrencyUSD), quote.Target)
+	require.Equal(t, models.NewMoney(1, models.CurrencyUSD), quote.Spread)
+
+	// step 3: exchange by the quote, the quote can't be used twice
+	require.NoError(t, deps.UserSaga.Exchange(ctx, userID, quote.ID))
+	require.Error(t, deps.UserSaga.Exchange(ctx, userID, quote.ID))
+
+	balances, err := deps.UserService.GetBalances(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, []models.Money{
+		models.NewMoney(pkg.WelcomeBonus-100_00, models.CurrencyRUB),
+		models.NewMoney(1_07, models.CurrencyUSD),
+	}, balances)
+
+	// step 4: the treasury took the rubles and paid the dollars at the mid rate, keeping the spread
+	balances, err = deps.UserService.GetBalances(ctx, treasuryAccount)
+	require.NoError(t, err)
+	require.Equal(t, []models.Money{
+		models.NewMoney(100_00, models.CurrencyRUB),
+		models.NewMoney(-1_07, models.CurrencyUSD),
+	}, balances)
+}
+
+func TestExchange_QuoteExpired(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user with some money
+	userID, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	// step 2: the quote lives 3 seconds in tests
+	quote, err := deps.UserService.QuoteFX(ctx, userID, models.NewMoney(100_00, models.CurrencyRUB), models.CurrencyUSD)
+	require.NoError(t, err)
+	time.Sleep(time.Second * 4)
+
+	// step 3: the saga rejects it and no money is moved
+	require.Error(t, deps.UserSaga.Exchange(ctx, userID, quote.ID))
+
+	balances, err := deps.UserService.GetBalances(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, []models.Money{models.NewMoney(pkg.WelcomeBonus, models.CurrencyRUB)}, balances)
+}
@@ -0,0 +1,135 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"usershards/internal/config"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+)
+
+func withFeeRules(rules ...models.FeeRule) pkg.Setup {
+	return pkg.Setup{
+		Config: func(conf *config.Config) {
+			conf.Fees.Rules = rules
+		},
+	}
+}
+
+func TestTransferFee_Percent(t *testing.T) {
+	// 1% of the amount, from 1 to 5 rubles
+	deps := pkg.SetupTest(t, withFeeRules(models.FeeRule{
+		Type:        models.FeeRuleTypePercent,
+		BasisPoints: 100,
+		Min:         1_00,
+		Max:         5_00,
+	}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2 with some money
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	// step 2: the fee is the percent of the amount, bounded by min and max
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, 200_00)) // 2 rubles
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, 50_00))  // 50 kopecks, min 1 ruble
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, 600_00)) // 6 rubles, max 5 rubles
+
+	// step 3: the sender pays the fees on top of the amounts and the fee revenue gets them
+	user1, err := deps.UserService.GetUserByID(ctx, userID1)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus-850_00-8_00), user1.Balance)
+
+	user2, err := deps.UserService.GetUserByID(ctx, userID2)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus+850_00), user2.Balance)
+
+	feeRevenue, err := deps.UserService.GetUserByID(ctx, deps.UserService.SystemAccount(models.SystemAccountFeeRevenue))
+	require.NoError(t, err)
+	require.Equal(t, int64(8_00), feeRevenue.Balance)
+	requireLedgerBalanced(t, ctx, deps)
+}
+
+func TestTransferFee_Flat(t *testing.T) {
+	deps := pkg.SetupTest(t, withFeeRules(models.FeeRule{Type: models.FeeRuleTypeFlat, Amount: 3_00}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2 with some money
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	// step 2: the fee doesn't depend on the amount
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, 10_00))
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, 500_00))
+
+	// step 3: the sender pays the fee for every transfer
+	user1, err := deps.UserService.GetUserByID(ctx, userID1)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus-510_00-2*3_00), user1.Balance)
+
+	feeRevenue, err := deps.UserService.GetUserByID(ctx, deps.UserService.SystemAccount(models.SystemAccountFeeRevenue))
+	require.NoError(t, err)
+	require.Equal(t, int64(2*3_00), feeRevenue.Balance)
+}
+
+func TestTransferFee_Waived(t *testing.T) {
+	deps := pkg.SetupTest(t, withFeeRules(models.FeeRule{Type: models.FeeRuleTypeFlat, Amount: 3_00}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user with some money
+	userID, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	// step 2: the transfers to the system accounts are free
+	treasury := deps.UserService.SystemAccount(models.SystemAccountTreasury)
+	require.Equal(t, int64(0), deps.UserService.CalculateTransferFee(userID, treasury,
+		models.NewMoney(10_00, models.DefaultCurrency)).Amount)
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID, treasury, 10_00))
+
+	// step 3: the sender pays the amount only
+	user, err := deps.UserService.GetUserByID(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus-10_00), user.Balance)
+
+	feeRevenue, err := deps.UserService.GetUserByID(ctx, deps.UserService.SystemAccount(models.SystemAccountFeeRevenue))
+	require.NoError(t, err)
+	require.Equal(t, int64(0), feeRevenue.Balance)
+}
+
+func TestTransferFee_ReversedOnCompensation(t *testing.T) {
+	deps := pkg.SetupTest(t, withFeeRules(models.FeeRule{Type: models.FeeRuleTypeFlat, Amount: 3_00}))
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2 with some money, block user2
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+	require.NoError(t, deps.UserService.MarkUserAsBlocked(ctx, userID2))
+
+	// step 2: the transfer fails after the fee is credited
+	require.Error(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, 10_00))
+
+	// step 3: the sender gets the amount and the fee back, the fee revenue returns the fee
+	user1, err := deps.UserService.GetUserByID(ctx, userID1)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus), user1.Balance)
+
+	feeRevenue, err := deps.UserService.GetUserByID(ctx, deps.UserService.SystemAccount(models.SystemAccountFeeRevenue))
+	require.NoError(t, err)
+	require.Equal(t, int64(0), feeRevenue.Balance)
+	requireLedgerBalanced(t, ctx, deps)
+}
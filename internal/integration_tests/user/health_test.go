@@ -0,0 +1,78 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"usershards/internal/health"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/shard"
+)
+
+func TestHealth(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	checker := health.NewChecker(5 * time.Second)
+	checker.AddShards(deps.ShardManager)
+	checker.Add("temporal", health.TemporalCheck(deps.TemporalClient))
+	checker.Add("worker.user", deps.UserWorker.Check)
+	checker.Add("worker.transfer", deps.TransferWorker.Check)
+
+	server := httptest.NewServer(checker.Handler())
+	defer server.Close()
+
+	get := func(path string) (int, health.Report) {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+path, nil)
+		require.NoError(t, err)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+
+		var report health.Report
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&report))
+		return resp.StatusCode, report
+	}
+
+	// step 1: the instance is alive and ready, every component is reported
+	code, report := get("/healthz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, health.StatusOK, report.Status)
+
+	code, report = get("/readyz")
+	require.Equal(t, http.StatusOK, code)
+	require.Equal(t, health.StatusOK, report.Status)
+	components := 2*(len(deps.ShardManager.UserShards)+len(deps.ShardManager.EmailShards)) + 3
+	require.Len(t, report.Components, components)
+	for name, component := range report.Components {
+		require.Equal(t, health.StatusOK, component.Status, name)
+	}
+	require.Contains(t, report.Components, "db.users.0")
+	require.Contains(t, report.Components, "migrations.emails.0")
+
+	// step 2: a stopped worker makes the instance not ready, the rest stays ok
+	deps.TransferWorker.Stop()
+
+	code, report = get("/readyz")
+	require.Equal(t, http.StatusServiceUnavailable, code)
+	require.Equal(t, health.StatusFail, report.Status)
+	require.Equal(t, health.StatusFail, report.Components["worker.transfer"].Status)
+	require.NotEmpty(t, report.Components["worker.transfer"].Error)
+	require.Equal(t, health.StatusOK, report.Components["worker.user"].Status)
+
+	// step 3: the process is still alive
+	code, _ = get("/healthz")
+	require.Equal(t, http.StatusOK, code)
+
+	// step 4: a schema ahead of the instance is fine, a schema behind it is not
+	pool := deps.ShardManager.UserShards[0]
+	expected := len(shard.UserMigrations)
+	require.NoError(t, health.MigrationsCheck(pool, expected)(ctx))
+	require.NoError(t, health.MigrationsCheck(pool, expected-1)(ctx))
+	require.Error(t, health.MigrationsCheck(pool, expected+1)(ctx))
+}
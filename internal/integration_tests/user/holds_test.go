@@ -0,0 +1,91 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+)
+
+func TestHoldFunds_Capture(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2 with some money
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	// step 2: hold money, it is not available anymore but still on the balance
+	const holdAmount = 100_00
+	holdID, err := deps.UserSaga.HoldFunds(ctx, userID1, holdAmount, time.Minute)
+	require.NoError(t, err)
+
+	user1, err := deps.UserService.GetUserByID(ctx, userID1)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus), user1.Balance)
+	require.Equal(t, int64(pkg.WelcomeBonus-holdAmount), user1.AvailableBalance)
+
+	// step 3: held money can't be spent by a transfer
+	err = deps.UserSaga.TransferMoney(ctx, userID1, userID2, pkg.WelcomeBonus)
+	require.Error(t, err)
+
+	// step 4: capture moves the held money to user2
+	err = deps.UserSaga.CaptureHold(ctx, holdID, userID2)
+	require.NoError(t, err)
+
+	user1, err = deps.UserService.GetUserByID(ctx, userID1)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus-holdAmount), user1.Balance)
+	require.Equal(t, int64(pkg.WelcomeBonus-holdAmount), user1.AvailableBalance)
+
+	user2, err := deps.UserService.GetUserByID(ctx, userID2)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus+holdAmount), user2.Balance)
+
+	transfer, err := deps.UserService.GetTransfer(ctx, holdID)
+	require.NoError(t, err)
+	require.Equal(t, models.TransferStatusCompleted, transfer.Status)
+}
+
+func TestHoldFunds_ReleaseAndExpire(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user
+	userID, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	// step 2: released hold returns money to available balance
+	const holdAmount = 100_00
+	holdID, err := deps.UserSaga.HoldFunds(ctx, userID, holdAmount, time.Minute)
+	require.NoError(t, err)
+	require.NoError(t, deps.UserSaga.ReleaseHold(ctx, holdID))
+
+	hold, err := deps.UserService.GetHold(ctx, holdID, userID)
+	require.NoError(t, err)
+	require.Equal(t, models.HoldStatusReleased, hold.Status)
+
+	// step 3: hold which is not captured in time is released by the timer
+	holdID, err = deps.UserSaga.HoldFunds(ctx, userID, holdAmount, time.Second*2)
+	require.NoError(t, err)
+	time.Sleep(time.Second * 5)
+
+	hold, err = deps.UserService.GetHold(ctx, holdID, userID)
+	require.NoError(t, err)
+	require.Equal(t, models.HoldStatusReleased, hold.Status)
+
+	user, err := deps.UserService.GetUserByID(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus), user.AvailableBalance)
+
+	// step 4: hold more than available
+	_, err = deps.UserSaga.HoldFunds(ctx, userID, pkg.WelcomeBonus+1, time.Minute)
+	require.Error(t, err)
+}
@@ -0,0 +1,64 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+)
+
+func TestAccrueDepositInterest(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2, user2 gives all the money to user1
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID2, userID1, pkg.WelcomeBonus))
+
+	// step 2: 2000.00 is in the second tier, 7.3% a year is 0.40 a day. The day is paid once
+	date := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, deps.UserSaga.AccrueDepositInterest(ctx, date))
+	require.NoError(t, deps.UserSaga.AccrueDepositInterest(ctx, date))
+
+	user1, err := deps.UserService.GetUserByID(ctx, userID1)
+	require.NoError(t, err)
+	require.Equal(t, int64(2*pkg.WelcomeBonus+40), user1.Balance)
+
+	user2, err := deps.UserService.GetUserByID(ctx, userID2)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), user2.Balance)
+
+	treasury, err := deps.UserService.GetUserByID(ctx, deps.UserService.SystemAccount(models.SystemAccountTreasury))
+	require.NoError(t, err)
+	require.Equal(t, int64(-40), treasury.Balance)
+
+	// step 3: the next day is paid on the new balance
+	require.NoError(t, deps.UserSaga.AccrueDepositInterest(ctx, date.AddDate(0, 0, 1)))
+
+	user1, err = deps.UserService.GetUserByID(ctx, userID1)
+	require.NoError(t, err)
+	require.Equal(t, int64(2*pkg.WelcomeBonus+80), user1.Balance)
+
+	// step 4: user3 joins later, the first day is run again for them and their payment is settled too,
+	// 1000.00 is in the first tier, 36.5% a year is 1.00 a day
+	userID3, err := deps.UserSaga.CreateUser(ctx, "+79133971113", "test3@test.ru")
+	require.NoError(t, err)
+	require.NoError(t, deps.UserSaga.AccrueDepositInterest(ctx, date))
+
+	user3, err := deps.UserService.GetUserByID(ctx, userID3)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus+1_00), user3.Balance)
+
+	treasury, err = deps.UserService.GetUserByID(ctx, deps.UserService.SystemAccount(models.SystemAccountTreasury))
+	require.NoError(t, err)
+	require.Equal(t, int64(-1_80), treasury.Balance)
+	requireLedgerBalanced(t, ctx, deps)
+}
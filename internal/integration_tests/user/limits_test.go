@@ -0,0 +1,86 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+)
+
+func TestTransferMoney_LimitExceeded(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2 with some money
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	// step 2: override limits of user1
+	limits := models.TransferLimits{Single: 50_00, Daily: 80_00}
+	require.NoError(t, deps.UserService.SetUserLimits(ctx, userID1, limits))
+
+	actual, err := deps.UserService.GetUserLimits(ctx, userID1)
+	require.NoError(t, err)
+	require.Equal(t, limits, actual)
+
+	// step 3: single limit
+	err = deps.UserSaga.TransferMoney(ctx, userID1, userID2, 60_00)
+	require.Error(t, err)
+
+	// step 4: daily limit
+	err = deps.UserSaga.TransferMoney(ctx, userID1, userID2, 50_00)
+	require.NoError(t, err)
+
+	err = deps.UserSaga.TransferMoney(ctx, userID1, userID2, 40_00)
+	require.Error(t, err)
+
+	// step 5: only the allowed transfer moved money
+	user1, err := deps.UserService.GetUserByID(ctx, userID1)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus-50_00), user1.Balance)
+
+	user2, err := deps.UserService.GetUserByID(ctx, userID2)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus+50_00), user2.Balance)
+}
+
+func TestHoldFunds_LimitExceeded(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2 with some money, user1 has limits
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	require.NoError(t, deps.UserService.SetUserLimits(ctx, userID1, models.TransferLimits{Single: 50_00, Daily: 80_00}))
+
+	// step 2: the hold is checked as the transfer it pays
+	_, err = deps.UserSaga.HoldFunds(ctx, userID1, 60_00, time.Minute)
+	require.ErrorIs(t, err, apperrors.ErrLimitExceeded)
+
+	// step 3: the held money counts against the daily limit until it is captured
+	holdID, err := deps.UserSaga.HoldFunds(ctx, userID1, 50_00, time.Minute)
+	require.NoError(t, err)
+
+	err = deps.UserSaga.TransferMoney(ctx, userID1, userID2, 40_00)
+	require.Error(t, err)
+
+	// step 4: the capture doesn't count twice
+	require.NoError(t, deps.UserSaga.CaptureHold(ctx, holdID, userID2))
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, 30_00))
+
+	user1, err := deps.UserService.GetUserByID(ctx, userID1)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus-80_00), user1.Balance)
+}
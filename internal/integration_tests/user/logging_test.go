@@ -0,0 +1,64 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+	"testing"
+	"time"
+	"usershards/internal/id"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/logger"
+)
+
+func TestLogging(t *testing.T) {
+	core, logs := observer.New(zap.DebugLevel)
+	deps := pkg.SetupTest(t, pkg.Setup{Logger: zap.New(core)})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2 and transfer from user1 to user2 within a request
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79134031111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79134031112", "test2@test.ru")
+	require.NoError(t, err)
+
+	const requestID = "test-request-1"
+	require.NoError(t, deps.UserSaga.TransferMoney(logger.WithRequestID(ctx, requestID), userID1, userID2, 10_00))
+
+	request := logs.FilterField(logger.RequestID(requestID))
+	one := func(message string) map[string]interface{} {
+		entries := request.FilterMessage(message).All()
+		require.NotEmpty(t, entries, message)
+		return entries[0].ContextMap()
+	}
+
+	// step 2: the workflow logs carry the request, the transfer and both users
+	workflowLog := one("TransferMoneyWorkflow start")
+	transferID, ok := workflowLog[logger.TransferIDKey].(string)
+	require.True(t, ok)
+	require.NotEmpty(t, transferID)
+	require.Equal(t, userID1, workflowLog[logger.UserIDKey])
+	require.Equal(t, userID2, workflowLog[logger.ToUserIDKey])
+
+	// step 3: the activity logs carry the same fields
+	activityLog := one("DecreaseMoney start")
+	require.Equal(t, transferID, activityLog[logger.TransferIDKey])
+	require.Equal(t, userID1, activityLog[logger.UserIDKey])
+
+	// step 4: the service logs carry the user and the shard of the account they change
+	_, fromShard, _ := id.ParseUserID(userID1)
+	_, toShard, _ := id.ParseUserID(userID2)
+
+	decreased := one("money decreased")
+	require.Equal(t, transferID, decreased[logger.TransferIDKey])
+	require.Equal(t, userID1, decreased[logger.UserIDKey])
+	require.Equal(t, int64(fromShard), decreased[logger.ShardIDKey])
+
+	increased := one("money increased")
+	require.Equal(t, transferID, increased[logger.TransferIDKey])
+	require.Equal(t, userID2, increased[logger.UserIDKey])
+	require.Equal(t, int64(toShard), increased[logger.ShardIDKey])
+}
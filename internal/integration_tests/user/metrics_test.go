@@ -0,0 +1,47 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2 and transfer from user1 to user2
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79134011111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79134011112", "test2@test.ru")
+	require.NoError(t, err)
+
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, 10_00))
+
+	// step 2: the scrape has the pools, the queries by method, the saga outcomes and the generated ids
+	server := httptest.NewServer(metrics.Handler())
+	defer server.Close()
+
+	resp, err := http.Get(server.URL)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+
+	require.Contains(t, string(body), `usershards_pool_acquired_connections{db="users",shard="0"}`)
+	require.Contains(t, string(body), `usershards_pool_acquire_wait_seconds_total{db="emails",shard="0"}`)
+	require.Contains(t, string(body), `method="DecreaseMoneyFromUser"`)
+	require.Contains(t, string(body), `method="IncreaseMoneyToUser"`)
+	require.Contains(t, string(body), `usershards_saga_outcomes_total{outcome="completed",workflow="TransferMoneyWorkflow"}`)
+	require.Contains(t, string(body), `usershards_saga_outcomes_total{outcome="completed",workflow="CreateUserWorkflow"}`)
+	require.Contains(t, string(body), `usershards_generated_ids_total{shard=`)
+}
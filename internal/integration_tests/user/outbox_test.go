@@ -0,0 +1,68 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+)
+
+func TestOutbox(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2 and transfer from user1 to user2
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	const transferAmount = 10_00
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, transferAmount))
+
+	// step 2: the relay publishes the events once
+	published, err := deps.Outbox.PublishPending(ctx)
+	require.NoError(t, err)
+	require.NotZero(t, published)
+
+	published, err = deps.Outbox.PublishPending(ctx)
+	require.NoError(t, err)
+	require.Zero(t, published)
+
+	// step 3: the events of each user go in the order they happened
+	types := func(events []models.Event) []models.EventType {
+		res := make([]models.EventType, 0, len(events))
+		for _, event := range events {
+			res = append(res, event.Type)
+		}
+		return res
+	}
+
+	events1 := deps.Events.Events(userID1)
+	require.Equal(t, []models.EventType{
+		models.EventTypeUserCreated,
+		models.EventTypeBalanceCredited,
+		models.EventTypeTransferCompleted,
+		models.EventTypeBalanceDebited,
+	}, types(events1))
+
+	events2 := deps.Events.Events(userID2)
+	require.Equal(t, []models.EventType{
+		models.EventTypeUserCreated,
+		models.EventTypeBalanceCredited,
+		models.EventTypeTransferCompleted,
+		models.EventTypeBalanceCredited,
+		models.EventTypeTransferCompleted,
+	}, types(events2))
+
+	var debited models.BalanceChangedEvent
+	require.NoError(t, json.Unmarshal(events1[3].Payload, &debited))
+	require.Equal(t, userID2, debited.CounterpartyID)
+	require.Equal(t, int64(transferAmount), debited.Amount)
+	require.Equal(t, int64(pkg.WelcomeBonus-transferAmount), debited.BalanceAfter)
+}
@@ -0,0 +1,203 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+	"usershards/internal/saga"
+)
+
+func TestDeposit(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user with some money
+	userID, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	// step 2: deposit is pending until the provider calls back
+	const depositAmount = 500_00
+	paymentID, err := deps.UserSaga.Deposit(ctx, userID, models.NewMoney(depositAmount, models.DefaultCurrency))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		_, ok := deps.Payments.Payment(paymentID)
+		return ok
+	}, time.Second*5, time.Millisecond*100)
+
+	payment, err := deps.UserService.GetPayment(ctx, paymentID, userID)
+	require.NoError(t, err)
+	require.Equal(t, models.PaymentStatusPending, payment.Status)
+
+	// step 3: the provider confirms the deposit and the money is credited
+	require.NoError(t, deps.UserSaga.PaymentCallback(ctx, paymentID, saga.PaymentCallback{Success: true}))
+
+	status, err := deps.UserSaga.WaitPayment(ctx, paymentID)
+	require.NoError(t, err)
+	require.Equal(t, models.PaymentStatusCompleted, status)
+
+	user, err := deps.UserService.GetUserByID(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus+depositAmount), user.Balance)
+
+	settlement, err := deps.UserService.GetUserByID(ctx, deps.UserService.SystemAccount(models.SystemAccountExternalSettlement))
+	require.NoError(t, err)
+	require.Equal(t, int64(-depositAmount), settlement.Balance)
+}
+
+func TestDeposit_RecipientBlocked(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user and block them
+	userID, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+	require.NoError(t, deps.UserService.MarkUserAsBlocked(ctx, userID))
+
+	// step 2: the provider confirms the deposit, but the money can't be credited
+	paymentID, err := deps.UserSaga.Deposit(ctx, userID, models.NewMoney(500_00, models.DefaultCurrency))
+	require.NoError(t, err)
+	require.NoError(t, deps.UserSaga.PaymentCallback(ctx, paymentID, saga.PaymentCallback{Success: true}))
+
+	status, err := deps.UserSaga.WaitPayment(ctx, paymentID)
+	require.NoError(t, err)
+	require.Equal(t, models.PaymentStatusFailed, status)
+
+	// step 3: the provider is asked to refund and the ledger is untouched
+	payment, ok := deps.Payments.Payment(paymentID)
+	require.True(t, ok)
+	require.True(t, payment.Refunded)
+
+	totals, err := deps.UserService.LedgerTotals(ctx)
+	require.NoError(t, err)
+	require.Equal(t, int64(0), totals[models.DefaultCurrency])
+}
+
+func TestWithdraw(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user with some money
+	userID, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	// step 2: the withdrawn money is held while the payout is pending
+	const withdrawAmount = 300_00
+	paymentID, err := deps.UserSaga.Withdraw(ctx, userID, models.NewMoney(withdrawAmount, models.DefaultCurrency))
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		user, err := deps.UserService.GetUserByID(ctx, userID)
+		return err == nil && user.AvailableBalance == pkg.WelcomeBonus-withdrawAmount
+	}, time.Second*5, time.Millisecond*100)
+
+	// step 3: the provider confirms the payout and the money is debited
+	require.NoError(t, deps.UserSaga.PaymentCallback(ctx, paymentID, saga.PaymentCallback{Success: true}))
+
+	status, err := deps.UserSaga.WaitPayment(ctx, paymentID)
+	require.NoError(t, err)
+	require.Equal(t, models.PaymentStatusCompleted, status)
+
+	user, err := deps.UserService.GetUserByID(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus-withdrawAmount), user.Balance)
+	require.Equal(t, int64(pkg.WelcomeBonus-withdrawAmount), user.AvailableBalance)
+}
+
+func TestWithdraw_ProviderFails(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user with some money
+	userID, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	// step 2: the provider fails the payout and the held money is released
+	paymentID, err := deps.UserSaga.Withdraw(ctx, userID, models.NewMoney(300_00, models.DefaultCurrency))
+	require.NoError(t, err)
+	require.NoError(t, deps.UserSaga.PaymentCallback(ctx, paymentID, saga.PaymentCallback{Reason: "card is closed"}))
+
+	status, err := deps.UserSaga.WaitPayment(ctx, paymentID)
+	require.NoError(t, err)
+	require.Equal(t, models.PaymentStatusFailed, status)
+
+	payment, err := deps.UserService.GetPayment(ctx, paymentID, userID)
+	require.NoError(t, err)
+	require.Equal(t, "card is closed", payment.Reason)
+
+	user, err := deps.UserService.GetUserByID(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus), user.Balance)
+	require.Equal(t, int64(pkg.WelcomeBonus), user.AvailableBalance)
+
+	// step 3: the provider declines the payouts of the user at once
+	deps.Payments.Decline(userID)
+	paymentID, err = deps.UserSaga.Withdraw(ctx, userID, models.NewMoney(300_00, models.DefaultCurrency))
+	require.NoError(t, err)
+
+	status, err = deps.UserSaga.WaitPayment(ctx, paymentID)
+	require.NoError(t, err)
+	require.Equal(t, models.PaymentStatusFailed, status)
+}
+
+func TestDeposit_CallbackTimeout(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user with some money
+	userID, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	// step 2: the provider doesn't call back in time, the deposit fails and is canceled at the provider
+	paymentID, err := deps.UserSaga.Deposit(ctx, userID, models.NewMoney(500_00, models.DefaultCurrency))
+	require.NoError(t, err)
+
+	status, err := deps.UserSaga.WaitPayment(ctx, paymentID)
+	require.NoError(t, err)
+	require.Equal(t, models.PaymentStatusFailed, status)
+
+	payment, ok := deps.Payments.Payment(paymentID)
+	require.True(t, ok)
+	require.True(t, payment.Canceled)
+
+	// step 3: the late success callback doesn't credit the user
+	require.NoError(t, deps.UserSaga.PaymentCallback(ctx, paymentID, saga.PaymentCallback{Success: true}))
+
+	user, err := deps.UserService.GetUserByID(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus), user.Balance)
+}
+
+func TestWithdraw_LimitExceeded(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user with some money and a single transfer limit
+	userID, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+	require.NoError(t, deps.UserService.SetUserLimits(ctx, userID, models.TransferLimits{Single: 100_00}))
+
+	// step 2: the withdrawal over the limit fails before the payout is requested
+	paymentID, err := deps.UserSaga.Withdraw(ctx, userID, models.NewMoney(300_00, models.DefaultCurrency))
+	require.NoError(t, err)
+
+	status, err := deps.UserSaga.WaitPayment(ctx, paymentID)
+	require.NoError(t, err)
+	require.Equal(t, models.PaymentStatusFailed, status)
+
+	_, ok := deps.Payments.Payment(paymentID)
+	require.False(t, ok)
+
+	user, err := deps.UserService.GetUserByID(ctx, userID)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus), user.AvailableBalance)
+}
@@ -0,0 +1,70 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+)
+
+func TestRefundTransfer(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2 with some money and transfer from user1 to user2
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	const transferAmount = 100_00
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, transferAmount))
+
+	page, err := deps.UserService.ListTransactions(ctx, userID1, "", 1, models.TransactionFilter{})
+	require.NoError(t, err)
+	require.Len(t, page.Transactions, 1)
+	transferID := page.Transactions[0].TransferID
+
+	// step 2: partial refund, repeated with the same key it is made once
+	refundID, err := deps.UserSaga.RefundTransfer(ctx, transferID, 30_00, "first")
+	require.NoError(t, err)
+
+	sameRefundID, err := deps.UserSaga.RefundTransfer(ctx, transferID, 30_00, "first")
+	require.NoError(t, err)
+	require.Equal(t, refundID, sameRefundID)
+
+	transfer, err := deps.UserService.GetTransfer(ctx, transferID)
+	require.NoError(t, err)
+	require.Equal(t, int64(30_00), transfer.Refunded)
+
+	refund, err := deps.UserService.GetTransfer(ctx, refundID)
+	require.NoError(t, err)
+	require.Equal(t, models.TransferStatusCompleted, refund.Status)
+	require.Equal(t, userID2, refund.FromID)
+	require.Equal(t, userID1, refund.ToID)
+
+	// step 3: refunds can't exceed the transfer
+	_, err = deps.UserSaga.RefundTransfer(ctx, transferID, transferAmount, "too-much")
+	require.Error(t, err)
+
+	// step 4: the rest is refunded even though the recipient is blocked
+	require.NoError(t, deps.UserService.MarkUserAsBlocked(ctx, userID2))
+	_, err = deps.UserSaga.RefundTransfer(ctx, transferID, 70_00, "rest")
+	require.NoError(t, err)
+
+	user1, err := deps.UserService.GetUserByID(ctx, userID1)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus), user1.Balance)
+
+	user2, err := deps.UserService.GetUserByID(ctx, userID2)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus), user2.Balance)
+
+	transfer, err = deps.UserService.GetTransfer(ctx, transferID)
+	require.NoError(t, err)
+	require.Equal(t, int64(transferAmount), transfer.Refunded)
+}
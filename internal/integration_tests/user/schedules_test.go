@@ -0,0 +1,121 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+)
+
+func TestScheduleTransfer(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2 with some money
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	// step 2: schedule the transfer in 2 seconds, nothing is moved yet
+	const transferAmount = 10_00
+	scheduleID, err := deps.UserSaga.ScheduleTransfer(ctx, userID1, userID2, transferAmount, time.Now().Add(time.Second*2))
+	require.NoError(t, err)
+
+	user2, err := deps.UserService.GetUserByID(ctx, userID2)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus), user2.Balance)
+
+	// step 3: the transfer is made and the schedule is completed
+	require.Eventually(t, func() bool {
+		schedule, err := deps.UserService.GetSchedule(ctx, scheduleID, userID1)
+		return err == nil && schedule.Status == models.ScheduleStatusCompleted
+	}, time.Second*10, time.Millisecond*200)
+
+	schedule, err := deps.UserService.GetSchedule(ctx, scheduleID, userID1)
+	require.NoError(t, err)
+	require.Equal(t, int64(1), schedule.Runs)
+	require.Empty(t, schedule.LastError)
+	require.True(t, schedule.NextRunAt.IsZero())
+
+	user2, err = deps.UserService.GetUserByID(ctx, userID2)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus+transferAmount), user2.Balance)
+}
+
+func TestRecurringTransfer_PauseAndCancel(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2 with some money
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	// step 2: the daily transfer starting now is made at once, the next run is tomorrow
+	scheduleID, err := deps.UserSaga.ScheduleRecurringTransfer(ctx, userID1, userID2, 10_00,
+		models.ScheduleKindDaily, "", time.Now())
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		schedule, err := deps.UserService.GetSchedule(ctx, scheduleID, userID1)
+		return err == nil && schedule.Runs == 1
+	}, time.Second*10, time.Millisecond*200)
+
+	// step 3: pause and cancel the schedule, only the sender can do it
+	require.ErrorIs(t, deps.UserSaga.PauseSchedule(ctx, scheduleID, userID2), apperrors.ErrScheduleNotFound)
+	require.ErrorIs(t, deps.UserSaga.CancelSchedule(ctx, scheduleID, userID2), apperrors.ErrScheduleNotFound)
+
+	require.NoError(t, deps.UserSaga.PauseSchedule(ctx, scheduleID, userID1))
+	require.Eventually(t, func() bool {
+		schedule, err := deps.UserService.GetSchedule(ctx, scheduleID, userID1)
+		return err == nil && schedule.Status == models.ScheduleStatusPaused
+	}, time.Second*10, time.Millisecond*200)
+
+	require.NoError(t, deps.UserSaga.CancelSchedule(ctx, scheduleID, userID1))
+	require.Eventually(t, func() bool {
+		schedule, err := deps.UserService.GetSchedule(ctx, scheduleID, userID1)
+		return err == nil && schedule.Status == models.ScheduleStatusCancelled
+	}, time.Second*10, time.Millisecond*200)
+
+	// step 4: the sender sees the schedule in the list
+	schedules, err := deps.UserService.ListSchedules(ctx, userID1)
+	require.NoError(t, err)
+	require.Len(t, schedules, 1)
+	require.Equal(t, scheduleID, schedules[0].ID)
+	require.Equal(t, int64(1), schedules[0].Runs)
+}
+
+func TestSchedule_MonthlyKeepsStartDay(t *testing.T) {
+	// step 1: the monthly schedule starts on the 31st
+	start := time.Date(2025, time.January, 31, 10, 0, 0, 0, time.UTC)
+	schedule := models.Schedule{Kind: models.ScheduleKindMonthly, StartAt: start, NextRunAt: start}
+
+	// step 2: the short months get their last day, the long ones get the 31st back
+	expected := []time.Time{
+		time.Date(2025, time.February, 28, 10, 0, 0, 0, time.UTC),
+		time.Date(2025, time.March, 31, 10, 0, 0, 0, time.UTC),
+		time.Date(2025, time.April, 30, 10, 0, 0, 0, time.UTC),
+		time.Date(2025, time.May, 31, 10, 0, 0, 0, time.UTC),
+	}
+	prev := start
+	for _, want := range expected {
+		next, err := schedule.NextRun(prev)
+		require.NoError(t, err)
+		require.Equal(t, want, next)
+		prev = next
+	}
+
+	// step 3: the missed runs are skipped from the start day too
+	next, err := schedule.NextRunAfter(time.Date(2025, time.June, 15, 0, 0, 0, 0, time.UTC))
+	require.NoError(t, err)
+	require.Equal(t, time.Date(2025, time.June, 30, 10, 0, 0, 0, time.UTC), next)
+}
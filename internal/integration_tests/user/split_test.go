@@ -0,0 +1,84 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+	"usershards/internal/saga"
+)
+
+func TestTransferSplit(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create the sender and two recipients
+	senderID, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	userID3, err := deps.UserSaga.CreateUser(ctx, "+79133971113", "test3@test.ru")
+	require.NoError(t, err)
+
+	// step 2: one debit pays both recipients
+	err = deps.UserSaga.TransferSplit(ctx, senderID, []saga.SplitPart{
+		{To: userID2, Amount: 10_00},
+		{To: userID3, Amount: 20_00},
+	})
+	require.NoError(t, err)
+
+	sender, err := deps.UserService.GetUserByID(ctx, senderID)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus-30_00), sender.Balance)
+
+	user2, err := deps.UserService.GetUserByID(ctx, userID2)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus+10_00), user2.Balance)
+
+	user3, err := deps.UserService.GetUserByID(ctx, userID3)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus+20_00), user3.Balance)
+
+	page, err := deps.UserService.ListTransactions(ctx, senderID, "", 1, models.TransactionFilter{})
+	require.NoError(t, err)
+	require.Len(t, page.Transactions, 1)
+
+	transfer, err := deps.UserService.GetTransfer(ctx, page.Transactions[0].TransferID)
+	require.NoError(t, err)
+	require.Equal(t, models.TransferStatusCompleted, transfer.Status)
+	require.Equal(t, int64(30_00), transfer.Amount)
+	require.Len(t, transfer.Entries, 3)
+
+	// step 3: the split transfer has no single recipient to refund it
+	_, err = deps.UserSaga.RefundTransfer(ctx, transfer.ID, 10_00, "refund-1")
+	require.ErrorIs(t, err, apperrors.ErrRefundNotAllowed)
+
+	// step 4: the blocked recipient fails the whole transfer, the credited recipient gives the money back
+	require.NoError(t, deps.UserService.MarkUserAsBlocked(ctx, userID3))
+	err = deps.UserSaga.TransferSplit(ctx, senderID, []saga.SplitPart{
+		{To: userID2, Amount: 10_00},
+		{To: userID3, Amount: 20_00},
+	})
+	require.Error(t, err)
+
+	sender, err = deps.UserService.GetUserByID(ctx, senderID)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus-30_00), sender.Balance)
+
+	user2, err = deps.UserService.GetUserByID(ctx, userID2)
+	require.NoError(t, err)
+	require.Equal(t, int64(pkg.WelcomeBonus+10_00), user2.Balance)
+
+	// step 5: the parts must not repeat a recipient
+	err = deps.UserSaga.TransferSplit(ctx, senderID, []saga.SplitPart{
+		{To: userID2, Amount: 10_00},
+		{To: userID2, Amount: 20_00},
+	})
+	require.Error(t, err)
+}
@@ -0,0 +1,90 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"usershards/internal/config"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+	"usershards/internal/saga"
+)
+
+func TestSystemAccounts_LedgerBalancesToZero(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{
+		Config: func(conf *config.Config) {
+			conf.Fees.Rules = []models.FeeRule{{Type: models.FeeRuleTypeFlat, Amount: 1_00}}
+		},
+	})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2, the welcome bonus comes from the bonus pool
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	bonusPool, err := deps.UserService.GetUserByID(ctx, deps.UserService.SystemAccount(models.SystemAccountBonusPool))
+	require.NoError(t, err)
+	require.Equal(t, int64(-2*pkg.WelcomeBonus), bonusPool.Balance)
+
+	// step 2: move some money around, the fee goes to the fee revenue
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, 10_00))
+	requireLedgerBalanced(t, ctx, deps)
+
+	// step 3: exchange rubles to dollars, the treasury takes the other side
+	quote, err := deps.UserService.QuoteFX(ctx, userID2, models.NewMoney(100_00, models.CurrencyRUB), models.CurrencyUSD)
+	require.NoError(t, err)
+	require.NoError(t, deps.UserSaga.Exchange(ctx, userID2, quote.ID))
+	requireLedgerBalanced(t, ctx, deps)
+
+	// step 4: transfer rubles which user2 gets in dollars, the treasury converts them
+	require.NoError(t, deps.UserSaga.TransferCurrency(ctx, userID1, userID2, models.NewMoney(100_00, models.CurrencyRUB),
+		models.CurrencyUSD, models.Rate(11_000)))
+	requireLedgerBalanced(t, ctx, deps)
+
+	// step 5: deposit and withdraw, the external settlement account mirrors the money outside
+	for _, start := range []func(context.Context, int64, models.Money) (string, error){
+		deps.UserSaga.Deposit,
+		deps.UserSaga.Withdraw,
+	} {
+		paymentID, err := start(ctx, userID1, models.NewMoney(100_00, models.DefaultCurrency))
+		require.NoError(t, err)
+		require.NoError(t, deps.UserSaga.PaymentCallback(ctx, paymentID, saga.PaymentCallback{Success: true}))
+
+		status, err := deps.UserSaga.WaitPayment(ctx, paymentID)
+		require.NoError(t, err)
+		require.Equal(t, models.PaymentStatusCompleted, status)
+	}
+	requireLedgerBalanced(t, ctx, deps)
+
+	// step 6: user1 goes below zero, the interest is charged to user1 and paid to user2 by the treasury
+	require.NoError(t, deps.UserService.SetCreditLimit(ctx, userID1, 100_00))
+	user1, err := deps.UserService.GetUserByID(ctx, userID1)
+	require.NoError(t, err)
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, user1.Balance+50_00))
+
+	date := time.Date(2025, 1, 10, 0, 0, 0, 0, time.UTC)
+	require.NoError(t, deps.UserSaga.ChargeCreditInterest(ctx, date))
+	require.NoError(t, deps.UserSaga.AccrueDepositInterest(ctx, date))
+
+	treasury, err := deps.UserService.GetUserByID(ctx, deps.UserService.SystemAccount(models.SystemAccountTreasury))
+	require.NoError(t, err)
+	require.NotZero(t, treasury.Balance)
+
+	// step 7: the money in the system balances to zero in every currency
+	requireLedgerBalanced(t, ctx, deps)
+}
+
+func requireLedgerBalanced(t *testing.T, ctx context.Context, deps *pkg.TestDeps) {
+	t.Helper()
+	totals, err := deps.UserService.LedgerTotals(ctx)
+	require.NoError(t, err)
+	require.Contains(t, totals, models.DefaultCurrency)
+	for currency, total := range totals {
+		require.Equal(t, int64(0), total, currency)
+	}
+}
@@ -0,0 +1,95 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"strings"
+	"testing"
+	"time"
+	"usershards/internal/id"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/tracing"
+)
+
+func TestTracing(t *testing.T) {
+	// the provider is installed before the temporal client, so the workers trace to it
+	exporter := tracetest.NewInMemoryExporter()
+	provider := tracing.Install(exporter, 1)
+	t.Cleanup(func() {
+		_ = provider.Shutdown(context.Background())
+	})
+
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79134021111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79134021112", "test2@test.ru")
+	require.NoError(t, err)
+
+	// step 2: transfer from user1 to user2 within the caller's span
+	spanCtx, root := tracing.Tracer().Start(ctx, "test-transfer")
+	require.NoError(t, deps.UserSaga.TransferMoney(spanCtx, userID1, userID2, 10_00))
+	root.End()
+	require.NoError(t, provider.ForceFlush(ctx))
+
+	// step 3: the workflow, its activities and their queries are in the caller's trace
+	traceID := root.SpanContext().TraceID()
+	var spans tracetest.SpanStubs
+	for _, span := range exporter.GetSpans() {
+		if span.SpanContext.TraceID() == traceID {
+			spans = append(spans, span)
+		}
+	}
+
+	find := func(name string) tracetest.SpanStub {
+		for _, span := range spans {
+			if span.Name == name {
+				return span
+			}
+		}
+		require.Failf(t, "span not found", "%s", name)
+		return tracetest.SpanStub{}
+	}
+	value := func(span tracetest.SpanStub, key attribute.Key) attribute.Value {
+		for _, attr := range span.Attributes {
+			if attr.Key == key {
+				return attr.Value
+			}
+		}
+		return attribute.Value{}
+	}
+
+	start := find("StartWorkflow:TransferMoneyWorkflow")
+	require.Equal(t, root.SpanContext().SpanID(), start.Parent.SpanID())
+	require.Equal(t, userID1, value(start, tracing.FromUserIDKey).AsInt64())
+	require.Equal(t, userID2, value(start, tracing.ToUserIDKey).AsInt64())
+	transferID := value(start, tracing.TransferIDKey).AsString()
+	require.NotEmpty(t, transferID)
+
+	// step 4: the debit queries are on the sender's shard and the credit queries are on the recipient's shard
+	_, fromShard, _ := id.ParseUserID(userID1)
+	_, toShard, _ := id.ParseUserID(userID2)
+	for name, shardID := range map[string]int{
+		"RunActivity:DecreaseMoney": fromShard,
+		"RunActivity:IncreaseMoney": toShard,
+	} {
+		activity := find(name)
+		require.Equal(t, transferID, value(activity, tracing.TransferIDKey).AsString())
+
+		queries := 0
+		for _, span := range spans {
+			if span.Parent.SpanID() != activity.SpanContext.SpanID() || !strings.HasPrefix(span.Name, "users ") {
+				continue
+			}
+			require.Equal(t, int64(shardID), value(span, tracing.ShardKey).AsInt64(), span.Name)
+			queries++
+		}
+		require.NotZero(t, queries, name)
+	}
+}
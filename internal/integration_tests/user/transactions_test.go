@@ -0,0 +1,113 @@
+package user
+
+import (
+	"context"
+	"github.com/stretchr/testify/require"
+	"testing"
+	"time"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+)
+
+func TestListTransactions(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2 with some money
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	// step 2: two transfers from user1 to user2 and one back
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, 10_00))
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, 20_00))
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID2, userID1, 5_00))
+
+	// step 3: read user1 statement page by page
+	page, err := deps.UserService.ListTransactions(ctx, userID1, "", 2, models.TransactionFilter{})
+	require.NoError(t, err)
+	require.Len(t, page.Transactions, 2)
+	require.NotEmpty(t, page.NextCursor)
+
+	require.Equal(t, models.TransactionDirectionIn, page.Transactions[0].Direction)
+	require.Equal(t, int64(5_00), page.Transactions[0].Amount)
+	require.Equal(t, userID2, page.Transactions[0].CounterpartyID)
+	require.NotEmpty(t, page.Transactions[0].TransferID)
+
+	// the oldest entry is the welcome bonus from the bonus pool
+	page, err = deps.UserService.ListTransactions(ctx, userID1, page.NextCursor, 2, models.TransactionFilter{})
+	require.NoError(t, err)
+	require.Len(t, page.Transactions, 2)
+	require.Empty(t, page.NextCursor)
+	require.Equal(t, int64(10_00), page.Transactions[0].Amount)
+	require.Equal(t, int64(pkg.WelcomeBonus), page.Transactions[1].Amount)
+	require.Equal(t, deps.UserService.SystemAccount(models.SystemAccountBonusPool), page.Transactions[1].CounterpartyID)
+
+	// step 4: filter by direction
+	page, err = deps.UserService.ListTransactions(ctx, userID1, "", 10, models.TransactionFilter{
+		Direction: models.TransactionDirectionOut,
+	})
+	require.NoError(t, err)
+	require.Len(t, page.Transactions, 2)
+	for _, transaction := range page.Transactions {
+		require.Equal(t, models.TransactionDirectionOut, transaction.Direction)
+		require.Equal(t, userID2, transaction.CounterpartyID)
+	}
+
+	// step 5: date range in the future is empty
+	page, err = deps.UserService.ListTransactions(ctx, userID1, "", 10, models.TransactionFilter{
+		Since: time.Now().Add(time.Hour),
+	})
+	require.NoError(t, err)
+	require.Empty(t, page.Transactions)
+}
+
+func TestGetTransfer(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: create user1 and user2 with some money
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133971111", "test1@test.ru")
+	require.NoError(t, err)
+
+	userID2, err := deps.UserSaga.CreateUser(ctx, "+79133971112", "test2@test.ru")
+	require.NoError(t, err)
+
+	// step 2: one completed transfer and one compensated because of blocked recipient
+	const transferAmount = 10_00
+	require.NoError(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, transferAmount))
+
+	page, err := deps.UserService.ListTransactions(ctx, userID1, "", 1, models.TransactionFilter{})
+	require.NoError(t, err)
+	require.Len(t, page.Transactions, 1)
+
+	transfer, err := deps.UserService.GetTransfer(ctx, page.Transactions[0].TransferID)
+	require.NoError(t, err)
+	require.Equal(t, models.TransferStatusCompleted, transfer.Status)
+	require.Equal(t, userID1, transfer.FromID)
+	require.Equal(t, userID2, transfer.ToID)
+	require.Equal(t, int64(transferAmount), transfer.Amount)
+	require.Len(t, transfer.Entries, 2)
+
+	require.NoError(t, deps.UserService.MarkUserAsBlocked(ctx, userID2))
+	require.Error(t, deps.UserSaga.TransferMoney(ctx, userID1, userID2, transferAmount))
+
+	page, err = deps.UserService.ListTransactions(ctx, userID1, "", 1, models.TransactionFilter{
+		Direction: models.TransactionDirectionOut,
+	})
+	require.NoError(t, err)
+	require.Len(t, page.Transactions, 1)
+
+	transfer, err = deps.UserService.GetTransfer(ctx, page.Transactions[0].TransferID)
+	require.NoError(t, err)
+	require.Equal(t, models.TransferStatusCompensated, transfer.Status)
+	for _, entry := range transfer.Entries {
+		if entry.Type == models.TransactionTypeDecrease {
+			require.Equal(t, models.TransactionStatusCompensated, entry.Status)
+		}
+	}
+}
@@ -6,7 +6,6 @@ import (
 	"testing"
 	"time"
 	"usershards/internal/integration_tests/pkg"
-	"usershards/internal/services"
 )
 
 func TestTransferMoney(t *testing.T) {
@@ -37,8 +36,8 @@ func TestTransferMoney(t *testing.T) {
 	user2, err := deps.UserService.GetUserByID(ctx, userID2)
 	require.NoError(t, err)
 
-	require.Equal(t, user1.Balance, int64(services.WelcomeBonus-transferAmount))
-	require.Equal(t, user2.Balance, int64(services.WelcomeBonus+transferAmount))
+	require.Equal(t, user1.Balance, int64(pkg.WelcomeBonus-transferAmount))
+	require.Equal(t, user2.Balance, int64(pkg.WelcomeBonus+transferAmount))
 }
 
 func TestTransferMoney_SecondUserIsBlocked(t *testing.T) {
@@ -74,8 +73,8 @@ func TestTransferMoney_SecondUserIsBlocked(t *testing.T) {
 	user2, err := deps.UserService.GetUserByID(ctx, userID2)
 	require.NoError(t, err)
 
-	require.Equal(t, int64(services.WelcomeBonus), user1.Balance)
-	require.Equal(t, int64(services.WelcomeBonus), user2.Balance)
+	require.Equal(t, int64(pkg.WelcomeBonus), user1.Balance)
+	require.Equal(t, int64(pkg.WelcomeBonus), user2.Balance)
 }
 
 // Test when the sender user is blocked
@@ -112,8 +111,8 @@ func TestTransferMoney_FirstUserIsBlocked(t *testing.T) {
 	user2, err := deps.UserService.GetUserByID(ctx, userID2)
 	require.NoError(t, err)
 
-	require.Equal(t, int64(services.WelcomeBonus), user1.Balance)
-	require.Equal(t, int64(services.WelcomeBonus), user2.Balance)
+	require.Equal(t, int64(pkg.WelcomeBonus), user1.Balance)
+	require.Equal(t, int64(pkg.WelcomeBonus), user2.Balance)
 }
 
 // Test when the sender doesn't have enough balance
@@ -134,7 +133,7 @@ func TestTransferMoney_InsufficientBalance(t *testing.T) {
 	require.NoError(t, err)
 
 	// step 2: transfer more money than user1 has
-	const transferAmount = services.WelcomeBonus + 1_00 // 1 more than available
+	const transferAmount = pkg.WelcomeBonus + 1_00 // 1 more than available
 	err = deps.UserSaga.TransferMoney(ctx, userID1, userID2, transferAmount)
 	require.Error(t, err)
 	time.Sleep(time.Second * 5)
@@ -146,8 +145,8 @@ func TestTransferMoney_InsufficientBalance(t *testing.T) {
 	user2, err := deps.UserService.GetUserByID(ctx, userID2)
 	require.NoError(t, err)
 
-	require.Equal(t, int64(services.WelcomeBonus), user1.Balance)
-	require.Equal(t, int64(services.WelcomeBonus), user2.Balance)
+	require.Equal(t, int64(pkg.WelcomeBonus), user1.Balance)
+	require.Equal(t, int64(pkg.WelcomeBonus), user2.Balance)
 }
 
 // Test with a zero amount transfer
@@ -181,8 +180,8 @@ func TestTransferMoney_ZeroAmount(t *testing.T) {
 	user2, err := deps.UserService.GetUserByID(ctx, userID2)
 	require.NoError(t, err)
 
-	require.Equal(t, int64(services.WelcomeBonus), user1.Balance)
-	require.Equal(t, int64(services.WelcomeBonus), user2.Balance)
+	require.Equal(t, int64(pkg.WelcomeBonus), user1.Balance)
+	require.Equal(t, int64(pkg.WelcomeBonus), user2.Balance)
 }
 
 // Test when the sender doesn't exist
@@ -207,7 +206,7 @@ func TestTransferMoney_SenderDoesNotExist(t *testing.T) {
 	// step 3: check that user2's balance remains unchanged
 	user2, err := deps.UserService.GetUserByID(ctx, userID2)
 	require.NoError(t, err)
-	require.Equal(t, int64(services.WelcomeBonus), user2.Balance)
+	require.Equal(t, int64(pkg.WelcomeBonus), user2.Balance)
 }
 
 // Test when the recipient doesn't exist
@@ -232,7 +231,7 @@ func TestTransferMoney_RecipientDoesNotExist(t *testing.T) {
 	// step 3: check that user1's balance remains unchanged (compensation worked)
 	user1, err := deps.UserService.GetUserByID(ctx, userID1)
 	require.NoError(t, err)
-	require.Equal(t, int64(services.WelcomeBonus), user1.Balance)
+	require.Equal(t, int64(pkg.WelcomeBonus), user1.Balance)
 }
 
 // Test with a negative amount transfer
@@ -266,8 +265,8 @@ func TestTransferMoney_NegativeAmount(t *testing.T) {
 	user2, err := deps.UserService.GetUserByID(ctx, userID2)
 	require.NoError(t, err)
 
-	require.Equal(t, int64(services.WelcomeBonus), user1.Balance)
-	require.Equal(t, int64(services.WelcomeBonus), user2.Balance)
+	require.Equal(t, int64(pkg.WelcomeBonus), user1.Balance)
+	require.Equal(t, int64(pkg.WelcomeBonus), user2.Balance)
 }
 
 // Test transferring money from a user to themselves
@@ -291,13 +290,13 @@ func TestTransferMoney_SameUser(t *testing.T) {
 		// If self-transfers are not allowed, check that the balance remains unchanged
 		user, err := deps.UserService.GetUserByID(ctx, userID)
 		require.NoError(t, err)
-		require.Equal(t, int64(services.WelcomeBonus), user.Balance)
+		require.Equal(t, int64(pkg.WelcomeBonus), user.Balance)
 	} else {
 		// If self-transfers are allowed, the balance should remain the same
 		// (decrease and increase cancel each other out)
 		user, err := deps.UserService.GetUserByID(ctx, userID)
 		require.NoError(t, err)
-		require.Equal(t, int64(services.WelcomeBonus), user.Balance)
+		require.Equal(t, int64(pkg.WelcomeBonus), user.Balance)
 	}
 }
 
@@ -0,0 +1,150 @@
+package user
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/stretchr/testify/require"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/integration_tests/pkg"
+	"usershards/internal/models"
+	"usershards/internal/webhooks"
+)
+
+type webhookReceiver struct {
+	mu     sync.Mutex
+	bodies []webhooks.Body
+	failed atomic.Bool
+	secret string
+	t      *testing.T
+}
+
+func (r *webhookReceiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := io.ReadAll(req.Body)
+	require.NoError(r.t, err)
+
+	if r.failed.Load() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !webhooks.Verify(r.secret, body, req.Header.Get(webhooks.SignatureHeader)) {
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+	var parsed webhooks.Body
+	require.NoError(r.t, json.Unmarshal(body, &parsed))
+	require.Equal(r.t, string(parsed.Type), req.Header.Get(webhooks.EventHeader))
+	require.Equal(r.t, parsed.ID, req.Header.Get(webhooks.DeliveryHeader))
+	r.bodies = append(r.bodies, parsed)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (r *webhookReceiver) received() []webhooks.Body {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]webhooks.Body(nil), r.bodies...)
+}
+
+func TestWebhooks(t *testing.T) {
+	deps := pkg.SetupTest(t, pkg.Setup{})
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute*1)
+	defer cancel()
+
+	// step 1: register one webhook for the user events and one for the completed transfers, which is down
+	usersReceiver := &webhookReceiver{t: t}
+	usersServer := httptest.NewServer(usersReceiver)
+	defer usersServer.Close()
+	transfersReceiver := &webhookReceiver{t: t}
+	transfersReceiver.failed.Store(true)
+	transfersServer := httptest.NewServer(transfersReceiver)
+	defer transfersServer.Close()
+
+	_, err := deps.UserService.RegisterWebhook(ctx, usersServer.URL, []models.EventType{models.EventTypeBalanceDebited})
+	require.ErrorIs(t, err, apperrors.ErrInvalidWebhook)
+
+	usersWebhook, err := deps.UserService.RegisterWebhook(ctx, usersServer.URL,
+		[]models.EventType{models.EventTypeUserCreated, models.EventTypeUserBlocked})
+	require.NoError(t, err)
+	usersReceiver.secret = usersWebhook.Secret
+
+	transfersWebhook, err := deps.UserService.RegisterWebhook(ctx, transfersServer.URL,
+		[]models.EventType{models.EventTypeTransferCompleted})
+	require.NoError(t, err)
+	transfersReceiver.secret = transfersWebhook.Secret
+
+	// step 2: create user1 and block it
+	userID1, err := deps.UserSaga.CreateUser(ctx, "+79133981111", "test1@test.ru")
+	require.NoError(t, err)
+	require.NoError(t, deps.UserService.MarkUserAsBlocked(ctx, userID1))
+
+	// step 3: the signed user events are delivered once, even when the outbox publishes them again
+	_, err = deps.Outbox.PublishPending(ctx)
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(usersReceiver.received()) == 2
+	}, 10*time.Second, 100*time.Millisecond)
+
+	bodies := usersReceiver.received()
+	require.Equal(t, models.EventTypeUserCreated, bodies[0].Type)
+	require.Equal(t, models.EventTypeUserBlocked, bodies[1].Type)
+	var blocked models.UserBlockedEvent
+	require.NoError(t, json.Unmarshal(bodies[1].Data, &blocked))
+	require.Equal(t, userID1, blocked.UserID)
+
+	require.NoError(t, deps.UserSaga.DispatchWebhooks(ctx, deps.Events.Events(userID1)))
+	time.Sleep(time.Second)
+	require.Len(t, usersReceiver.received(), 2)
+
+	deliveries, err := deps.UserService.ListWebhookDeliveries(ctx, usersWebhook.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 2)
+	for _, delivery := range deliveries {
+		require.Equal(t, models.WebhookDeliveryStatusDelivered, delivery.Status)
+		require.Equal(t, 1, delivery.Attempts)
+		require.Equal(t, http.StatusNoContent, delivery.ResponseCode)
+	}
+
+	// step 4: the completed transfer fails to be delivered after all attempts
+	deliveries, err = deps.UserService.ListWebhookDeliveries(ctx, transfersWebhook.ID, 0)
+	require.NoError(t, err)
+	require.Len(t, deliveries, 1)
+	deliveryID := deliveries[0].ID
+
+	require.Eventually(t, func() bool {
+		delivery, err := deps.UserService.GetWebhookDelivery(ctx, deliveryID)
+		require.NoError(t, err)
+		return delivery.Status == models.WebhookDeliveryStatusFailed
+	}, 20*time.Second, 200*time.Millisecond)
+
+	delivery, err := deps.UserService.GetWebhookDelivery(ctx, deliveryID)
+	require.NoError(t, err)
+	require.Equal(t, 3, delivery.Attempts)
+	require.Equal(t, http.StatusServiceUnavailable, delivery.ResponseCode)
+	require.NotEmpty(t, delivery.LastError)
+	require.Empty(t, transfersReceiver.received())
+
+	// step 5: the endpoint is back and the delivery is redelivered by hand
+	transfersReceiver.failed.Store(false)
+	require.NoError(t, deps.UserSaga.RedeliverWebhook(ctx, deliveryID))
+
+	require.Eventually(t, func() bool {
+		delivery, err := deps.UserService.GetWebhookDelivery(ctx, deliveryID)
+		require.NoError(t, err)
+		return delivery.Status == models.WebhookDeliveryStatusDelivered
+	}, 10*time.Second, 100*time.Millisecond)
+
+	bodies = transfersReceiver.received()
+	require.Len(t, bodies, 1)
+	require.Equal(t, deliveryID, bodies[0].ID)
+	require.Equal(t, models.EventTypeTransferCompleted, bodies[0].Type)
+}
@@ -0,0 +1,88 @@
+package logger
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/trace"
+	"go.uber.org/zap"
+	"slices"
+)
+
+// The correlation ids the logs are tagged with, the same in the services, the workflows and the activities.
+const (
+	RequestIDKey  = "request_id"
+	TransferIDKey = "transfer_id"
+	UserIDKey     = "user_id"
+	ShardIDKey    = "shard_id"
+	ToUserIDKey   = "to_user_id"  // the recipient of a transfer, the sender is UserIDKey
+	ToShardIDKey  = "to_shard_id" // the shard of the recipient
+	TraceIDKey    = "trace_id"
+)
+
+type fieldsKey struct{}
+
+type requestIDKey struct{}
+
+// Fielder is implemented by the workflow and activity params which know their users and transfer.
+type Fielder interface {
+	LogFields() []zap.Field
+}
+
+func RequestID(requestID string) zap.Field {
+	return zap.String(RequestIDKey, requestID)
+}
+
+func TransferID(transferID string) zap.Field {
+	return zap.String(TransferIDKey, transferID)
+}
+
+func UserID(userID int64) zap.Field {
+	return zap.Int64(UserIDKey, userID)
+}
+
+func ShardID(shardID int) zap.Field {
+	return zap.Int(ShardIDKey, shardID)
+}
+
+// WithFields returns the context which logs with the fields in addition to the fields it has,
+// a field replaces the one of the context with the same key.
+func WithFields(ctx context.Context, fields ...zap.Field) context.Context {
+	if len(fields) == 0 {
+		return ctx
+	}
+	parent, _ := ctx.Value(fieldsKey{}).([]zap.Field)
+	merged := make([]zap.Field, 0, len(parent)+len(fields))
+	for _, field := range parent {
+		replaced := slices.ContainsFunc(fields, func(f zap.Field) bool { return f.Key == field.Key })
+		if !replaced {
+			merged = append(merged, field)
+		}
+	}
+	merged = append(merged, fields...)
+	return context.WithValue(ctx, fieldsKey{}, merged)
+}
+
+// WithRequestID tags the context with the request, the id goes on to the workflows started with the context.
+func WithRequestID(ctx context.Context, requestID string) context.Context {
+	ctx = context.WithValue(ctx, requestIDKey{}, requestID)
+	return WithFields(ctx, RequestID(requestID))
+}
+
+// RequestIDFromContext returns the request id of the context, empty if it has none.
+func RequestIDFromContext(ctx context.Context) string {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	return requestID
+}
+
+// Fields returns the fields of the context, with the trace id when the context is traced.
+func Fields(ctx context.Context) []zap.Field {
+	fields, _ := ctx.Value(fieldsKey{}).([]zap.Field)
+	if spanContext := trace.SpanContextFromContext(ctx); spanContext.IsValid() {
+		fields = append(fields[:len(fields):len(fields)], zap.String(TraceIDKey, spanContext.TraceID().String()))
+	}
+	return fields
+}
+
+// FromContext returns the logger of the service with the fields of the context.
+func FromContext(ctx context.Context) *zap.Logger {
+	return L().With(Fields(ctx)...)
+}
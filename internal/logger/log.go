@@ -1,19 +1,68 @@
 package logger
 
 import (
+	"fmt"
 	"go.uber.org/zap"
-	"sync"
+	"go.uber.org/zap/zapcore"
 	"sync/atomic"
-	"unsafe"
+	"usershards/internal/config"
 )
 
-var Logger *zap.SugaredLogger
+const FormatJSON = "json"
+const FormatConsole = "console"
 
-func InitLogger() {
-	sync.OnceFunc(func() {
-		logger, _ := zap.NewProduction()
-		defer logger.Sync() // flushes buffer, if any
-		sugar := logger.Sugar()
-		atomic.StorePointer((*unsafe.Pointer)(unsafe.Pointer(&Logger)), unsafe.Pointer(sugar))
-	})()
+// global is the logger of the service, it logs in JSON at the info level until Init is called.
+var global atomic.Pointer[zap.Logger]
+
+func init() {
+	global.Store(zap.Must(zap.NewProduction()))
+}
+
+// L returns the logger of the service. The code which has a context logs by FromContext instead,
+// so the correlation ids of the context get into the log.
+func L() *zap.Logger {
+	return global.Load()
+}
+
+// Init replaces the logger of the service by the one the config describes.
+func Init(conf config.Logging) error {
+	logger, err := New(conf)
+	if err != nil {
+		return err
+	}
+	Set(logger)
+	return nil
+}
+
+// Set replaces the logger of the service.
+func Set(logger *zap.Logger) {
+	global.Store(logger)
+}
+
+// New builds a logger with the level and the format of the config, info and json by default.
+func New(conf config.Logging) (*zap.Logger, error) {
+	zapConfig := zap.NewProductionConfig()
+
+	if conf.Level != "" {
+		level, err := zapcore.ParseLevel(conf.Level)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse log level: %w", err)
+		}
+		zapConfig.Level = zap.NewAtomicLevelAt(level)
+	}
+
+	switch conf.Format {
+	case "", FormatJSON:
+	case FormatConsole:
+		zapConfig.Encoding = FormatConsole
+		zapConfig.EncoderConfig = zap.NewDevelopmentEncoderConfig()
+	default:
+		return nil, fmt.Errorf("unknown log format %s", conf.Format)
+	}
+
+	logger, err := zapConfig.Build()
+	if err != nil {
+		return nil, fmt.Errorf("failed to build logger: %w", err)
+	}
+	return logger, nil
 }
@@ -0,0 +1,203 @@
+package logger
+
+import (
+	"context"
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/converter"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/log"
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
+)
+
+// requestIDHeader is the Temporal header the request id goes in from the caller to the workflows and activities.
+const requestIDHeader = "usershards-request-id"
+
+// temporalLogger makes the logs of the Temporal SDK, the workflows and the activities go to zap.
+type temporalLogger struct {
+	logger *zap.SugaredLogger
+}
+
+func NewTemporalLogger(logger *zap.Logger) log.Logger {
+	return &temporalLogger{logger: logger.WithOptions(zap.AddCallerSkip(1)).Sugar()}
+}
+
+func (l *temporalLogger) Debug(msg string, keyvals ...interface{}) {
+	l.logger.Debugw(msg, keyvals...)
+}
+
+func (l *temporalLogger) Info(msg string, keyvals ...interface{}) {
+	l.logger.Infow(msg, keyvals...)
+}
+
+func (l *temporalLogger) Warn(msg string, keyvals ...interface{}) {
+	l.logger.Warnw(msg, keyvals...)
+}
+
+func (l *temporalLogger) Error(msg string, keyvals ...interface{}) {
+	l.logger.Errorw(msg, keyvals...)
+}
+
+func (l *temporalLogger) With(keyvals ...interface{}) log.Logger {
+	return &temporalLogger{logger: l.logger.With(keyvals...)}
+}
+
+func (l *temporalLogger) WithCallerSkip(depth int) log.Logger {
+	return &temporalLogger{logger: l.logger.WithOptions(zap.AddCallerSkip(depth))}
+}
+
+// ContextPropagator carries the request id of the caller's context through the workflows to the activities.
+// A workflow started without a request id gets a new one, so all logs of a saga share it.
+type ContextPropagator struct{}
+
+func NewContextPropagator() workflow.ContextPropagator {
+	return &ContextPropagator{}
+}
+
+func (p *ContextPropagator) Inject(ctx context.Context, writer workflow.HeaderWriter) error {
+	requestID := RequestIDFromContext(ctx)
+	if requestID == "" {
+		requestID = uuid.NewString()
+	}
+	return writeRequestID(writer, requestID)
+}
+
+func (p *ContextPropagator) Extract(ctx context.Context, reader workflow.HeaderReader) (context.Context, error) {
+	requestID, err := readRequestID(reader)
+	if err != nil || requestID == "" {
+		return ctx, err
+	}
+	return WithRequestID(ctx, requestID), nil
+}
+
+func (p *ContextPropagator) InjectFromWorkflow(ctx workflow.Context, writer workflow.HeaderWriter) error {
+	requestID, _ := ctx.Value(requestIDKey{}).(string)
+	if requestID == "" {
+		return nil
+	}
+	return writeRequestID(writer, requestID)
+}
+
+func (p *ContextPropagator) ExtractToWorkflow(ctx workflow.Context, reader workflow.HeaderReader) (workflow.Context, error) {
+	requestID, err := readRequestID(reader)
+	if err != nil || requestID == "" {
+		return ctx, err
+	}
+	return workflow.WithValue(ctx, requestIDKey{}, requestID), nil
+}
+
+func writeRequestID(writer workflow.HeaderWriter, requestID string) error {
+	payload, err := converter.GetDefaultDataConverter().ToPayload(requestID)
+	if err != nil {
+		return err
+	}
+	writer.Set(requestIDHeader, payload)
+	return nil
+}
+
+func readRequestID(reader workflow.HeaderReader) (string, error) {
+	payload, ok := reader.Get(requestIDHeader)
+	if !ok {
+		return "", nil
+	}
+	var requestID string
+	err := converter.GetDefaultDataConverter().FromPayload(payload, &requestID)
+	return requestID, err
+}
+
+// WorkerInterceptor tags the logs of the workflows and activities with the request id and the fields
+// of their params which are Fielder, the activities pass the fields on to the services by the context.
+type WorkerInterceptor struct {
+	interceptor.WorkerInterceptorBase
+}
+
+func NewWorkerInterceptor() *WorkerInterceptor {
+	return &WorkerInterceptor{}
+}
+
+func (w *WorkerInterceptor) InterceptActivity(
+	ctx context.Context,
+	next interceptor.ActivityInboundInterceptor,
+) interceptor.ActivityInboundInterceptor {
+	i := &activityInbound{}
+	i.Next = next
+	return i
+}
+
+func (w *WorkerInterceptor) InterceptWorkflow(
+	ctx workflow.Context,
+	next interceptor.WorkflowInboundInterceptor,
+) interceptor.WorkflowInboundInterceptor {
+	i := &workflowInbound{}
+	i.Next = next
+	return i
+}
+
+type activityInbound struct {
+	interceptor.ActivityInboundInterceptorBase
+}
+
+func (a *activityInbound) Init(outbound interceptor.ActivityOutboundInterceptor) error {
+	i := &activityOutbound{}
+	i.Next = outbound
+	return a.Next.Init(i)
+}
+
+func (a *activityInbound) ExecuteActivity(ctx context.Context, in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	return a.Next.ExecuteActivity(WithFields(ctx, argsFields(in.Args)...), in)
+}
+
+type activityOutbound struct {
+	interceptor.ActivityOutboundInterceptorBase
+}
+
+func (a *activityOutbound) GetLogger(ctx context.Context) log.Logger {
+	return log.With(a.Next.GetLogger(ctx), fieldsKeyvals(Fields(ctx))...)
+}
+
+type workflowInbound struct {
+	interceptor.WorkflowInboundInterceptorBase
+	fields []zap.Field
+}
+
+func (w *workflowInbound) Init(outbound interceptor.WorkflowOutboundInterceptor) error {
+	i := &workflowOutbound{inbound: w}
+	i.Next = outbound
+	return w.Next.Init(i)
+}
+
+func (w *workflowInbound) ExecuteWorkflow(ctx workflow.Context, in *interceptor.ExecuteWorkflowInput) (interface{}, error) {
+	if requestID, ok := ctx.Value(requestIDKey{}).(string); ok {
+		w.fields = append(w.fields, RequestID(requestID))
+	}
+	w.fields = append(w.fields, argsFields(in.Args)...)
+	return w.Next.ExecuteWorkflow(ctx, in)
+}
+
+type workflowOutbound struct {
+	interceptor.WorkflowOutboundInterceptorBase
+	inbound *workflowInbound
+}
+
+func (w *workflowOutbound) GetLogger(ctx workflow.Context) log.Logger {
+	return log.With(w.Next.GetLogger(ctx), fieldsKeyvals(w.inbound.fields)...)
+}
+
+func argsFields(args []interface{}) []zap.Field {
+	var fields []zap.Field
+	for _, arg := range args {
+		if fielder, ok := arg.(Fielder); ok {
+			fields = append(fields, fielder.LogFields()...)
+		}
+	}
+	return fields
+}
+
+// fieldsKeyvals passes the fields to the Temporal logger, which hands them to zap as they are.
+func fieldsKeyvals(fields []zap.Field) []interface{} {
+	keyvals := make([]interface{}, len(fields))
+	for i, field := range fields {
+		keyvals[i] = field
+	}
+	return keyvals
+}
@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"errors"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"go.uber.org/zap"
+	"net/http"
+	"time"
+	"usershards/internal/logger"
+)
+
+const namespace = "usershards"
+
+// Registry keeps all metrics of the service, it is what /metrics exposes.
+var Registry = prometheus.NewRegistry()
+
+var factory = promauto.With(Registry)
+
+// QueryDuration is the latency of the queries to the shards by the UserService method which made them.
+var QueryDuration = factory.NewHistogramVec(prometheus.HistogramOpts{
+	Namespace: namespace,
+	Name:      "query_duration_seconds",
+	Help:      "Latency of shard queries by UserService method.",
+	Buckets:   []float64{.0005, .001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+}, []string{"db", "shard", "method"})
+
+// SagaOutcomes counts the finished workflows by how they finished.
+var SagaOutcomes = factory.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "saga_outcomes_total",
+	Help:      "Finished workflows by outcome: completed, compensated or failed.",
+}, []string{"workflow", "outcome"})
+
+// ActivityRetries counts the activity attempts after the first one.
+var ActivityRetries = factory.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "activity_retries_total",
+	Help:      "Temporal activity attempts after the first one.",
+}, []string{"activity"})
+
+// GeneratedIDs counts the generated user IDs.
+var GeneratedIDs = factory.NewCounterVec(prometheus.CounterOpts{
+	Namespace: namespace,
+	Name:      "generated_ids_total",
+	Help:      "Generated user IDs by shard.",
+}, []string{"shard"})
+
+func init() {
+	Registry.MustRegister(collectors.NewGoCollector(), collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}))
+	Registry.MustRegister(pools)
+}
+
+func Handler() http.Handler {
+	return promhttp.HandlerFor(Registry, promhttp.HandlerOpts{Registry: Registry})
+}
+
+// Serve exposes /metrics on addr in the background, an empty addr turns it off.
+func Serve(addr string) *http.Server {
+	if addr == "" {
+		return nil
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", Handler())
+	server := &http.Server{Addr: addr, Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+	go func() {
+		err := server.ListenAndServe()
+		if err != nil && !errors.Is(err, http.ErrServerClosed) {
+			logger.L().Error("metrics server failed", zap.Error(err))
+		}
+	}()
+
+	return server
+}
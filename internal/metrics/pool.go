@@ -0,0 +1,77 @@
+package metrics
+
+import (
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+	"strconv"
+	"sync"
+)
+
+var pools = &poolCollector{pools: make(map[poolKey]*pgxpool.Pool)}
+
+type poolKey struct {
+	db    string
+	shard int
+}
+
+// RegisterPool adds the stats of the shard's pool to the metrics, it replaces the pool registered before.
+func RegisterPool(db string, shardID int, pool *pgxpool.Pool) {
+	pools.mu.Lock()
+	defer pools.mu.Unlock()
+
+	pools.pools[poolKey{db: db, shard: shardID}] = pool
+}
+
+var poolLabels = []string{"db", "shard"}
+
+var (
+	acquiredConnsDesc = prometheus.NewDesc(namespace+"_pool_acquired_connections",
+		"Connections of the shard pool in use.", poolLabels, nil)
+	idleConnsDesc = prometheus.NewDesc(namespace+"_pool_idle_connections",
+		"Idle connections of the shard pool.", poolLabels, nil)
+	totalConnsDesc = prometheus.NewDesc(namespace+"_pool_total_connections",
+		"Connections of the shard pool.", poolLabels, nil)
+	maxConnsDesc = prometheus.NewDesc(namespace+"_pool_max_connections",
+		"Maximum connections of the shard pool.", poolLabels, nil)
+	acquiresDesc = prometheus.NewDesc(namespace+"_pool_acquires_total",
+		"Connections acquired from the shard pool.", poolLabels, nil)
+	emptyAcquiresDesc = prometheus.NewDesc(namespace+"_pool_empty_acquires_total",
+		"Acquires which waited for a connection because the shard pool was empty.", poolLabels, nil)
+	acquireWaitDesc = prometheus.NewDesc(namespace+"_pool_acquire_wait_seconds_total",
+		"Time spent acquiring connections from the shard pool.", poolLabels, nil)
+)
+
+// poolCollector reads the stats of the pools on every scrape.
+type poolCollector struct {
+	mu    sync.Mutex
+	pools map[poolKey]*pgxpool.Pool
+}
+
+func (c *poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- acquiredConnsDesc
+	ch <- idleConnsDesc
+	ch <- totalConnsDesc
+	ch <- maxConnsDesc
+	ch <- acquiresDesc
+	ch <- emptyAcquiresDesc
+	ch <- acquireWaitDesc
+}
+
+func (c *poolCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, pool := range c.pools {
+		stat := pool.Stat()
+		labels := []string{key.db, strconv.Itoa(key.shard)}
+		ch <- prometheus.MustNewConstMetric(acquiredConnsDesc, prometheus.GaugeValue, float64(stat.AcquiredConns()), labels...)
+		ch <- prometheus.MustNewConstMetric(idleConnsDesc, prometheus.GaugeValue, float64(stat.IdleConns()), labels...)
+		ch <- prometheus.MustNewConstMetric(totalConnsDesc, prometheus.GaugeValue, float64(stat.TotalConns()), labels...)
+		ch <- prometheus.MustNewConstMetric(maxConnsDesc, prometheus.GaugeValue, float64(stat.MaxConns()), labels...)
+		ch <- prometheus.MustNewConstMetric(acquiresDesc, prometheus.CounterValue, float64(stat.AcquireCount()), labels...)
+		ch <- prometheus.MustNewConstMetric(emptyAcquiresDesc, prometheus.CounterValue,
+			float64(stat.EmptyAcquireCount()), labels...)
+		ch <- prometheus.MustNewConstMetric(acquireWaitDesc, prometheus.CounterValue,
+			stat.AcquireDuration().Seconds(), labels...)
+	}
+}
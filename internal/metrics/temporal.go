@@ -0,0 +1,128 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/prometheus/client_golang/prometheus"
+	"go.temporal.io/api/workflowservice/v1"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
+	"time"
+	"usershards/internal/logger"
+)
+
+const OutcomeCompleted = "completed"
+const OutcomeCompensated = "compensated"
+const OutcomeFailed = "failed"
+
+// compensatedErrorType is the type of the error the sagas return after they have rolled back.
+const compensatedErrorType = "apperrors.ErrCompensationCompleted"
+
+// WorkerInterceptor counts the activity retries and the outcomes of the workflows of a worker.
+type WorkerInterceptor struct {
+	interceptor.WorkerInterceptorBase
+}
+
+func NewWorkerInterceptor() *WorkerInterceptor {
+	return &WorkerInterceptor{}
+}
+
+func (w *WorkerInterceptor) InterceptActivity(
+	ctx context.Context,
+	next interceptor.ActivityInboundInterceptor,
+) interceptor.ActivityInboundInterceptor {
+	i := &activityInbound{}
+	i.Next = next
+	return i
+}
+
+func (w *WorkerInterceptor) InterceptWorkflow(
+	ctx workflow.Context,
+	next interceptor.WorkflowInboundInterceptor,
+) interceptor.WorkflowInboundInterceptor {
+	i := &workflowInbound{}
+	i.Next = next
+	return i
+}
+
+type activityInbound struct {
+	interceptor.ActivityInboundInterceptorBase
+}
+
+func (a *activityInbound) ExecuteActivity(ctx context.Context, in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	info := activity.GetInfo(ctx)
+	if info.Attempt > 1 {
+		ActivityRetries.WithLabelValues(info.ActivityType.Name).Inc()
+	}
+	return a.Next.ExecuteActivity(ctx, in)
+}
+
+type workflowInbound struct {
+	interceptor.WorkflowInboundInterceptorBase
+}
+
+func (w *workflowInbound) ExecuteWorkflow(ctx workflow.Context, in *interceptor.ExecuteWorkflowInput) (interface{}, error) {
+	res, err := w.Next.ExecuteWorkflow(ctx, in)
+
+	// a workflow finishes live after the replay of its history, so every run is counted once
+	if workflow.IsReplaying(ctx) || workflow.IsContinueAsNewError(err) {
+		return res, err
+	}
+	workflowType := workflow.GetInfo(ctx).WorkflowType.Name
+	var appErr *temporal.ApplicationError
+	switch {
+	case err == nil:
+		SagaOutcomes.WithLabelValues(workflowType, OutcomeCompleted).Inc()
+	case errors.As(err, &appErr) && appErr.Type() == compensatedErrorType:
+		SagaOutcomes.WithLabelValues(workflowType, OutcomeCompensated).Inc()
+	default:
+		SagaOutcomes.WithLabelValues(workflowType, OutcomeFailed).Inc()
+	}
+
+	return res, err
+}
+
+var stuckSagasDesc = prometheus.NewDesc(namespace+"_saga_stuck",
+	"Workflows running longer than expected.", []string{"workflow"}, nil)
+
+// stuckSagaCollector counts the running workflows older than stuckAfter in the Temporal visibility on every scrape.
+type stuckSagaCollector struct {
+	client        client.Client
+	stuckAfter    time.Duration
+	workflowTypes []string
+}
+
+// RegisterStuckSagas adds the count of the workflows of the types which run longer than stuckAfter.
+func RegisterStuckSagas(temporalClient client.Client, stuckAfter time.Duration, workflowTypes ...string) error {
+	return Registry.Register(&stuckSagaCollector{
+		client:        temporalClient,
+		stuckAfter:    stuckAfter,
+		workflowTypes: workflowTypes,
+	})
+}
+
+func (c *stuckSagaCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- stuckSagasDesc
+}
+
+func (c *stuckSagaCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	startedBefore := time.Now().Add(-c.stuckAfter).UTC().Format(time.RFC3339)
+	for _, workflowType := range c.workflowTypes {
+		query := fmt.Sprintf("WorkflowType = '%s' AND ExecutionStatus = 'Running' AND StartTime < '%s'",
+			workflowType, startedBefore)
+		resp, err := c.client.CountWorkflow(ctx, &workflowservice.CountWorkflowExecutionsRequest{Query: query})
+		if err != nil {
+			logger.L().Error("failed to count stuck workflows", zap.String("workflow", workflowType), zap.Error(err))
+			continue
+		}
+		ch <- prometheus.MustNewConstMetric(stuckSagasDesc, prometheus.GaugeValue, float64(resp.GetCount()), workflowType)
+	}
+}
@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"strconv"
+	"time"
+)
+
+// otherMethod labels the queries made outside of UserService, like the outbox relay.
+const otherMethod = "other"
+
+type queryStartKey struct{}
+
+type methodKey struct{}
+
+type queryStart struct {
+	method string
+	at     time.Time
+}
+
+// QueryTracer measures the queries of one shard's pool.
+type QueryTracer struct {
+	db    string
+	shard string
+}
+
+func NewQueryTracer(db string, shardID int) *QueryTracer {
+	return &QueryTracer{db: db, shard: strconv.Itoa(shardID)}
+}
+
+func (t *QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryStartData) context.Context {
+	return context.WithValue(ctx, queryStartKey{}, queryStart{method: serviceMethod(ctx), at: time.Now()})
+}
+
+func (t *QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, _ pgx.TraceQueryEndData) {
+	start, ok := ctx.Value(queryStartKey{}).(queryStart)
+	if !ok {
+		return
+	}
+	QueryDuration.WithLabelValues(t.db, t.shard, start.method).Observe(time.Since(start.at).Seconds())
+}
+
+// WithMethod tags the queries made with the context by the UserService method. The outermost method wins,
+// so the queries of the methods it calls are counted as its own.
+func WithMethod(ctx context.Context, method string) context.Context {
+	if _, ok := ctx.Value(methodKey{}).(string); ok {
+		return ctx
+	}
+	return context.WithValue(ctx, methodKey{}, method)
+}
+
+// serviceMethod is the UserService method of the context, otherMethod when there is none.
+func serviceMethod(ctx context.Context) string {
+	if method, ok := ctx.Value(methodKey{}).(string); ok {
+		return method
+	}
+	return otherMethod
+}
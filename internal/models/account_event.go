@@ -0,0 +1,61 @@
+package models
+
+import "time"
+
+// StorageMode is how the accounts are stored. In StorageModeEvents every change of an account is also
+// appended to its event stream, and the reads of balances are served by the projection of the stream.
+type StorageMode string
+
+const StorageModeRows StorageMode = "rows"
+const StorageModeEvents StorageMode = "events"
+
+type AccountEventType string
+
+const AccountEventTypeOpened AccountEventType = "opened"
+const AccountEventTypeCredited AccountEventType = "credited"
+const AccountEventTypeDebited AccountEventType = "debited"
+const AccountEventTypeBlocked AccountEventType = "blocked"
+
+// AccountEvent is one change of the account. The events of a user are numbered from 1 without gaps.
+type AccountEvent struct {
+	UserID          int64            `json:"user_id"`
+	Version         int64            `json:"version"`
+	Type            AccountEventType `json:"type"`
+	TransferID      string           `json:"transfer_id,omitempty"`
+	TransactionType TransactionType  `json:"transaction_type,omitempty"`
+	Amount          int64            `json:"amount,omitempty"`
+	Currency        Currency         `json:"currency,omitempty"`
+	CreatedAt       time.Time        `json:"created_at"`
+}
+
+// AccountState is the account as its events left it.
+type AccountState struct {
+	UserID    int64              `json:"user_id"`
+	Version   int64              `json:"version"` // of the last applied event
+	Balances  map[Currency]int64 `json:"balances"`
+	IsBlocked bool               `json:"is_blocked"`
+	CreatedAt time.Time          `json:"created_at"`
+	UpdatedAt time.Time          `json:"updated_at"`
+}
+
+func NewAccountState(userID int64) *AccountState {
+	return &AccountState{UserID: userID, Balances: make(map[Currency]int64)}
+}
+
+// Apply moves the state to the event, the events must be applied in the order of their versions.
+func (a *AccountState) Apply(event AccountEvent) {
+	switch event.Type {
+	case AccountEventTypeOpened:
+		a.Balances = make(map[Currency]int64)
+		a.IsBlocked = false
+		a.CreatedAt = event.CreatedAt
+	case AccountEventTypeCredited:
+		a.Balances[event.Currency] += event.Amount
+	case AccountEventTypeDebited:
+		a.Balances[event.Currency] -= event.Amount
+	case AccountEventTypeBlocked:
+		a.IsBlocked = true
+	}
+	a.Version = event.Version
+	a.UpdatedAt = event.CreatedAt
+}
@@ -0,0 +1,18 @@
+package models
+
+type BatchItemStatus string
+
+const BatchItemStatusPending BatchItemStatus = "pending"
+const BatchItemStatusCompleted BatchItemStatus = "completed"
+const BatchItemStatusFailed BatchItemStatus = "failed"
+
+// BatchItem is one transfer of the batch, kept on the sender's shard until the batch is done.
+type BatchItem struct {
+	Position      int             `json:"position"` // the order the item was given in, from 0
+	To            int64           `json:"to"`
+	Amount        int64           `json:"amount"`
+	Fee           int64           `json:"fee"`
+	TransactionID string          `json:"transaction_id"`
+	Status        BatchItemStatus `json:"status"`
+	Error         string          `json:"error"`
+}
@@ -0,0 +1,48 @@
+package models
+
+import (
+	"strings"
+	"time"
+)
+
+// Campaign pays a bonus to the new users which match it. The first matching campaign with budget left wins.
+type Campaign struct {
+	ID            string    `json:"id" yaml:"id"`
+	Amount        int64     `json:"amount" yaml:"amount"`                 // in kopecks
+	Since         time.Time `json:"since" yaml:"since"`                   // zero means no start
+	Until         time.Time `json:"until" yaml:"until"`                   // zero means no end
+	Budget        int64     `json:"budget" yaml:"budget"`                 // total of all bonuses, zero means unlimited
+	PhonePrefixes []string  `json:"phone_prefixes" yaml:"phone-prefixes"` // empty means any phone
+}
+
+// Matches checks the window and the targeting, the budget is checked when the bonus is reserved.
+func (c Campaign) Matches(phone string, now time.Time) bool {
+	if !c.Since.IsZero() && now.Before(c.Since) {
+		return false
+	}
+	if !c.Until.IsZero() && !now.Before(c.Until) {
+		return false
+	}
+	if len(c.PhonePrefixes) == 0 {
+		return true
+	}
+	for _, prefix := range c.PhonePrefixes {
+		if strings.HasPrefix(phone, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+type BonusGrantStatus string
+
+const BonusGrantStatusGranted BonusGrantStatus = "granted"
+const BonusGrantStatusReleased BonusGrantStatus = "released"
+
+// BonusGrant is the bonus reserved for the user from the campaign budget. Zero amount means no bonus.
+type BonusGrant struct {
+	UserID     int64            `json:"user_id"`
+	CampaignID string           `json:"campaign_id"`
+	Amount     int64            `json:"amount"`
+	Status     BonusGrantStatus `json:"status"`
+}
@@ -0,0 +1,9 @@
+package models
+
+type EscrowStatus string
+
+const EscrowStatusPending EscrowStatus = "pending"   // the buyer is not debited yet
+const EscrowStatusHeld EscrowStatus = "held"         // the money is on the escrow account
+const EscrowStatusReleased EscrowStatus = "released" // the money is paid to the seller
+const EscrowStatusRefunded EscrowStatus = "refunded" // the money is returned to the buyer
+const EscrowStatusFailed EscrowStatus = "failed"     // the buyer couldn't be debited
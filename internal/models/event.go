@@ -0,0 +1,83 @@
+package models
+
+import (
+	"encoding/json"
+	"time"
+)
+
+type EventType string
+
+const EventTypeUserCreated EventType = "user_created"
+const EventTypeBalanceDebited EventType = "balance_debited"
+const EventTypeBalanceCredited EventType = "balance_credited"
+const EventTypeTransferCompleted EventType = "transfer_completed"
+const EventTypeTransferCompensated EventType = "transfer_compensated"
+const EventTypeTransferFailed EventType = "transfer_failed"
+const EventTypeUserBlocked EventType = "user_blocked"
+
+// Event is a domain event published from the outbox of the user's shard. The events of one user
+// are published in the order they happened, an event may be published more than once.
+type Event struct {
+	ID        int64           `json:"id"` // position in the shard's outbox
+	Shard     int             `json:"shard"`
+	UserID    int64           `json:"user_id"`
+	Type      EventType       `json:"type"`
+	Payload   json.RawMessage `json:"payload"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+type UserCreatedEvent struct {
+	UserID int64  `json:"user_id"`
+	Phone  string `json:"phone"`
+	Email  string `json:"email"`
+}
+
+type UserBlockedEvent struct {
+	UserID int64 `json:"user_id"`
+}
+
+// BalanceChangedEvent is the payload of EventTypeBalanceDebited and EventTypeBalanceCredited.
+type BalanceChangedEvent struct {
+	UserID          int64           `json:"user_id"`
+	CounterpartyID  int64           `json:"counterparty_id"`
+	TransferID      string          `json:"transfer_id"`
+	TransactionType TransactionType `json:"transaction_type"`
+	Amount          int64           `json:"amount"`
+	Currency        Currency        `json:"currency"`
+	BalanceAfter    int64           `json:"balance_after"`
+}
+
+type TransferCompletedEvent struct {
+	TransferID string   `json:"transfer_id"`
+	FromID     int64    `json:"from_id"`
+	ToID       int64    `json:"to_id"`
+	Amount     int64    `json:"amount"` // credited to the recipient
+	Currency   Currency `json:"currency"`
+}
+
+// TransferCompensatedEvent is published to the sender once the debit of the transfer is returned.
+type TransferCompensatedEvent struct {
+	TransferID string   `json:"transfer_id"`
+	FromID     int64    `json:"from_id"`
+	ToID       int64    `json:"to_id"`
+	Amount     int64    `json:"amount"` // returned to the sender, with the fee
+	Currency   Currency `json:"currency"`
+}
+
+// TransferFailedEvent is published to the sender when the transfer ends without crediting the recipient,
+// after its compensation if the sender was debited.
+type TransferFailedEvent struct {
+	TransferID string   `json:"transfer_id"`
+	FromID     int64    `json:"from_id"`
+	ToID       int64    `json:"to_id"`
+	Amount     int64    `json:"amount"`
+	Currency   Currency `json:"currency"`
+	Reason     string   `json:"reason"`
+}
+
+// CompletingTransactionTypes are the credits which complete a transfer.
+var CompletingTransactionTypes = []TransactionType{
+	TransactionTypeIncrease,
+	TransactionTypeExchangeIn,
+	TransactionTypeRefundIn,
+}
@@ -0,0 +1,29 @@
+package models
+
+type FeeRuleType string
+
+const FeeRuleTypeFlat FeeRuleType = "flat"
+const FeeRuleTypePercent FeeRuleType = "percent"
+const FeeRuleTypeTiered FeeRuleType = "tiered"
+
+// FeeRule describes how a transfer fee is charged. Amounts are in kopecks.
+type FeeRule struct {
+	Type        FeeRuleType `yaml:"type"`
+	Amount      int64       `yaml:"amount"`       // flat: fixed fee
+	BasisPoints int64       `yaml:"basis-points"` // percent: 1 basis point = 0.01% of the amount
+	Min         int64       `yaml:"min"`          // percent: lower bound of the fee, 0 - no bound
+	Max         int64       `yaml:"max"`          // percent: upper bound of the fee, 0 - no bound
+	Tiers       []FeeTier   `yaml:"tiers"`        // tiered: the first tier the amount fits in is applied
+}
+
+// FeeTier applies its own flat or percent rule to amounts below UpTo. UpTo = 0 matches any amount.
+type FeeTier struct {
+	UpTo    int64 `yaml:"up-to"`
+	FeeRule `yaml:",inline"`
+}
+
+// Fee is charged from the sender on top of the transfer amount and credited to AccountID.
+type Fee struct {
+	Amount    int64 `json:"amount"`
+	AccountID int64 `json:"account_id"`
+}
@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+type FXQuoteStatus string
+
+const FXQuoteStatusOpen FXQuoteStatus = "open"
+const FXQuoteStatusAccepted FXQuoteStatus = "accepted"
+const FXQuoteStatusCompensated FXQuoteStatus = "compensated" // the exchange failed, the quote can't be used again
+
+// FXQuote locks the exchange rate for the user until ExpiresAt.
+// Spread is the difference between the mid rate and the user's rate, booked to the treasury in the target currency.
+type FXQuote struct {
+	ID        string        `json:"id"`
+	UserID    int64         `json:"user_id"`
+	Source    Money         `json:"source"`
+	Target    Money         `json:"target"`
+	Spread    Money         `json:"spread"`
+	Rate      Rate          `json:"rate"`
+	MidRate   Rate          `json:"mid_rate"`
+	Status    FXQuoteStatus `json:"status"`
+	ExpiresAt time.Time     `json:"expires_at"`
+	CreatedAt time.Time     `json:"created_at"`
+}
@@ -0,0 +1,27 @@
+package models
+
+import "time"
+
+type HoldStatus string
+
+const HoldStatusActive HoldStatus = "active"
+const HoldStatusCaptured HoldStatus = "captured"
+const HoldStatusReleased HoldStatus = "released"
+
+// Hold is money reserved on the user's balance. It is not spendable until captured or released.
+type Hold struct {
+	ID        string     `json:"id"`
+	UserID    int64      `json:"user_id"`
+	ToID      int64      `json:"to_id"`
+	Amount    int64      `json:"amount"`
+	Captured  int64      `json:"captured"` // debited by parts, see UserService.DecreaseHeldMoney
+	Status    HoldStatus `json:"status"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	CreatedAt time.Time  `json:"created_at"`
+	UpdatedAt time.Time  `json:"updated_at"`
+}
+
+// Remaining is the held money which is not captured yet.
+func (h Hold) Remaining() int64 {
+	return h.Amount - h.Captured
+}
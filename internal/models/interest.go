@@ -0,0 +1,17 @@
+package models
+
+// InterestRate is the annual rate paid on balances below UpTo. UpTo = 0 matches any balance.
+type InterestRate struct {
+	UpTo        int64 `yaml:"up-to"`
+	BasisPoints int64 `yaml:"basis-points"` // 1 basis point = 0.01% a year
+}
+
+// InterestBasisPoints returns the rate of the first tier the balance fits in, 0 if none.
+func InterestBasisPoints(rates []InterestRate, balance int64) int64 {
+	for _, rate := range rates {
+		if rate.UpTo == 0 || balance < rate.UpTo {
+			return rate.BasisPoints
+		}
+	}
+	return 0
+}
@@ -0,0 +1,8 @@
+package models
+
+// TransferLimits restrict outgoing transfers of the user, in kopecks. Zero means unlimited.
+type TransferLimits struct {
+	Single  int64 `json:"single" yaml:"single"`   // one transfer
+	Daily   int64 `json:"daily" yaml:"daily"`     // total per calendar day, UTC
+	Monthly int64 `json:"monthly" yaml:"monthly"` // total per calendar month, UTC
+}
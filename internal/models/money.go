@@ -0,0 +1,106 @@
+package models
+
+import (
+	"fmt"
+	"math/big"
+	"strconv"
+	"strings"
+	"usershards/internal/apperrors"
+)
+
+// Currency is an ISO 4217 alphabetic code
+type Currency string
+
+const CurrencyRUB Currency = "RUB"
+const CurrencyUSD Currency = "USD"
+const CurrencyEUR Currency = "EUR"
+
+// DefaultCurrency is the currency of users.balance, other currencies are kept in separate accounts.
+const DefaultCurrency = CurrencyRUB
+
+func (c Currency) Valid() bool {
+	if len(c) != 3 {
+		return false
+	}
+	for _, r := range c {
+		if r < 'A' || r > 'Z' {
+			return false
+		}
+	}
+	return true
+}
+
+// Money is an amount in minor units (kopecks, cents) of the currency.
+type Money struct {
+	Amount   int64    `json:"amount"`
+	Currency Currency `json:"currency"`
+}
+
+func NewMoney(amount int64, currency Currency) Money {
+	return Money{Amount: amount, Currency: currency}
+}
+
+func (m Money) String() string {
+	return fmt.Sprintf("%d %s", m.Amount, m.Currency)
+}
+
+// RateScale is the number of Rate units in one whole.
+const RateScale = 1_000_000
+
+// Rate is a conversion rate between minor units of two currencies with 6 decimal places:
+// 92_500000 means that one minor unit of the source currency is worth 92.5 minor units of the target one.
+type Rate int64
+
+// Convert returns the amount in the target currency, rounded down to the minor unit.
+// It fails with apperrors.ErrAmountOverflow when the result doesn't fit in int64.
+func (r Rate) Convert(amount int64) (int64, error) {
+	res := new(big.Int).Mul(big.NewInt(amount), big.NewInt(int64(r)))
+	res.Quo(res, big.NewInt(RateScale))
+	if !res.IsInt64() {
+		return 0, apperrors.ErrAmountOverflow
+	}
+	return res.Int64(), nil
+}
+
+// Inverse returns the rate of the opposite direction, rounded down.
+func (r Rate) Inverse() Rate {
+	if r <= 0 {
+		return 0
+	}
+	return Rate(RateScale * RateScale / int64(r))
+}
+
+// ApplySpread lowers the rate by the spread given in basis points.
+// It fails with apperrors.ErrAmountOverflow when the result doesn't fit in int64.
+func (r Rate) ApplySpread(basisPoints int64) (Rate, error) {
+	res := new(big.Int).Mul(big.NewInt(int64(r)), big.NewInt(10_000-basisPoints))
+	res.Quo(res, big.NewInt(10_000))
+	if !res.IsInt64() {
+		return 0, apperrors.ErrAmountOverflow
+	}
+	return Rate(res.Int64()), nil
+}
+
+// ParseRate parses a decimal like "92.5" with at most 6 fractional digits.
+func ParseRate(s string) (Rate, error) {
+	whole, fraction, _ := strings.Cut(strings.TrimSpace(s), ".")
+	if len(fraction) > 6 {
+		return 0, fmt.Errorf("rate %q has more than 6 fractional digits", s)
+	}
+	fraction += strings.Repeat("0", 6-len(fraction))
+
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rate %q: %w", s, err)
+	}
+	f, err := strconv.ParseInt(fraction, 10, 64)
+	if err != nil || f < 0 {
+		return 0, fmt.Errorf("invalid rate %q", s)
+	}
+
+	rate := Rate(w*RateScale + f)
+	if rate <= 0 {
+		return 0, fmt.Errorf("rate %q must be positive", s)
+	}
+	return rate, nil
+}
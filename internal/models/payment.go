@@ -0,0 +1,32 @@
+package models
+
+import "time"
+
+type PaymentType string
+
+const PaymentTypeDeposit PaymentType = "deposit"
+const PaymentTypeWithdrawal PaymentType = "withdrawal"
+
+type PaymentStatus string
+
+const PaymentStatusPending PaymentStatus = "pending"
+const PaymentStatusCompleted PaymentStatus = "completed"
+const PaymentStatusFailed PaymentStatus = "failed"
+
+// PaymentStatusReconcile is the payment the provider has made but the ledger could not record,
+// it is reconciled by hand.
+const PaymentStatusReconcile PaymentStatus = "reconcile"
+
+// Payment moves money between the user and the outside world through the payment provider.
+// It stays pending until the provider confirms or rejects it.
+type Payment struct {
+	ID          string        `json:"id"`
+	UserID      int64         `json:"user_id"`
+	Type        PaymentType   `json:"type"`
+	Money       Money         `json:"money"`
+	Status      PaymentStatus `json:"status"`
+	ProviderRef string        `json:"provider_ref"`
+	Reason      string        `json:"reason"`
+	CreatedAt   time.Time     `json:"created_at"`
+	UpdatedAt   time.Time     `json:"updated_at"`
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"fmt"
+	"github.com/robfig/cron"
+	"time"
+)
+
+type ScheduleKind string
+
+const ScheduleKindOnce ScheduleKind = "once"
+const ScheduleKindDaily ScheduleKind = "daily"
+const ScheduleKindWeekly ScheduleKind = "weekly"
+const ScheduleKindMonthly ScheduleKind = "monthly"
+const ScheduleKindCron ScheduleKind = "cron"
+
+type ScheduleStatus string
+
+const ScheduleStatusActive ScheduleStatus = "active"
+const ScheduleStatusPaused ScheduleStatus = "paused"
+const ScheduleStatusCancelled ScheduleStatus = "cancelled"
+const ScheduleStatusCompleted ScheduleStatus = "completed"
+
+// Schedule is a transfer in the default currency made at NextRunAt and then repeated by Kind.
+type Schedule struct {
+	ID        string         `json:"id"`
+	FromID    int64          `json:"from_id"`
+	ToID      int64          `json:"to_id"`
+	Amount    int64          `json:"amount"`
+	Kind      ScheduleKind   `json:"kind"`
+	Cron      string         `json:"cron"` // standard 5 fields spec, for ScheduleKindCron only
+	Status    ScheduleStatus `json:"status"`
+	StartAt   time.Time      `json:"start_at"`    // the first run, the monthly runs fall on its day
+	NextRunAt time.Time      `json:"next_run_at"` // zero when there are no more runs
+	Runs      int64          `json:"runs"`
+	LastError string         `json:"last_error"` // error of the last failed run, the schedule goes on
+	CreatedAt time.Time      `json:"created_at"`
+	UpdatedAt time.Time      `json:"updated_at"`
+}
+
+func (s Schedule) Validate() error {
+	if s.Amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+	switch s.Kind {
+	case ScheduleKindOnce, ScheduleKindDaily, ScheduleKindWeekly, ScheduleKindMonthly:
+		return nil
+	case ScheduleKindCron:
+		_, err := cron.ParseStandard(s.Cron)
+		if err != nil {
+			return fmt.Errorf("invalid cron spec %q: %w", s.Cron, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown schedule kind %q", s.Kind)
+}
+
+// NextRun returns the run following prev, zero time when the schedule has no more runs.
+func (s Schedule) NextRun(prev time.Time) (time.Time, error) {
+	switch s.Kind {
+	case ScheduleKindOnce:
+		return time.Time{}, nil
+	case ScheduleKindDaily:
+		return prev.AddDate(0, 0, 1), nil
+	case ScheduleKindWeekly:
+		return prev.AddDate(0, 0, 7), nil
+	case ScheduleKindMonthly:
+		return s.nextMonthlyRun(prev), nil
+	case ScheduleKindCron:
+		schedule, err := cron.ParseStandard(s.Cron)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid cron spec %q: %w", s.Cron, err)
+		}
+		return schedule.Next(prev), nil
+	}
+	return time.Time{}, fmt.Errorf("unknown schedule kind %q", s.Kind)
+}
+
+// nextMonthlyRun counts the months from StartAt, not from prev, so a run moved to the end of a short month
+// doesn't move the runs after it. The day is clamped to the last day of the month.
+func (s Schedule) nextMonthlyRun(prev time.Time) time.Time {
+	start := s.StartAt
+	if start.IsZero() {
+		start = prev
+	}
+	months := (prev.Year()-start.Year())*12 + int(prev.Month()-start.Month())
+	next := addMonths(start, months)
+	for !next.After(prev) {
+		months++
+		next = addMonths(start, months)
+	}
+	return next
+}
+
+func addMonths(t time.Time, months int) time.Time {
+	firstDay := time.Date(t.Year(), t.Month()+time.Month(months), 1, t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), t.Location())
+	lastDay := firstDay.AddDate(0, 1, -1).Day()
+	return firstDay.AddDate(0, 0, min(t.Day(), lastDay)-1)
+}
+
+// NextRunAfter returns the first run later than now, the runs missed meanwhile are skipped.
+func (s Schedule) NextRunAfter(now time.Time) (time.Time, error) {
+	next := s.NextRunAt
+	if s.Kind == ScheduleKindCron {
+		next = now
+	}
+	for !next.IsZero() && !next.After(now) {
+		var err error
+		next, err = s.NextRun(next)
+		if err != nil {
+			return time.Time{}, err
+		}
+	}
+	return next, nil
+}
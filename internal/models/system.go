@@ -0,0 +1,22 @@
+package models
+
+// SystemAccount is the kind of internal account. System accounts are the other side of the money which
+// enters or leaves users, so the sum of all balances in a currency is zero.
+type SystemAccount string
+
+const SystemAccountBonusPool SystemAccount = "bonus-pool"                   // pays bonuses to users
+const SystemAccountFeeRevenue SystemAccount = "fee-revenue"                 // collects transfer fees
+const SystemAccountTreasury SystemAccount = "treasury"                      // takes the other side of exchanges
+const SystemAccountSuspense SystemAccount = "suspense"                      // keeps money which can't be posted yet
+const SystemAccountExternalSettlement SystemAccount = "external-settlement" // mirrors money outside the system
+const SystemAccountEscrow SystemAccount = "escrow"                          // keeps money of escrows until they are settled
+
+// SystemAccounts is the list of all system accounts, the position defines the account id on the shard.
+var SystemAccounts = []SystemAccount{
+	SystemAccountBonusPool,
+	SystemAccountFeeRevenue,
+	SystemAccountTreasury,
+	SystemAccountSuspense,
+	SystemAccountExternalSettlement,
+	SystemAccountEscrow,
+}
@@ -1,7 +1,144 @@
 package models
 
+import "time"
+
 type TransactionType string
 
 const TransactionTypeDecrease TransactionType = "decrease"
 const TransactionTypeIncrease TransactionType = "increase"
 const TransactionTypeCompensate TransactionType = "compensate"
+
+// TransactionTypeFee is the fee paid by the sender, TransactionTypeFeeIncome is the same fee credited to the
+// revenue account, TransactionTypeFeeReversal takes it back when the transfer is compensated.
+const TransactionTypeFee TransactionType = "fee"
+const TransactionTypeFeeIncome TransactionType = "fee_income"
+const TransactionTypeFeeReversal TransactionType = "fee_reversal"
+
+// Currency exchange by a locked quote: the user pays in one currency and gets another one,
+// the spread is credited to the treasury.
+const TransactionTypeExchangeOut TransactionType = "exchange_out"
+const TransactionTypeExchangeIn TransactionType = "exchange_in"
+const TransactionTypeFXSpread TransactionType = "fx_spread"
+const TransactionTypeFXSpreadReversal TransactionType = "fx_spread_reversal"
+
+// The treasury's side of the exchange and of the transfer to another currency: it takes the source money and
+// pays the target money, TransactionTypeFXTreasuryReversal gives the source money back on compensation.
+const TransactionTypeFXTreasuryIn TransactionType = "fx_treasury_in"
+const TransactionTypeFXTreasuryOut TransactionType = "fx_treasury_out"
+const TransactionTypeFXTreasuryReversal TransactionType = "fx_treasury_reversal"
+
+// Refund of a completed transfer moves money from its recipient back to its sender.
+const TransactionTypeRefundOut TransactionType = "refund_out"
+const TransactionTypeRefundIn TransactionType = "refund_in"
+
+// TransactionTypeRefundCompensate returns the refund to the recipient when the sender can't be credited,
+// it is the compensation of refunds only, since it moves money of blocked users like refunds do.
+const TransactionTypeRefundCompensate TransactionType = "refund_compensate"
+
+// TransactionTypeCreditReversal takes back a credit of the split transfer which is compensated.
+const TransactionTypeCreditReversal TransactionType = "credit_reversal"
+
+// TransactionTypeInterestCharge is the daily interest on the negative balance, TransactionTypeInterestIncome
+// is the total of the charges of the shard credited to the treasury.
+const TransactionTypeInterestCharge TransactionType = "interest_charge"
+const TransactionTypeInterestIncome TransactionType = "interest_income"
+
+// TransactionTypeInterestCredit is the daily interest on the positive balance, TransactionTypeInterestExpense
+// is the total of the payments of the shard debited from the treasury.
+const TransactionTypeInterestCredit TransactionType = "interest_credit"
+const TransactionTypeInterestExpense TransactionType = "interest_expense"
+
+// TransactionStatus is the state of a history entry. A debit becomes compensated once its money was returned.
+type TransactionStatus string
+
+const TransactionStatusPosted TransactionStatus = "posted"
+const TransactionStatusCompensated TransactionStatus = "compensated"
+
+// TransactionDirection tells whether a history entry moved money out of or into the user's balance.
+type TransactionDirection string
+
+const TransactionDirectionOut TransactionDirection = "out"
+const TransactionDirectionIn TransactionDirection = "in"
+
+// OutgoingTransactionTypes are the entry types written on the sender's side of a transfer.
+var OutgoingTransactionTypes = []TransactionType{
+	TransactionTypeDecrease,
+	TransactionTypeFee,
+	TransactionTypeFeeReversal,
+	TransactionTypeExchangeOut,
+	TransactionTypeFXSpreadReversal,
+	TransactionTypeFXTreasuryOut,
+	TransactionTypeFXTreasuryReversal,
+	TransactionTypeRefundOut,
+	TransactionTypeCreditReversal,
+	TransactionTypeInterestCharge,
+	TransactionTypeInterestExpense,
+}
+
+// IncomingTransactionTypes are the entry types written on the recipient's side of a transfer.
+var IncomingTransactionTypes = []TransactionType{
+	TransactionTypeIncrease,
+	TransactionTypeCompensate,
+	TransactionTypeRefundCompensate,
+	TransactionTypeFeeIncome,
+	TransactionTypeExchangeIn,
+	TransactionTypeFXSpread,
+	TransactionTypeFXTreasuryIn,
+	TransactionTypeRefundIn,
+	TransactionTypeInterestIncome,
+	TransactionTypeInterestCredit,
+}
+
+// CompensatingTransactionTypes return the money of a debit to the user.
+var CompensatingTransactionTypes = []TransactionType{
+	TransactionTypeCompensate,
+	TransactionTypeRefundCompensate,
+}
+
+// CompensatedTransactionTypes are the debits of the user which are marked as compensated
+// when the money comes back with one of CompensatingTransactionTypes.
+var CompensatedTransactionTypes = []TransactionType{
+	TransactionTypeDecrease,
+	TransactionTypeFee,
+	TransactionTypeExchangeOut,
+	TransactionTypeRefundOut,
+}
+
+// BlockOverrideTransactionTypes move money of blocked users too: refunds are made by operators,
+// and their compensations return money to where it was.
+var BlockOverrideTransactionTypes = []TransactionType{
+	TransactionTypeRefundCompensate,
+	TransactionTypeRefundOut,
+	TransactionTypeRefundIn,
+	TransactionTypeCreditReversal,
+}
+
+// Transaction is a single entry of the user's statement.
+type Transaction struct {
+	ID             string               `json:"id"`
+	TransferID     string               `json:"transfer_id"`
+	Type           TransactionType      `json:"type"`
+	Direction      TransactionDirection `json:"direction"`
+	UserID         int64                `json:"user_id"`
+	CounterpartyID int64                `json:"counterparty_id"`
+	Amount         int64                `json:"amount"`
+	Currency       Currency             `json:"currency"`
+	BalanceAfter   int64                `json:"balance_after"`
+	Status         TransactionStatus    `json:"status"`
+	CreatedAt      time.Time            `json:"created_at"`
+}
+
+// TransactionFilter narrows down the statement. Zero values mean "no filter".
+type TransactionFilter struct {
+	Direction TransactionDirection
+	Currency  Currency
+	Types     []TransactionType
+	Since     time.Time // inclusive
+	Until     time.Time // exclusive
+}
+
+// TransactionPage is one page of the statement. NextCursor is empty on the last page.
+type TransactionPage struct {
+	Transactions []Transaction `json:"transactions"`
+	NextCursor   string        `json:"next_cursor"`
+}
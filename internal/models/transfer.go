@@ -0,0 +1,24 @@
+package models
+
+import "time"
+
+type TransferStatus string
+
+// TransferStatusPending means the sender was debited, but the recipient was not credited yet.
+const TransferStatusPending TransferStatus = "pending"
+const TransferStatusCompleted TransferStatus = "completed"
+const TransferStatusCompensated TransferStatus = "compensated"
+
+// Transfer is a money transfer assembled from its history entries on the sender's and recipient's shards.
+type Transfer struct {
+	ID        string         `json:"id"`
+	FromID    int64          `json:"from_id"`
+	ToID      int64          `json:"to_id"`
+	Amount    int64          `json:"amount"`
+	Currency  Currency       `json:"currency"`
+	Fee       int64          `json:"fee"`
+	Status    TransferStatus `json:"status"`
+	Refunded  int64          `json:"refunded"` // refunded to the sender so far, in the currency the recipient got
+	CreatedAt time.Time      `json:"created_at"`
+	Entries   []Transaction  `json:"entries"`
+}
@@ -3,10 +3,12 @@ package models
 import "time"
 
 type User struct {
-	ID        int64     `json:"id"`
-	Phone     string    `json:"phone"`
-	Email     string    `json:"email"`
-	Balance   int64     `json:"balance"`
-	CreatedAt time.Time `json:"created_at"`
-	UpdatedAt time.Time `json:"updated_at"`
+	ID               int64     `json:"id"`
+	Phone            string    `json:"phone"`
+	Email            string    `json:"email"`
+	Balance          int64     `json:"balance"`
+	AvailableBalance int64     `json:"available_balance"` // balance without active holds, with the credit line
+	CreditLimit      int64     `json:"credit_limit"`      // the balance may go below zero down to -CreditLimit
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
 }
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"encoding/json"
+	"slices"
+	"time"
+)
+
+// WebhookEventTypes are the events which may be delivered to webhooks.
+var WebhookEventTypes = []EventType{
+	EventTypeUserCreated,
+	EventTypeUserBlocked,
+	EventTypeTransferCompleted,
+	EventTypeTransferCompensated,
+	EventTypeTransferFailed,
+}
+
+// Webhook is an endpoint of an integrator. Every delivery is signed with Secret.
+type Webhook struct {
+	ID         string      `json:"id"`
+	URL        string      `json:"url"`
+	Secret     string      `json:"secret"`
+	EventTypes []EventType `json:"event_types"` // all of WebhookEventTypes if empty
+	CreatedAt  time.Time   `json:"created_at"`
+}
+
+// Accepts tells whether the event is delivered to the webhook.
+func (w Webhook) Accepts(eventType EventType) bool {
+	if len(w.EventTypes) == 0 {
+		return slices.Contains(WebhookEventTypes, eventType)
+	}
+	return slices.Contains(w.EventTypes, eventType)
+}
+
+type WebhookDeliveryStatus string
+
+const WebhookDeliveryStatusPending WebhookDeliveryStatus = "pending"
+const WebhookDeliveryStatusDelivered WebhookDeliveryStatus = "delivered"
+const WebhookDeliveryStatusFailed WebhookDeliveryStatus = "failed" // all attempts failed, may be redelivered
+
+// WebhookDelivery is the log entry of one event sent to one webhook.
+type WebhookDelivery struct {
+	ID           string                `json:"id"`
+	WebhookID    string                `json:"webhook_id"`
+	EventType    EventType             `json:"event_type"`
+	Payload      json.RawMessage       `json:"payload"` // the body sent to the webhook
+	Status       WebhookDeliveryStatus `json:"status"`
+	Attempts     int                   `json:"attempts"`
+	ResponseCode int                   `json:"response_code"` // of the last attempt, 0 if there was no response
+	LastError    string                `json:"last_error"`
+	CreatedAt    time.Time             `json:"created_at"`
+	UpdatedAt    time.Time             `json:"updated_at"`
+}
@@ -0,0 +1,144 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+	"usershards/internal/logger"
+	"usershards/internal/models"
+	"usershards/internal/shard"
+)
+
+const defaultBatchSize = 100
+const defaultInterval = time.Second
+
+// Relay publishes the events of the outbox tables of all user shards to the sink.
+// A batch is marked as published only after the sink accepts it, so delivery is at least once.
+// The batch rows stay locked while they are published, so concurrent relays don't reorder
+// the events of a user.
+type Relay struct {
+	shardManager *shard.ShardManager
+	sink         Sink
+	batchSize    int
+	interval     time.Duration
+}
+
+// NewRelay creates the relay, zero batchSize and interval mean the defaults.
+func NewRelay(shardManager *shard.ShardManager, sink Sink, batchSize int, interval time.Duration) *Relay {
+	if batchSize <= 0 {
+		batchSize = defaultBatchSize
+	}
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	return &Relay{
+		shardManager: shardManager,
+		sink:         sink,
+		batchSize:    batchSize,
+		interval:     interval,
+	}
+}
+
+// Run polls every shard until ctx is done.
+func (r *Relay) Run(ctx context.Context) {
+	wg := sync.WaitGroup{}
+	for shardID := range r.shardManager.UserShards {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			ticker := time.NewTicker(r.interval)
+			defer ticker.Stop()
+			for {
+				if _, err := r.publishShard(ctx, shardID); err != nil && ctx.Err() == nil {
+					logger.L().Error("failed to publish outbox", zap.Int("shard", shardID), zap.Error(err))
+				}
+
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// PublishPending publishes all events which are pending now and returns how many were published.
+func (r *Relay) PublishPending(ctx context.Context) (int, error) {
+	total := 0
+	for shardID := range r.shardManager.UserShards {
+		published, err := r.publishShard(ctx, shardID)
+		total += published
+		if err != nil {
+			return total, err
+		}
+	}
+
+	return total, nil
+}
+
+func (r *Relay) publishShard(ctx context.Context, shardID int) (int, error) {
+	total := 0
+	for {
+		published, err := r.publishBatch(ctx, shardID)
+		total += published
+		if err != nil || published < r.batchSize {
+			return total, err
+		}
+	}
+}
+
+func (r *Relay) publishBatch(ctx context.Context, shardID int) (int, error) {
+	userDB, ok := r.shardManager.UserShards[shardID]
+	if !ok {
+		return 0, fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	var events []models.Event
+	err := shard.WithTransaction(ctx, userDB, func(tx pgx.Tx) error {
+		const selectEvents = `SELECT id, user_id, type, payload, created_at FROM outbox
+							  WHERE published_at IS NULL ORDER BY id LIMIT $1 FOR UPDATE`
+		rows, err := tx.Query(ctx, selectEvents, r.batchSize)
+		if err != nil {
+			return fmt.Errorf("failed to select events: %w", err)
+		}
+		events, err = pgx.CollectRows(rows, func(row pgx.CollectableRow) (models.Event, error) {
+			event := models.Event{Shard: shardID}
+			err := row.Scan(&event.ID, &event.UserID, &event.Type, &event.Payload, &event.CreatedAt)
+			return event, err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to read events: %w", err)
+		}
+		if len(events) == 0 {
+			return nil
+		}
+
+		if err = r.sink.Publish(ctx, events); err != nil {
+			return fmt.Errorf("failed to publish events: %w", err)
+		}
+
+		const markPublished = `UPDATE outbox SET published_at = $1 WHERE id = ANY($2)`
+		ids := make([]int64, 0, len(events))
+		for _, event := range events {
+			ids = append(ids, event.ID)
+		}
+		_, err = tx.Exec(ctx, markPublished, time.Now().UTC(), ids)
+		if err != nil {
+			return fmt.Errorf("failed to mark events as published: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return len(events), nil
+}
@@ -0,0 +1,113 @@
+package outbox
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"usershards/internal/models"
+)
+
+// Sink receives the published events. Publish is retried with the same events until it succeeds,
+// so a sink must tolerate duplicates.
+type Sink interface {
+	Publish(ctx context.Context, events []models.Event) error
+}
+
+// WriterSink writes the events as JSON lines.
+type WriterSink struct {
+	mu      sync.Mutex
+	encoder *json.Encoder
+}
+
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{encoder: json.NewEncoder(w)}
+}
+
+// NewFileSink appends the events to the file, "" or "-" means stdout.
+func NewFileSink(path string) (*WriterSink, error) {
+	if path == "" || path == "-" {
+		return NewWriterSink(os.Stdout), nil
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open outbox file: %w", err)
+	}
+	return NewWriterSink(file), nil
+}
+
+func (s *WriterSink) Publish(ctx context.Context, events []models.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, event := range events {
+		if err := s.encoder.Encode(event); err != nil {
+			return fmt.Errorf("failed to write event: %w", err)
+		}
+	}
+	return nil
+}
+
+// MemorySink keeps the events in memory, for tests.
+type MemorySink struct {
+	mu     sync.Mutex
+	events []models.Event
+}
+
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+func (s *MemorySink) Publish(ctx context.Context, events []models.Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.events = append(s.events, events...)
+	return nil
+}
+
+// Events returns the events of the user in the order they were published, all events if userID is 0.
+func (s *MemorySink) Events(userID int64) []models.Event {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if userID == 0 {
+		return slices.Clone(s.events)
+	}
+
+	var events []models.Event
+	for _, event := range s.events {
+		if event.UserID == userID {
+			events = append(events, event)
+		}
+	}
+	return events
+}
+
+// SinkFunc lets a function be a sink.
+type SinkFunc func(ctx context.Context, events []models.Event) error
+
+func (f SinkFunc) Publish(ctx context.Context, events []models.Event) error {
+	return f(ctx, events)
+}
+
+// FanoutSink publishes the events to every sink in order. When one of them fails the whole batch is
+// published again, so the sinks before it get the events twice.
+type FanoutSink []Sink
+
+func NewFanoutSink(sinks ...Sink) FanoutSink {
+	return sinks
+}
+
+func (s FanoutSink) Publish(ctx context.Context, events []models.Event) error {
+	for _, sink := range s {
+		if err := sink.Publish(ctx, events); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,100 @@
+package payments
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"usershards/internal/apperrors"
+	"usershards/internal/models"
+)
+
+// FakePayment is the request received by FakeProvider.
+type FakePayment struct {
+	ID       string
+	Type     models.PaymentType
+	UserID   int64
+	Money    models.Money
+	Refunded bool
+	Canceled bool
+}
+
+// FakeProvider keeps the requests in memory and accepts them all unless declined by Decline.
+type FakeProvider struct {
+	mu       sync.Mutex
+	payments map[string]*FakePayment
+	declined map[int64]struct{}
+}
+
+func NewFakeProvider() *FakeProvider {
+	return &FakeProvider{
+		payments: make(map[string]*FakePayment),
+		declined: make(map[int64]struct{}),
+	}
+}
+
+// Decline makes the provider reject the requests of the user.
+func (p *FakeProvider) Decline(userID int64) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.declined[userID] = struct{}{}
+}
+
+func (p *FakeProvider) Charge(ctx context.Context, paymentID string, userID int64, money models.Money) (string, error) {
+	return p.accept(paymentID, models.PaymentTypeDeposit, userID, money)
+}
+
+func (p *FakeProvider) Payout(ctx context.Context, paymentID string, userID int64, money models.Money) (string, error) {
+	return p.accept(paymentID, models.PaymentTypeWithdrawal, userID, money)
+}
+
+func (p *FakeProvider) Refund(ctx context.Context, paymentID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, ok := p.payments[paymentID]
+	if !ok {
+		return apperrors.ErrPaymentNotFound
+	}
+	payment.Refunded = true
+
+	return nil
+}
+
+func (p *FakeProvider) Cancel(ctx context.Context, paymentID string) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, ok := p.payments[paymentID]
+	if !ok {
+		return apperrors.ErrPaymentNotFound
+	}
+	payment.Canceled = true
+
+	return nil
+}
+
+// Payment returns a copy of the received request.
+func (p *FakeProvider) Payment(paymentID string) (FakePayment, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	payment, ok := p.payments[paymentID]
+	if !ok {
+		return FakePayment{}, false
+	}
+	return *payment, true
+}
+
+func (p *FakeProvider) accept(paymentID string, paymentType models.PaymentType, userID int64, money models.Money) (string, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if _, ok := p.declined[userID]; ok {
+		return "", apperrors.ErrPaymentDeclined
+	}
+	if _, ok := p.payments[paymentID]; !ok {
+		p.payments[paymentID] = &FakePayment{ID: paymentID, Type: paymentType, UserID: userID, Money: money}
+	}
+
+	return fmt.Sprintf("fake-%s", paymentID), nil
+}
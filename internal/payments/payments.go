@@ -0,0 +1,22 @@
+package payments
+
+import (
+	"context"
+	"usershards/internal/models"
+)
+
+// Provider moves money between the system and the outside world. The requests are asynchronous:
+// the provider accepts them and calls back later with the result, the callback is delivered
+// to the payment workflow by UserSagaWorkflow.PaymentCallback.
+// Requests are retried, so the provider must deduplicate them by paymentID.
+type Provider interface {
+	// Charge asks the provider to collect money from the user for the deposit.
+	Charge(ctx context.Context, paymentID string, userID int64, money models.Money) (ref string, err error)
+	// Payout asks the provider to send money to the user for the withdrawal.
+	Payout(ctx context.Context, paymentID string, userID int64, money models.Money) (ref string, err error)
+	// Refund returns the collected money of the deposit which could not be credited to the user.
+	Refund(ctx context.Context, paymentID string) error
+	// Cancel withdraws the request which got no callback in time. The provider must not execute it anymore,
+	// and must return the money if it already has.
+	Cancel(ctx context.Context, paymentID string) error
+}
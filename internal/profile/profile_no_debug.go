@@ -2,14 +2,5 @@
 
 package profile
 
-import (
-	"log"
-	"net/http"
-)
-
-func StartPprof() {
-	go func() {
-		log.Println("pprof enabled on :6060")
-		log.Println(http.ListenAndServe("localhost:6060", nil))
-	}()
-}
+// StartPprof does nothing, pprof is served by the debug builds only.
+func StartPprof() {}
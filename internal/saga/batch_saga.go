@@ -0,0 +1,250 @@
+package saga
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/workflow"
+	"time"
+	"usershards/internal/models"
+)
+
+const defaultBatchConcurrency = 10
+
+// batchItemsPerExecution bounds the history of the batch workflow, it continues as new after that many items.
+const batchItemsPerExecution = 500
+
+// batchHoldTTL is how long the batch total stays reserved from the start of every part of the batch,
+// the batch releases the rest when it is done.
+const batchHoldTTL = 24 * time.Hour
+
+type BatchItemStatus = models.BatchItemStatus
+
+const BatchItemStatusCompleted = models.BatchItemStatusCompleted
+const BatchItemStatusFailed = models.BatchItemStatusFailed
+
+type BatchItem struct {
+	To     int64
+	Amount int64
+}
+
+type BatchItemResult struct {
+	To            int64
+	Amount        int64
+	Fee           int64
+	TransactionID string
+	Status        BatchItemStatus
+	Error         string
+}
+
+// BatchReport is the result of the batch, the items go in the order they were given.
+type BatchReport struct {
+	BatchID   string
+	From      int64
+	Total     int64 // reserved on the sender, with the fees
+	Completed int
+	Failed    int
+	Items     []BatchItemResult
+}
+
+// BatchCursor is the state of BatchWorkflow, the items and their results are kept on the sender's shard,
+// so the size of the batch doesn't grow the workflow input.
+type BatchCursor struct {
+	BatchID     string
+	From        int64
+	Concurrency int
+	Count       int   // the number of the items
+	Total       int64 // reserved on the sender, with the fees
+	Next        int   // the first item which is not processed yet
+	Completed   int
+	Failed      int
+}
+
+// BatchTransfer pays every item from one sender in the default currency. The total with the fees is reserved
+// on the sender first, so the batch fails at once when the sender can't pay all the items. Then the items are
+// transferred by child TransferMoneyWorkflow runs, at most concurrency at a time. A failed item doesn't stop
+// the batch, its money is returned to the sender with the rest of the reservation.
+func (s *UserSagaWorkflow) BatchTransfer(
+	ctx context.Context,
+	from int64,
+	items []BatchItem,
+	concurrency int,
+) (*BatchReport, error) {
+	if len(items) == 0 {
+		return nil, fmt.Errorf("batch is empty")
+	}
+	if concurrency <= 0 {
+		concurrency = defaultBatchConcurrency
+	}
+
+	batchID, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	batchItems := make([]models.BatchItem, 0, len(items))
+	for _, item := range items {
+		if item.Amount <= 0 {
+			return nil, fmt.Errorf("amount must be positive")
+		}
+		transactionID, err := uuid.NewV7()
+		if err != nil {
+			return nil, err
+		}
+		batchItems = append(batchItems, models.BatchItem{
+			To:            item.To,
+			Amount:        item.Amount,
+			TransactionID: transactionID.String(),
+		})
+	}
+
+	total, err := s.userService.CreateBatch(ctx, batchID.String(), from, batchItems)
+	if err != nil {
+		return nil, err
+	}
+
+	cursor := BatchCursor{
+		BatchID:     batchID.String(),
+		From:        from,
+		Concurrency: concurrency,
+		Count:       len(batchItems),
+		Total:       total,
+	}
+
+	workflowOptions := client.StartWorkflowOptions{
+		ID:        "batch-" + cursor.BatchID,
+		TaskQueue: TransferTaskQueue,
+	}
+
+	we, err := s.temporalClient.ExecuteWorkflow(ctx, workflowOptions, s.BatchWorkflow, cursor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to start workflows: %w", err)
+	}
+
+	err = we.Get(ctx, &cursor)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get workflows result: %w", err)
+	}
+
+	results, err := s.userService.GetBatchItems(ctx, cursor.BatchID, from, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	report := &BatchReport{
+		BatchID:   cursor.BatchID,
+		From:      from,
+		Total:     cursor.Total,
+		Completed: cursor.Completed,
+		Failed:    cursor.Failed,
+		Items:     make([]BatchItemResult, 0, len(results)),
+	}
+	for _, result := range results {
+		report.Items = append(report.Items, BatchItemResult{
+			To:            result.To,
+			Amount:        result.Amount,
+			Fee:           result.Fee,
+			TransactionID: result.TransactionID,
+			Status:        result.Status,
+			Error:         result.Error,
+		})
+	}
+
+	return report, nil
+}
+
+// BatchWorkflow reserves the total of the batch and transfers its items by parts, the part's results are saved
+// before the workflow continues as new. The hold is extended with every part, so a long batch keeps its money.
+func (s *UserSagaWorkflow) BatchWorkflow(ctx workflow.Context, cursor BatchCursor) (BatchCursor, error) {
+	ctx = workflow.WithActivityOptions(ctx, s.getDefaultOptions())
+	logger := workflow.GetLogger(ctx)
+	logger.Debug("BatchWorkflow start")
+
+	holdParams := HoldParams{
+		HoldID:    cursor.BatchID,
+		UserID:    cursor.From,
+		Amount:    cursor.Total,
+		ExpiresAt: workflow.Now(ctx).Add(batchHoldTTL),
+		Batch:     true,
+	}
+
+	hold := s.HoldMoney
+	if cursor.Next > 0 {
+		hold = s.ExtendHeldMoney
+	}
+	err := workflow.ExecuteActivity(ctx, hold, holdParams).Get(ctx, nil)
+	if err != nil {
+		return cursor, err
+	}
+
+	var items []models.BatchItem
+	err = workflow.ExecuteActivity(ctx, s.LoadBatchItems, cursor, batchItemsPerExecution).Get(ctx, &items)
+	if err != nil {
+		return cursor, err
+	}
+
+	pending := 0
+	selector := workflow.NewSelector(ctx)
+	for i := range items {
+		if pending == cursor.Concurrency {
+			selector.Select(ctx)
+			pending--
+		}
+
+		item := &items[i]
+		childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+			WorkflowID: fmt.Sprintf("batch-%s-%d", cursor.BatchID, item.Position),
+			TaskQueue:  TransferTaskQueue,
+		})
+		transferParams := TransferMoneyParams{
+			From:          cursor.From,
+			To:            item.To,
+			TransactionID: item.TransactionID,
+			Amount:        item.Amount,
+			HoldID:        cursor.BatchID,
+		}
+		future := workflow.ExecuteChildWorkflow(childCtx, s.TransferMoneyWorkflow, transferParams)
+		selector.AddFuture(future, func(f workflow.Future) {
+			if err := f.Get(ctx, nil); err != nil {
+				item.Status = models.BatchItemStatusFailed
+				item.Error = err.Error()
+				cursor.Failed++
+				return
+			}
+			item.Status = models.BatchItemStatusCompleted
+			cursor.Completed++
+		})
+		pending++
+	}
+	for ; pending > 0; pending-- {
+		selector.Select(ctx)
+	}
+
+	err = workflow.ExecuteActivity(ctx, s.FinishBatchItems, cursor, items).Get(ctx, nil)
+	if err != nil {
+		return cursor, err
+	}
+
+	cursor.Next += len(items)
+	if len(items) > 0 && cursor.Next < cursor.Count {
+		return cursor, workflow.NewContinueAsNewError(ctx, s.BatchWorkflow, cursor)
+	}
+
+	// the money of the failed items and their fees go back to the sender
+	err = workflow.ExecuteActivity(ctx, s.ReleaseHeldMoney, holdParams).Get(ctx, nil)
+	if err != nil {
+		return cursor, err
+	}
+
+	logger.Debug("BatchWorkflow stop")
+	return cursor, nil
+}
+
+func (s *UserSagaWorkflow) LoadBatchItems(ctx context.Context, cursor BatchCursor, limit int) ([]models.BatchItem, error) {
+	return s.userService.GetBatchItems(ctx, cursor.BatchID, cursor.From, cursor.Next, limit)
+}
+
+func (s *UserSagaWorkflow) FinishBatchItems(ctx context.Context, cursor BatchCursor, items []models.BatchItem) error {
+	return s.userService.FinishBatchItems(ctx, cursor.BatchID, cursor.From, items)
+}
@@ -0,0 +1,240 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/models"
+	"usershards/internal/tracing"
+)
+
+const EscrowReleaseSignal = "escrow-release"
+const EscrowDisputeSignal = "escrow-dispute"
+const EscrowStatusQuery = "escrow-status"
+const EscrowParamsQuery = "escrow-params"
+
+type EscrowParams struct {
+	EscrowID  string
+	Buyer     int64
+	Seller    int64
+	Amount    int64
+	ExpiresAt time.Time // the money is released to the seller then, unless the buyer disputes earlier
+}
+
+func (p EscrowParams) SpanAttributes() []attribute.KeyValue {
+	return tracing.Transfer(p.EscrowID, p.Buyer, p.Seller)
+}
+
+func (p EscrowParams) LogFields() []zap.Field {
+	return transferLogFields(p.EscrowID, p.Buyer, p.Seller)
+}
+
+func escrowWorkflowID(escrowID string) string {
+	return "escrow-" + escrowID
+}
+
+// Escrow debits amount in the default currency from the buyer into the escrow account. The money is paid
+// to the seller on release or when the escrow expires after ttl, and returned to the buyer on dispute.
+// The escrow is settled in the background, GetEscrowStatus shows whether the buyer has been debited.
+func (s *UserSagaWorkflow) Escrow(ctx context.Context, buyer, seller, amount int64, ttl time.Duration) (string, error) {
+	if amount <= 0 {
+		return "", fmt.Errorf("amount must be positive")
+	}
+
+	escrowID, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+
+	params := EscrowParams{
+		EscrowID:  escrowID.String(),
+		Buyer:     buyer,
+		Seller:    seller,
+		Amount:    amount,
+		ExpiresAt: time.Now().Add(ttl).UTC(),
+	}
+
+	workflowOptions := client.StartWorkflowOptions{
+		ID:        escrowWorkflowID(params.EscrowID),
+		TaskQueue: TransferTaskQueue,
+	}
+
+	_, err = s.temporalClient.ExecuteWorkflow(ctx, workflowOptions, s.EscrowWorkflow, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to start workflows: %w", err)
+	}
+
+	return params.EscrowID, nil
+}
+
+// ReleaseEscrow pays the escrow to the seller, only the buyer can release it. It waits until the escrow
+// is settled and returns its status, which is not models.EscrowStatusReleased when the escrow has been
+// settled otherwise.
+func (s *UserSagaWorkflow) ReleaseEscrow(ctx context.Context, escrowID string, buyerID int64) (models.EscrowStatus, error) {
+	params, err := s.getEscrow(ctx, escrowID)
+	if err != nil {
+		return "", err
+	}
+	if params.Buyer != buyerID {
+		return "", apperrors.ErrEscrowNotFound
+	}
+
+	return s.settleEscrow(ctx, escrowID, EscrowReleaseSignal)
+}
+
+// DisputeEscrow returns the escrow to the buyer, either party can dispute it. It waits until the escrow
+// is settled and returns its status, which is not models.EscrowStatusRefunded when the escrow has been
+// settled otherwise.
+func (s *UserSagaWorkflow) DisputeEscrow(ctx context.Context, escrowID string, userID int64) (models.EscrowStatus, error) {
+	params, err := s.getEscrow(ctx, escrowID)
+	if err != nil {
+		return "", err
+	}
+	if params.Buyer != userID && params.Seller != userID {
+		return "", apperrors.ErrEscrowNotFound
+	}
+
+	return s.settleEscrow(ctx, escrowID, EscrowDisputeSignal)
+}
+
+// getEscrow returns the params the escrow has been started with, the parties are checked against them.
+func (s *UserSagaWorkflow) getEscrow(ctx context.Context, escrowID string) (EscrowParams, error) {
+	var params EscrowParams
+	value, err := s.temporalClient.QueryWorkflow(ctx, escrowWorkflowID(escrowID), "", EscrowParamsQuery)
+	if err != nil {
+		var notFound *serviceerror.NotFound
+		if errors.As(err, &notFound) {
+			return params, apperrors.ErrEscrowNotFound
+		}
+		return params, fmt.Errorf("failed to query workflows: %w", err)
+	}
+
+	if err = value.Get(&params); err != nil {
+		return params, fmt.Errorf("failed to decode escrow params: %w", err)
+	}
+
+	return params, nil
+}
+
+func (s *UserSagaWorkflow) GetEscrowStatus(ctx context.Context, escrowID string) (models.EscrowStatus, error) {
+	value, err := s.temporalClient.QueryWorkflow(ctx, escrowWorkflowID(escrowID), "", EscrowStatusQuery)
+	if err != nil {
+		return "", fmt.Errorf("failed to query workflows: %w", err)
+	}
+
+	var status models.EscrowStatus
+	if err = value.Get(&status); err != nil {
+		return "", fmt.Errorf("failed to decode escrow status: %w", err)
+	}
+
+	return status, nil
+}
+
+func (s *UserSagaWorkflow) settleEscrow(ctx context.Context, escrowID, signal string) (models.EscrowStatus, error) {
+	err := s.temporalClient.SignalWorkflow(ctx, escrowWorkflowID(escrowID), "", signal, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to signal workflows: %w", err)
+	}
+
+	var status models.EscrowStatus
+	err = s.temporalClient.GetWorkflow(ctx, escrowWorkflowID(escrowID), "").Get(ctx, &status)
+	if err != nil {
+		return "", fmt.Errorf("failed to get workflows result: %w", err)
+	}
+
+	return status, nil
+}
+
+// EscrowWorkflow moves the money buyer -> escrow -> seller or buyer by child TransferMoneyWorkflow runs.
+// The signals sent while the buyer is being debited are handled after that.
+func (s *UserSagaWorkflow) EscrowWorkflow(ctx workflow.Context, params EscrowParams) (models.EscrowStatus, error) {
+	ctx = workflow.WithActivityOptions(ctx, s.getDefaultOptions())
+	logger := workflow.GetLogger(ctx)
+	logger.Debug("EscrowWorkflow start")
+
+	status := models.EscrowStatusPending
+	err := workflow.SetQueryHandler(ctx, EscrowStatusQuery, func() (models.EscrowStatus, error) {
+		return status, nil
+	})
+	if err != nil {
+		return status, err
+	}
+	err = workflow.SetQueryHandler(ctx, EscrowParamsQuery, func() (EscrowParams, error) {
+		return params, nil
+	})
+	if err != nil {
+		return status, err
+	}
+
+	escrowAccount := s.userService.SystemAccount(models.SystemAccountEscrow)
+	err = s.escrowTransfer(ctx, params, "in", params.Buyer, escrowAccount)
+	if err != nil {
+		logger.Error("escrow is not funded", zap.Error(err))
+		status = models.EscrowStatusFailed
+		return status, nil
+	}
+	status = models.EscrowStatusHeld
+
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	defer cancelTimer()
+
+	release := true
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(workflow.GetSignalChannel(ctx, EscrowReleaseSignal), func(c workflow.ReceiveChannel, more bool) {
+		c.Receive(ctx, nil)
+	})
+	selector.AddReceive(workflow.GetSignalChannel(ctx, EscrowDisputeSignal), func(c workflow.ReceiveChannel, more bool) {
+		c.Receive(ctx, nil)
+		release = false
+	})
+	selector.AddFuture(workflow.NewTimer(timerCtx, params.ExpiresAt.Sub(workflow.Now(ctx))), func(f workflow.Future) {
+		logger.Debug("escrow expired")
+	})
+	selector.Select(ctx)
+
+	if release {
+		err = s.escrowTransfer(ctx, params, "out", escrowAccount, params.Seller)
+		if err == nil {
+			status = models.EscrowStatusReleased
+			logger.Debug("EscrowWorkflow released")
+			return status, nil
+		}
+		// the seller can't be paid, e.g. is blocked, so the money goes back to the buyer
+		logger.Error("escrow is not released", zap.Error(err))
+	}
+
+	err = s.escrowTransfer(ctx, params, "refund", escrowAccount, params.Buyer)
+	if err != nil {
+		// the money stays on the escrow account, an operator has to move it
+		return status, err
+	}
+	status = models.EscrowStatusRefunded
+
+	logger.Debug("EscrowWorkflow refunded")
+	return status, nil
+}
+
+// escrowTransfer runs one leg of the escrow. The transaction ID is derived from the escrow, so the leg
+// is posted once even if the workflow is retried.
+func (s *UserSagaWorkflow) escrowTransfer(ctx workflow.Context, params EscrowParams, leg string, from, to int64) error {
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID: escrowWorkflowID(params.EscrowID) + "-" + leg,
+		TaskQueue:  TransferTaskQueue,
+	})
+	transferParams := TransferMoneyParams{
+		From:          from,
+		To:            to,
+		TransactionID: uuid.NewSHA1(uuid.MustParse(params.EscrowID), []byte(leg)).String(),
+		Amount:        params.Amount,
+	}
+
+	return workflow.ExecuteChildWorkflow(childCtx, s.TransferMoneyWorkflow, transferParams).Get(ctx, nil)
+}
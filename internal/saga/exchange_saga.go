@@ -0,0 +1,260 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"go.opentelemetry.io/otel/attribute"
+	"go.temporal.io/api/enums/v1"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
+	"usershards/internal/apperrors"
+	"usershards/internal/logger"
+	"usershards/internal/models"
+	"usershards/internal/tracing"
+)
+
+type exchangeStep int
+
+const exchangeStepNoCompensations exchangeStep = 0
+const exchangeStepSpreadFailed exchangeStep = 1
+const exchangeStepCreditFailed exchangeStep = 2
+
+type ExchangeParams struct {
+	QuoteID  string
+	UserID   int64
+	Quote    models.FXQuote // filled by the workflow when the quote is accepted
+	Treasury int64          // filled by the workflow when the quote is accepted
+}
+
+func (p ExchangeParams) SpanAttributes() []attribute.KeyValue {
+	return append(tracing.User(p.UserID), tracing.TransferIDKey.String(p.QuoteID))
+}
+
+func (p ExchangeParams) LogFields() []zap.Field {
+	return append(userLogFields(p.UserID), logger.TransferID(p.QuoteID))
+}
+
+// Exchange converts money of the user by the quote from QuoteFX. The quote is checked for expiration by the saga.
+func (s *UserSagaWorkflow) Exchange(ctx context.Context, userID int64, quoteID string) error {
+	// one quote can be exchanged only once, the workflow id guards it too
+	workflowOptions := client.StartWorkflowOptions{
+		ID:                    "exchange-" + quoteID,
+		TaskQueue:             TransferTaskQueue,
+		WorkflowIDReusePolicy: enums.WORKFLOW_ID_REUSE_POLICY_REJECT_DUPLICATE,
+	}
+
+	params := ExchangeParams{
+		QuoteID: quoteID,
+		UserID:  userID,
+	}
+
+	we, err := s.temporalClient.ExecuteWorkflow(ctx, workflowOptions, s.ExchangeWorkflow, params)
+	if err != nil {
+		return fmt.Errorf("failed to start workflows: %w", err)
+	}
+
+	err = we.Get(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get workflows result: %w", err)
+	}
+
+	return nil
+}
+
+func (s *UserSagaWorkflow) ExchangeWorkflow(ctx workflow.Context, params ExchangeParams) error {
+	ctx = workflow.WithActivityOptions(ctx, s.getDefaultOptions())
+	logger := workflow.GetLogger(ctx)
+	logger.Debug("ExchangeWorkflow start")
+
+	err := workflow.ExecuteActivity(ctx, s.AcceptQuote, params).Get(ctx, &params)
+	if err != nil {
+		return s.ExchangeCompensations(ctx, exchangeStepNoCompensations, err, params)
+	}
+
+	ledgerCtx := workflow.WithActivityOptions(ctx, s.getLedgerOptions())
+	err = workflow.ExecuteActivity(ledgerCtx, s.DebitExchange, params).Get(ctx, nil)
+	if err != nil {
+		return s.ExchangeCompensations(ctx, exchangeStepNoCompensations, err, params)
+	}
+
+	if params.Quote.Spread.Amount > 0 {
+		err = workflow.ExecuteActivity(ctx, s.CreditSpread, params).Get(ctx, nil)
+		if err != nil {
+			return s.ExchangeCompensations(ctx, exchangeStepSpreadFailed, err, params)
+		}
+	}
+
+	err = workflow.ExecuteActivity(ledgerCtx, s.CreditExchange, params).Get(ctx, nil)
+	if err != nil {
+		return s.ExchangeCompensations(ctx, exchangeStepCreditFailed, err, params)
+	}
+
+	logger.Debug("ExchangeWorkflow stop")
+	return nil
+}
+
+func (s *UserSagaWorkflow) AcceptQuote(ctx context.Context, params ExchangeParams) (ExchangeParams, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("AcceptQuote start")
+	quote, err := s.userService.AcceptQuote(ctx, params.QuoteID, params.UserID)
+	if err != nil {
+		logger.Error("AcceptQuote fails", zap.Error(err))
+		if errors.Is(err, apperrors.ErrQuoteExpired) {
+			return params, temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrQuoteExpired", apperrors.ErrQuoteExpired)
+		}
+		if errors.Is(err, apperrors.ErrQuoteNotFound) {
+			return params, temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrQuoteNotFound", apperrors.ErrQuoteNotFound)
+		}
+		if errors.Is(err, apperrors.ErrQuoteUsed) {
+			return params, temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrQuoteUsed", apperrors.ErrQuoteUsed)
+		}
+		return params, err
+	}
+
+	params.Quote = *quote
+	params.Treasury = s.userService.FXTreasuryAccount()
+	return params, nil
+}
+
+// DebitExchange takes the source money from the user to the treasury. The user is debited first, so the treasury
+// gets the money only once the user has paid.
+func (s *UserSagaWorkflow) DebitExchange(ctx context.Context, params ExchangeParams) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("DebitExchange start")
+	err := s.userService.DecreaseMoneyFromUser(ctx, params.QuoteID, models.TransactionTypeExchangeOut, params.UserID, params.Treasury, params.Quote.Source, models.Fee{})
+	if err == nil {
+		err = s.userService.IncreaseMoneyToUser(ctx, params.QuoteID, models.TransactionTypeFXTreasuryIn, params.UserID, params.Treasury, params.Quote.Source)
+	}
+	if err != nil {
+		logger.Error("DebitExchange fails", zap.Error(err))
+		if errors.Is(err, apperrors.ErrUserIsBlocked) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrUserIsBlocked", apperrors.ErrUserIsBlocked)
+		}
+		if errors.Is(err, apperrors.ErrInsufficientFunds) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrInsufficientFunds", apperrors.ErrInsufficientFunds)
+		}
+	}
+
+	return err
+}
+
+func (s *UserSagaWorkflow) CreditSpread(ctx context.Context, params ExchangeParams) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("CreditSpread start")
+	err := s.userService.IncreaseMoneyToUser(ctx, params.QuoteID, models.TransactionTypeFXSpread, params.UserID, params.Treasury, params.Quote.Spread)
+	if err != nil {
+		logger.Error("CreditSpread fails", zap.Error(err))
+	}
+
+	return err
+}
+
+// CreditExchange pays the target money to the user from the treasury. The treasury pays the mid value, the target
+// and the spread, the spread being its income booked by CreditSpread.
+func (s *UserSagaWorkflow) CreditExchange(ctx context.Context, params ExchangeParams) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("CreditExchange start")
+	err := s.userService.IncreaseMoneyToUser(ctx, params.QuoteID, models.TransactionTypeExchangeIn, params.Treasury, params.UserID, params.Quote.Target)
+	if err == nil {
+		midValue := models.NewMoney(params.Quote.Target.Amount+params.Quote.Spread.Amount, params.Quote.Target.Currency)
+		err = s.userService.DecreaseMoneyFromUser(ctx, params.QuoteID, models.TransactionTypeFXTreasuryOut, params.Treasury, params.UserID, midValue, models.Fee{})
+	}
+	if err != nil {
+		logger.Error("CreditExchange fails", zap.Error(err))
+		if errors.Is(err, apperrors.ErrUserIsBlocked) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrUserIsBlocked", apperrors.ErrUserIsBlocked)
+		}
+	}
+
+	return err
+}
+
+func (s *UserSagaWorkflow) ReverseSpread(ctx context.Context, params ExchangeParams) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("ReverseSpread start")
+	err := s.userService.DecreaseMoneyFromUser(ctx, params.QuoteID, models.TransactionTypeFXSpreadReversal, params.Treasury, params.UserID, params.Quote.Spread, models.Fee{})
+	if err != nil {
+		logger.Error("ReverseSpread fails", zap.Error(err))
+	}
+
+	return err
+}
+
+// CompensateExchange returns the source money from the treasury to the user. Both entries reuse the quote id,
+// they are told apart by their types in the idempotence key.
+func (s *UserSagaWorkflow) CompensateExchange(ctx context.Context, params ExchangeParams) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("CompensateExchange start")
+	err := s.userService.DecreaseMoneyFromUser(ctx, params.QuoteID, models.TransactionTypeFXTreasuryReversal, params.Treasury, params.UserID, params.Quote.Source, models.Fee{})
+	if err == nil {
+		err = s.userService.IncreaseMoneyToUser(ctx, params.QuoteID, models.TransactionTypeCompensate, params.Treasury, params.UserID, params.Quote.Source)
+	}
+	if err != nil {
+		logger.Error("CompensateExchange fails", zap.Error(err))
+	}
+
+	return err
+}
+
+// CompensateQuote marks the quote of the compensated exchange, so it can't be exchanged again.
+func (s *UserSagaWorkflow) CompensateQuote(ctx context.Context, params ExchangeParams) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("CompensateQuote start")
+	err := s.userService.CompensateQuote(ctx, params.QuoteID, params.UserID)
+	if err != nil {
+		logger.Error("CompensateQuote fails", zap.Error(err))
+	}
+
+	return err
+}
+
+// ExchangeCompensations returns the source money to the user, takes the spread back from the treasury
+// and closes the quote
+func (s *UserSagaWorkflow) ExchangeCompensations(
+	ctx workflow.Context,
+	stepNumber exchangeStep,
+	err error,
+	params ExchangeParams,
+) error {
+	logger := workflow.GetLogger(ctx)
+	logger.Debug("ExchangeCompensations start")
+
+	// the compensations post entries, once started they must complete
+	ledgerCtx := workflow.WithActivityOptions(ctx, s.getLedgerOptions())
+
+	switch stepNumber {
+	case exchangeStepCreditFailed:
+		logger.Debug("exchangeStepCreditFailed start")
+		if params.Quote.Spread.Amount > 0 {
+			compensateErr := workflow.ExecuteActivity(ledgerCtx, s.ReverseSpread, params).Get(ctx, nil)
+			if compensateErr != nil {
+				logger.Debug("exchangeStepCreditFailed error", zap.Error(compensateErr))
+				return compensateErr
+			}
+		}
+		fallthrough
+	case exchangeStepSpreadFailed:
+		logger.Debug("exchangeStepSpreadFailed start")
+		compensateErr := workflow.ExecuteActivity(ledgerCtx, s.CompensateExchange, params).Get(ctx, nil)
+		if compensateErr != nil {
+			logger.Debug("exchangeStepSpreadFailed error", zap.Error(compensateErr))
+			return compensateErr
+		}
+		fallthrough
+	case exchangeStepNoCompensations:
+		logger.Debug("exchangeStepNoCompensations start")
+		compensateErr := workflow.ExecuteActivity(ctx, s.CompensateQuote, params).Get(ctx, nil)
+		if compensateErr != nil {
+			logger.Debug("exchangeStepNoCompensations error", zap.Error(compensateErr))
+			return compensateErr
+		}
+		return temporal.NewNonRetryableApplicationError(apperrors.ErrCompensationCompleted.Error(),
+			"apperrors.ErrCompensationCompleted", apperrors.ErrCompensationCompleted)
+	}
+
+	return err
+}
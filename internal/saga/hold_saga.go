@@ -0,0 +1,246 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/logger"
+	"usershards/internal/models"
+	"usershards/internal/tracing"
+)
+
+const HoldCaptureSignal = "hold-capture"
+const HoldReleaseSignal = "hold-release"
+
+type HoldParams struct {
+	HoldID    string
+	UserID    int64
+	Amount    int64
+	ExpiresAt time.Time
+	Batch     bool // the hold pays the items of a batch, they are checked against the limits one by one
+}
+
+func (p HoldParams) SpanAttributes() []attribute.KeyValue {
+	return append(tracing.User(p.UserID), tracing.TransferIDKey.String(p.HoldID))
+}
+
+func (p HoldParams) LogFields() []zap.Field {
+	return append(userLogFields(p.UserID), logger.TransferID(p.HoldID))
+}
+
+type CaptureHoldParams struct {
+	HoldID   string
+	UserID   int64
+	ToUserID int64
+}
+
+func (p CaptureHoldParams) SpanAttributes() []attribute.KeyValue {
+	return tracing.Transfer(p.HoldID, p.UserID, p.ToUserID)
+}
+
+func (p CaptureHoldParams) LogFields() []zap.Field {
+	return transferLogFields(p.HoldID, p.UserID, p.ToUserID)
+}
+
+func holdWorkflowID(holdID string) string {
+	return "hold-" + holdID
+}
+
+// HoldFunds reserves money on the user's balance and starts a workflow that releases it after ttl
+// unless it is captured or released earlier.
+func (s *UserSagaWorkflow) HoldFunds(ctx context.Context, userID, amount int64, ttl time.Duration) (string, error) {
+	holdID, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+
+	params := HoldParams{
+		HoldID:    holdID.String(),
+		UserID:    userID,
+		Amount:    amount,
+		ExpiresAt: time.Now().Add(ttl).UTC(),
+	}
+
+	err = s.userService.HoldFunds(ctx, params.HoldID, userID, amount, params.ExpiresAt, true)
+	if err != nil {
+		return "", fmt.Errorf("failed to hold funds: %w", err)
+	}
+
+	workflowOptions := client.StartWorkflowOptions{
+		ID:        holdWorkflowID(params.HoldID),
+		TaskQueue: TransferTaskQueue,
+	}
+
+	_, err = s.temporalClient.ExecuteWorkflow(ctx, workflowOptions, s.HoldWorkflow, params)
+	if err != nil {
+		// nobody would release the hold on expiration, so don't leave it behind
+		if releaseErr := s.userService.ReleaseHold(ctx, params.HoldID, userID); releaseErr != nil {
+			err = errors.Join(err, releaseErr)
+		}
+		return "", fmt.Errorf("failed to start workflows: %w", err)
+	}
+
+	return params.HoldID, nil
+}
+
+// CaptureHold debits the held money and credits it to toUserID. It waits until the money is moved.
+func (s *UserSagaWorkflow) CaptureHold(ctx context.Context, holdID string, toUserID int64) error {
+	err := s.temporalClient.SignalWorkflow(ctx, holdWorkflowID(holdID), "", HoldCaptureSignal, toUserID)
+	if err != nil {
+		return fmt.Errorf("failed to signal workflows: %w", err)
+	}
+
+	return s.waitHoldWorkflow(ctx, holdID, models.HoldStatusCaptured)
+}
+
+// ReleaseHold returns the held money to the user's available balance.
+func (s *UserSagaWorkflow) ReleaseHold(ctx context.Context, holdID string) error {
+	err := s.temporalClient.SignalWorkflow(ctx, holdWorkflowID(holdID), "", HoldReleaseSignal, nil)
+	if err != nil {
+		return fmt.Errorf("failed to signal workflows: %w", err)
+	}
+
+	return s.waitHoldWorkflow(ctx, holdID, models.HoldStatusReleased)
+}
+
+func (s *UserSagaWorkflow) waitHoldWorkflow(ctx context.Context, holdID string, expected models.HoldStatus) error {
+	var status models.HoldStatus
+	err := s.temporalClient.GetWorkflow(ctx, holdWorkflowID(holdID), "").Get(ctx, &status)
+	if err != nil {
+		return fmt.Errorf("failed to get workflows result: %w", err)
+	}
+	if status != expected {
+		return apperrors.ErrHoldNotActive
+	}
+
+	return nil
+}
+
+// HoldWorkflow waits for the capture or release of the hold. The hold is released when it expires.
+func (s *UserSagaWorkflow) HoldWorkflow(ctx workflow.Context, params HoldParams) (models.HoldStatus, error) {
+	ctx = workflow.WithActivityOptions(ctx, s.getDefaultOptions())
+	logger := workflow.GetLogger(ctx)
+	logger.Debug("HoldWorkflow start")
+
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	defer cancelTimer()
+
+	var captureTo int64
+	capture := false
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(workflow.GetSignalChannel(ctx, HoldCaptureSignal), func(c workflow.ReceiveChannel, more bool) {
+		c.Receive(ctx, &captureTo)
+		capture = true
+	})
+	selector.AddReceive(workflow.GetSignalChannel(ctx, HoldReleaseSignal), func(c workflow.ReceiveChannel, more bool) {
+		c.Receive(ctx, nil)
+	})
+	selector.AddFuture(workflow.NewTimer(timerCtx, params.ExpiresAt.Sub(workflow.Now(ctx))), func(f workflow.Future) {
+		logger.Debug("hold expired")
+	})
+	selector.Select(ctx)
+
+	if !capture {
+		err := workflow.ExecuteActivity(ctx, s.ReleaseHeldMoney, params).Get(ctx, nil)
+		if err != nil {
+			return "", err
+		}
+		logger.Debug("HoldWorkflow released")
+		return models.HoldStatusReleased, nil
+	}
+
+	captureParams := CaptureHoldParams{HoldID: params.HoldID, UserID: params.UserID, ToUserID: captureTo}
+	err := workflow.ExecuteActivity(ctx, s.CaptureHeldMoney, captureParams).Get(ctx, nil)
+	if err != nil {
+		// the hold has expired meanwhile, it still has to be released
+		releaseErr := workflow.ExecuteActivity(ctx, s.ReleaseHeldMoney, params).Get(ctx, nil)
+		if releaseErr != nil {
+			return "", releaseErr
+		}
+		return models.HoldStatusReleased, nil
+	}
+
+	// captured money is a regular debit of the transfer with the hold id, so credit it the same way
+	transferParams := TransferMoneyParams{
+		From:          params.UserID,
+		To:            captureTo,
+		TransactionID: params.HoldID,
+		Amount:        params.Amount,
+	}
+	err = workflow.ExecuteActivity(ctx, s.IncreaseMoney, transferParams).Get(ctx, nil)
+	if err != nil {
+		return "", s.Compensations(ctx, stepIncreaseFailed, err, transferParams)
+	}
+
+	logger.Debug("HoldWorkflow captured")
+	return models.HoldStatusCaptured, nil
+}
+
+func (s *UserSagaWorkflow) CaptureHeldMoney(ctx context.Context, params CaptureHoldParams) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("CaptureHeldMoney start")
+	err := s.userService.CaptureHold(ctx, params.HoldID, params.UserID, params.ToUserID)
+	if err != nil {
+		logger.Error("CaptureHeldMoney fails", zap.Error(err))
+		if errors.Is(err, apperrors.ErrHoldNotActive) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrHoldNotActive", apperrors.ErrHoldNotActive)
+		}
+	}
+
+	return err
+}
+
+func (s *UserSagaWorkflow) ReleaseHeldMoney(ctx context.Context, params HoldParams) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("ReleaseHeldMoney start")
+	err := s.userService.ReleaseHold(ctx, params.HoldID, params.UserID)
+	if err != nil {
+		logger.Error("ReleaseHeldMoney fails", zap.Error(err))
+	}
+
+	return err
+}
+
+func (s *UserSagaWorkflow) HoldMoney(ctx context.Context, params HoldParams) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("HoldMoney start")
+	err := s.userService.HoldFunds(ctx, params.HoldID, params.UserID, params.Amount, params.ExpiresAt, !params.Batch)
+	if err != nil {
+		logger.Error("HoldMoney fails", zap.Error(err))
+		if errors.Is(err, apperrors.ErrLimitExceeded) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrLimitExceeded", apperrors.ErrLimitExceeded)
+		}
+		if errors.Is(err, apperrors.ErrInsufficientFunds) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrInsufficientFunds", apperrors.ErrInsufficientFunds)
+		}
+		if errors.Is(err, apperrors.ErrUserIsBlocked) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrUserIsBlocked", apperrors.ErrUserIsBlocked)
+		}
+	}
+
+	return err
+}
+
+// ExtendHeldMoney moves the expiration of the hold to params.ExpiresAt.
+func (s *UserSagaWorkflow) ExtendHeldMoney(ctx context.Context, params HoldParams) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("ExtendHeldMoney start")
+	err := s.userService.ExtendHold(ctx, params.HoldID, params.UserID, params.ExpiresAt)
+	if err != nil {
+		logger.Error("ExtendHeldMoney fails", zap.Error(err))
+		if errors.Is(err, apperrors.ErrHoldNotActive) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrHoldNotActive", apperrors.ErrHoldNotActive)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,166 @@
+package saga
+
+import (
+	"context"
+	"fmt"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/workflow"
+	"slices"
+	"time"
+)
+
+// InterestKind tells which balances the interest workflow posts to.
+type InterestKind string
+
+const InterestKindCredit InterestKind = "credit"   // charged on negative balances
+const InterestKindDeposit InterestKind = "deposit" // paid on positive balances
+
+// interestSchedule runs the postings shortly after midnight UTC, for the day which has just ended.
+const interestSchedule = "10 0 * * *"
+
+const interestPageSize = 500
+
+// interestPagesPerExecution bounds the history of the workflow, it continues as new after that many pages.
+const interestPagesPerExecution = 200
+
+type InterestParams struct {
+	Kind   InterestKind
+	Date   time.Time // the day to post, the previous day if zero
+	Shards []int     // all user shards, filled by the workflow
+	Shard  int       // the index in Shards of the shard in progress
+	After  int64     // the last user of the shard which is posted
+}
+
+func interestWorkflowID(kind InterestKind) string {
+	return string(kind) + "-interest"
+}
+
+// StartDailyInterest starts the daily interest workflows. It must be called on startup,
+// the workflows are started once and calling it again is a no-op.
+func (s *UserSagaWorkflow) StartDailyInterest(ctx context.Context) error {
+	for _, kind := range []InterestKind{InterestKindCredit, InterestKindDeposit} {
+		workflowOptions := client.StartWorkflowOptions{
+			ID:           interestWorkflowID(kind),
+			TaskQueue:    TransferTaskQueue,
+			CronSchedule: interestSchedule,
+		}
+
+		// the running workflow is returned instead of an error, so it is never started twice
+		_, err := s.temporalClient.ExecuteWorkflow(ctx, workflowOptions, s.InterestWorkflow, InterestParams{Kind: kind})
+		if err != nil {
+			return fmt.Errorf("failed to start workflows: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// ChargeCreditInterest charges the interest on negative balances for the day at once and waits until
+// it is charged. The day which is charged already is not charged again.
+func (s *UserSagaWorkflow) ChargeCreditInterest(ctx context.Context, date time.Time) error {
+	return s.postInterest(ctx, InterestKindCredit, date)
+}
+
+// AccrueDepositInterest pays the interest on positive balances for the day at once and waits until
+// it is paid. The day which is paid already is not paid again.
+func (s *UserSagaWorkflow) AccrueDepositInterest(ctx context.Context, date time.Time) error {
+	return s.postInterest(ctx, InterestKindDeposit, date)
+}
+
+func (s *UserSagaWorkflow) postInterest(ctx context.Context, kind InterestKind, date time.Time) error {
+	date = date.UTC().Truncate(24 * time.Hour)
+	workflowOptions := client.StartWorkflowOptions{
+		ID:        interestWorkflowID(kind) + "-" + date.Format(time.DateOnly),
+		TaskQueue: TransferTaskQueue,
+	}
+
+	we, err := s.temporalClient.ExecuteWorkflow(ctx, workflowOptions, s.InterestWorkflow,
+		InterestParams{Kind: kind, Date: date})
+	if err != nil {
+		return fmt.Errorf("failed to start workflows: %w", err)
+	}
+
+	err = we.Get(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to get workflows result: %w", err)
+	}
+
+	return nil
+}
+
+// InterestWorkflow posts the interest to the users of every shard page by page, then settles the shard's
+// total with the treasury. Every user is posted at most once a day, so a retried page is safe.
+func (s *UserSagaWorkflow) InterestWorkflow(ctx workflow.Context, params InterestParams) error {
+	ctx = workflow.WithActivityOptions(ctx, s.getDefaultOptions())
+	logger := workflow.GetLogger(ctx)
+	logger.Debug("InterestWorkflow start")
+
+	if params.Date.IsZero() {
+		params.Date = workflow.Now(ctx).UTC().Truncate(24*time.Hour).AddDate(0, 0, -1)
+	}
+	if params.Shards == nil {
+		err := workflow.ExecuteActivity(ctx, s.ListUserShards).Get(ctx, &params.Shards)
+		if err != nil {
+			return err
+		}
+	}
+
+	// a page posts many users one by one, it takes longer than the default activity
+	pageCtx := workflow.WithScheduleToCloseTimeout(workflow.WithStartToCloseTimeout(ctx, time.Minute), 5*time.Minute)
+	for pages := 0; params.Shard < len(params.Shards); pages++ {
+		if pages == interestPagesPerExecution {
+			return workflow.NewContinueAsNewError(ctx, s.InterestWorkflow, params)
+		}
+
+		shardID := params.Shards[params.Shard]
+		err := workflow.ExecuteActivity(pageCtx, s.PostInterestPage, params.Kind, shardID, params.Date, params.After).
+			Get(ctx, &params.After)
+		if err != nil {
+			return err
+		}
+		if params.After != 0 {
+			continue
+		}
+
+		err = workflow.ExecuteActivity(ctx, s.SettleInterest, params.Kind, shardID, params.Date).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+		params.Shard++
+	}
+
+	logger.Debug("InterestWorkflow stop")
+	return nil
+}
+
+func (s *UserSagaWorkflow) ListUserShards(ctx context.Context) ([]int, error) {
+	shards := make([]int, 0, len(s.userService.GetShardManager().UserShards))
+	for shardID := range s.userService.GetShardManager().UserShards {
+		shards = append(shards, shardID)
+	}
+	slices.Sort(shards)
+
+	return shards, nil
+}
+
+// PostInterestPage posts a page of users of the shard and returns the last of them, 0 when the shard is done.
+func (s *UserSagaWorkflow) PostInterestPage(
+	ctx context.Context,
+	kind InterestKind,
+	shardID int,
+	date time.Time,
+	after int64,
+) (int64, error) {
+	if kind == InterestKindDeposit {
+		return s.userService.AccrueDepositInterest(ctx, shardID, date, after, interestPageSize)
+	}
+	return s.userService.ChargeCreditInterest(ctx, shardID, date, after, interestPageSize)
+}
+
+// SettleInterest moves the shard's total of the day to or from the treasury.
+func (s *UserSagaWorkflow) SettleInterest(ctx context.Context, kind InterestKind, shardID int, date time.Time) error {
+	if kind == InterestKindDeposit {
+		return s.userService.DebitInterestExpense(ctx, shardID, date)
+	}
+	return s.userService.CreditInterestIncome(ctx, shardID, date)
+}
@@ -0,0 +1,24 @@
+package saga
+
+import (
+	"go.uber.org/zap"
+	"usershards/internal/id"
+	"usershards/internal/logger"
+)
+
+// userLogFields tags the logs with the user and the shard the user lives on.
+func userLogFields(userID int64) []zap.Field {
+	_, shardID, _ := id.ParseUserID(userID)
+	return []zap.Field{logger.UserID(userID), logger.ShardID(shardID)}
+}
+
+// transferLogFields tags the logs with the transfer, its sender and its recipient, with their shards.
+// to is 0 when there is no single recipient.
+func transferLogFields(transferID string, from, to int64) []zap.Field {
+	fields := append([]zap.Field{logger.TransferID(transferID)}, userLogFields(from)...)
+	if to != 0 {
+		_, shardID, _ := id.ParseUserID(to)
+		fields = append(fields, zap.Int64(logger.ToUserIDKey, to), zap.Int(logger.ToShardIDKey, shardID))
+	}
+	return fields
+}
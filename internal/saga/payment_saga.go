@@ -0,0 +1,420 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.temporal.io/api/serviceerror"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/models"
+	"usershards/internal/tracing"
+)
+
+const PaymentCallbackSignal = "payment-callback"
+
+// PaymentCallback is the result of the payment reported by the provider.
+type PaymentCallback struct {
+	Success bool
+	Reason  string
+}
+
+type PaymentParams struct {
+	PaymentID  string
+	UserID     int64
+	Money      models.Money
+	Settlement int64         // the external settlement account, the other side of the payment
+	Timeout    time.Duration // how long to wait for the provider callback
+}
+
+func (p PaymentParams) SpanAttributes() []attribute.KeyValue {
+	return tracing.Transfer(p.PaymentID, p.UserID, p.Settlement)
+}
+
+func (p PaymentParams) LogFields() []zap.Field {
+	return transferLogFields(p.PaymentID, p.UserID, p.Settlement)
+}
+
+// PaymentResult is filled by the activities of the payment workflows.
+type PaymentResult struct {
+	ProviderRef string
+	Reason      string
+}
+
+func paymentWorkflowID(paymentID string) string {
+	return "payment-" + paymentID
+}
+
+// Deposit asks the provider to collect money from the user. The payment is pending until the provider
+// calls back with PaymentCallback, then the money is credited to the user from the external settlement account.
+func (s *UserSagaWorkflow) Deposit(ctx context.Context, userID int64, money models.Money) (string, error) {
+	return s.startPayment(ctx, s.DepositWorkflow, userID, money)
+}
+
+// Withdraw holds money on the user's balance and asks the provider to pay it out. The money is debited
+// when the provider confirms the payout and released when the provider fails it. The hold is checked
+// against the transfer limits of the user.
+func (s *UserSagaWorkflow) Withdraw(ctx context.Context, userID int64, money models.Money) (string, error) {
+	// the money is held until the payout is confirmed, and holds are in the default currency only
+	if money.Currency != models.DefaultCurrency {
+		return "", fmt.Errorf("%w: withdrawals are in %s only", apperrors.ErrInvalidCurrency, models.DefaultCurrency)
+	}
+	return s.startPayment(ctx, s.WithdrawWorkflow, userID, money)
+}
+
+// PaymentCallback delivers the provider's result to the payment workflow. A success which comes
+// after the payment has been failed on timeout is canceled at the provider, so the money goes back.
+func (s *UserSagaWorkflow) PaymentCallback(ctx context.Context, paymentID string, callback PaymentCallback) error {
+	err := s.temporalClient.SignalWorkflow(ctx, paymentWorkflowID(paymentID), "", PaymentCallbackSignal, callback)
+	if err != nil {
+		var notFound *serviceerror.NotFound
+		if callback.Success && errors.As(err, &notFound) {
+			return s.cancelLatePayment(ctx, paymentID)
+		}
+		return fmt.Errorf("failed to signal workflows: %w", err)
+	}
+
+	return nil
+}
+
+func (s *UserSagaWorkflow) cancelLatePayment(ctx context.Context, paymentID string) error {
+	status, err := s.WaitPayment(ctx, paymentID)
+	if err != nil {
+		return err
+	}
+	if status != models.PaymentStatusFailed {
+		return nil
+	}
+
+	err = s.paymentProvider.Cancel(ctx, paymentID)
+	if err != nil {
+		return fmt.Errorf("failed to cancel payment: %w", err)
+	}
+
+	return nil
+}
+
+// WaitPayment waits until the payment is completed or failed.
+func (s *UserSagaWorkflow) WaitPayment(ctx context.Context, paymentID string) (models.PaymentStatus, error) {
+	var status models.PaymentStatus
+	err := s.temporalClient.GetWorkflow(ctx, paymentWorkflowID(paymentID), "").Get(ctx, &status)
+	if err != nil {
+		return "", fmt.Errorf("failed to get workflows result: %w", err)
+	}
+
+	return status, nil
+}
+
+func (s *UserSagaWorkflow) startPayment(
+	ctx context.Context,
+	paymentWorkflow interface{},
+	userID int64,
+	money models.Money,
+) (string, error) {
+	if !money.Currency.Valid() {
+		return "", apperrors.ErrInvalidCurrency
+	}
+	if money.Amount <= 0 {
+		return "", fmt.Errorf("amount must be positive")
+	}
+
+	paymentID, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+
+	params := PaymentParams{
+		PaymentID:  paymentID.String(),
+		UserID:     userID,
+		Money:      money,
+		Settlement: s.userService.SystemAccount(models.SystemAccountExternalSettlement),
+		Timeout:    s.userService.PaymentCallbackTimeout(),
+	}
+
+	workflowOptions := client.StartWorkflowOptions{
+		ID:        paymentWorkflowID(params.PaymentID),
+		TaskQueue: TransferTaskQueue,
+	}
+
+	_, err = s.temporalClient.ExecuteWorkflow(ctx, workflowOptions, paymentWorkflow, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to start workflows: %w", err)
+	}
+
+	return params.PaymentID, nil
+}
+
+func (s *UserSagaWorkflow) DepositWorkflow(ctx workflow.Context, params PaymentParams) (models.PaymentStatus, error) {
+	ctx = workflow.WithActivityOptions(ctx, s.getDefaultOptions())
+	logger := workflow.GetLogger(ctx)
+	logger.Debug("DepositWorkflow start")
+
+	err := workflow.ExecuteActivity(ctx, s.CreatePayment, params, models.PaymentTypeDeposit).Get(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	var result PaymentResult
+	err = workflow.ExecuteActivity(ctx, s.RequestCharge, params).Get(ctx, &result)
+	if err != nil {
+		return s.failPayment(ctx, params, err.Error())
+	}
+
+	callback, received := s.waitPaymentCallback(ctx, params.Timeout)
+	if !callback.Success {
+		// the provider may still charge the user after the timeout
+		if !received {
+			err = workflow.ExecuteActivity(ctx, s.CancelPayment, params).Get(ctx, nil)
+			if err != nil {
+				return "", err
+			}
+		}
+		return s.failPayment(ctx, params, callback.Reason)
+	}
+
+	// the provider has the money now, it is moved from the external settlement account by the usual transfer
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID: "deposit-" + params.PaymentID,
+		TaskQueue:  TransferTaskQueue,
+	})
+	transferParams := TransferMoneyParams{
+		From:          params.Settlement,
+		To:            params.UserID,
+		TransactionID: params.PaymentID,
+		Amount:        params.Money.Amount,
+		Currency:      params.Money.Currency,
+	}
+	err = workflow.ExecuteChildWorkflow(childCtx, s.TransferMoneyWorkflow, transferParams).Get(ctx, nil)
+	if err != nil {
+		// the transfer has compensated itself, the provider has to return the money
+		logger.Error("deposit transfer fails", zap.Error(err))
+		refundErr := workflow.ExecuteActivity(ctx, s.RefundPayment, params).Get(ctx, nil)
+		if refundErr != nil {
+			return "", refundErr
+		}
+		return s.failPayment(ctx, params, err.Error())
+	}
+
+	logger.Debug("DepositWorkflow stop")
+	return s.completePayment(ctx, params)
+}
+
+func (s *UserSagaWorkflow) WithdrawWorkflow(ctx workflow.Context, params PaymentParams) (models.PaymentStatus, error) {
+	ctx = workflow.WithActivityOptions(ctx, s.getDefaultOptions())
+	logger := workflow.GetLogger(ctx)
+	logger.Debug("WithdrawWorkflow start")
+
+	err := workflow.ExecuteActivity(ctx, s.CreatePayment, params, models.PaymentTypeWithdrawal).Get(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	// the payment id is the hold id, so the debit is recorded in history under the payment id
+	holdParams := HoldParams{
+		HoldID: params.PaymentID,
+		UserID: params.UserID,
+		Amount: params.Money.Amount,
+		// the hold must outlive the callback, otherwise a confirmed payout could not be captured
+		ExpiresAt: workflow.Now(ctx).Add(2*params.Timeout + time.Hour),
+	}
+	err = workflow.ExecuteActivity(ctx, s.HoldMoney, holdParams).Get(ctx, nil)
+	if err != nil {
+		return s.failPayment(ctx, params, err.Error())
+	}
+
+	var result PaymentResult
+	err = workflow.ExecuteActivity(ctx, s.RequestPayout, params).Get(ctx, &result)
+	if err != nil {
+		return s.releaseWithdrawal(ctx, params, holdParams, err.Error())
+	}
+
+	callback, received := s.waitPaymentCallback(ctx, params.Timeout)
+	if !callback.Success {
+		// the provider must not pay out the money which is released
+		if !received {
+			err = workflow.ExecuteActivity(ctx, s.CancelPayment, params).Get(ctx, nil)
+			if err != nil {
+				return "", err
+			}
+		}
+		return s.releaseWithdrawal(ctx, params, holdParams, callback.Reason)
+	}
+
+	// the money is paid out, so the ledger has to follow whatever it takes
+	ledgerCtx := workflow.WithActivityOptions(ctx, s.getLedgerOptions())
+
+	captureParams := CaptureHoldParams{HoldID: params.PaymentID, UserID: params.UserID, ToUserID: params.Settlement}
+	err = workflow.ExecuteActivity(ledgerCtx, s.CaptureHeldMoney, captureParams).Get(ctx, nil)
+	if err != nil {
+		return s.reconcilePayment(ctx, params, err.Error())
+	}
+
+	// the external settlement account is a system one, it can't be blocked, so the credit is retried until done
+	transferParams := TransferMoneyParams{
+		From:          params.UserID,
+		To:            params.Settlement,
+		TransactionID: params.PaymentID,
+		Amount:        params.Money.Amount,
+	}
+	err = workflow.ExecuteActivity(ledgerCtx, s.IncreaseMoney, transferParams).Get(ctx, nil)
+	if err != nil {
+		return s.reconcilePayment(ctx, params, err.Error())
+	}
+
+	logger.Debug("WithdrawWorkflow stop")
+	return s.completePayment(ctx, params)
+}
+
+// waitPaymentCallback waits for the provider's result, no result in time fails the payment.
+// The flag is false when the callback has not been received.
+func (s *UserSagaWorkflow) waitPaymentCallback(ctx workflow.Context, timeout time.Duration) (PaymentCallback, bool) {
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	defer cancelTimer()
+
+	callback := PaymentCallback{Reason: "provider callback timeout"}
+	received := false
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(workflow.GetSignalChannel(ctx, PaymentCallbackSignal), func(c workflow.ReceiveChannel, more bool) {
+		c.Receive(ctx, &callback)
+		received = true
+	})
+	selector.AddFuture(workflow.NewTimer(timerCtx, timeout), func(f workflow.Future) {
+		workflow.GetLogger(ctx).Debug("payment callback timeout")
+	})
+	selector.Select(ctx)
+
+	return callback, received
+}
+
+func (s *UserSagaWorkflow) releaseWithdrawal(
+	ctx workflow.Context,
+	params PaymentParams,
+	holdParams HoldParams,
+	reason string,
+) (models.PaymentStatus, error) {
+	err := workflow.ExecuteActivity(ctx, s.ReleaseHeldMoney, holdParams).Get(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return s.failPayment(ctx, params, reason)
+}
+
+func (s *UserSagaWorkflow) failPayment(ctx workflow.Context, params PaymentParams, reason string) (models.PaymentStatus, error) {
+	result := PaymentResult{Reason: reason}
+	err := workflow.ExecuteActivity(ctx, s.FinishPayment, params, models.PaymentStatusFailed, result).Get(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return models.PaymentStatusFailed, nil
+}
+
+// reconcilePayment leaves the payment the provider has made for the manual reconciliation,
+// the ledger could not record it.
+func (s *UserSagaWorkflow) reconcilePayment(ctx workflow.Context, params PaymentParams, reason string) (models.PaymentStatus, error) {
+	workflow.GetLogger(ctx).Error("payment needs reconciliation", zap.String("reason", reason))
+	result := PaymentResult{Reason: reason}
+	err := workflow.ExecuteActivity(ctx, s.FinishPayment, params, models.PaymentStatusReconcile, result).Get(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return models.PaymentStatusReconcile, nil
+}
+
+func (s *UserSagaWorkflow) completePayment(ctx workflow.Context, params PaymentParams) (models.PaymentStatus, error) {
+	err := workflow.ExecuteActivity(ctx, s.FinishPayment, params, models.PaymentStatusCompleted, PaymentResult{}).Get(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+
+	return models.PaymentStatusCompleted, nil
+}
+
+func (s *UserSagaWorkflow) CreatePayment(ctx context.Context, params PaymentParams, paymentType models.PaymentType) error {
+	return s.userService.CreatePayment(ctx, models.Payment{
+		ID:     params.PaymentID,
+		UserID: params.UserID,
+		Type:   paymentType,
+		Money:  params.Money,
+	})
+}
+
+func (s *UserSagaWorkflow) FinishPayment(
+	ctx context.Context,
+	params PaymentParams,
+	status models.PaymentStatus,
+	result PaymentResult,
+) error {
+	return s.userService.UpdatePayment(ctx, params.PaymentID, params.UserID, status, result.ProviderRef, result.Reason)
+}
+
+func (s *UserSagaWorkflow) RequestCharge(ctx context.Context, params PaymentParams) (PaymentResult, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("RequestCharge start")
+	ref, err := s.paymentProvider.Charge(ctx, params.PaymentID, params.UserID, params.Money)
+	if err != nil {
+		logger.Error("RequestCharge fails", zap.Error(err))
+		return PaymentResult{}, paymentProviderError(err)
+	}
+
+	return s.savePaymentRef(ctx, params, ref)
+}
+
+func (s *UserSagaWorkflow) RequestPayout(ctx context.Context, params PaymentParams) (PaymentResult, error) {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("RequestPayout start")
+	ref, err := s.paymentProvider.Payout(ctx, params.PaymentID, params.UserID, params.Money)
+	if err != nil {
+		logger.Error("RequestPayout fails", zap.Error(err))
+		return PaymentResult{}, paymentProviderError(err)
+	}
+
+	return s.savePaymentRef(ctx, params, ref)
+}
+
+func (s *UserSagaWorkflow) RefundPayment(ctx context.Context, params PaymentParams) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("RefundPayment start")
+	err := s.paymentProvider.Refund(ctx, params.PaymentID)
+	if err != nil {
+		logger.Error("RefundPayment fails", zap.Error(err))
+	}
+
+	return err
+}
+
+func (s *UserSagaWorkflow) CancelPayment(ctx context.Context, params PaymentParams) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("CancelPayment start")
+	err := s.paymentProvider.Cancel(ctx, params.PaymentID)
+	if err != nil {
+		logger.Error("CancelPayment fails", zap.Error(err))
+	}
+
+	return err
+}
+
+func (s *UserSagaWorkflow) savePaymentRef(ctx context.Context, params PaymentParams, ref string) (PaymentResult, error) {
+	err := s.userService.UpdatePayment(ctx, params.PaymentID, params.UserID, models.PaymentStatusPending, ref, "")
+	if err != nil {
+		return PaymentResult{}, err
+	}
+
+	return PaymentResult{ProviderRef: ref}, nil
+}
+
+func paymentProviderError(err error) error {
+	if errors.Is(err, apperrors.ErrPaymentDeclined) {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrPaymentDeclined", apperrors.ErrPaymentDeclined)
+	}
+	return err
+}
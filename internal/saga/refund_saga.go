@@ -0,0 +1,203 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
+	"go.temporal.io/sdk/activity"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
+	"usershards/internal/apperrors"
+	"usershards/internal/models"
+	"usershards/internal/tracing"
+)
+
+const refundStepNoCompensations step = 0
+const refundStepCreditFailed step = 1
+
+type RefundParams struct {
+	RefundID   string
+	TransferID string
+	From       int64        // the recipient of the transfer, who pays the refund
+	To         int64        // the sender of the transfer
+	Money      models.Money // in the currency the recipient got
+}
+
+func (p RefundParams) SpanAttributes() []attribute.KeyValue {
+	return append(tracing.Transfer(p.RefundID, p.From, p.To), attribute.String("usershards.refunded_transfer_id", p.TransferID))
+}
+
+func (p RefundParams) LogFields() []zap.Field {
+	return append(transferLogFields(p.RefundID, p.From, p.To), zap.String("refunded_transfer_id", p.TransferID))
+}
+
+// RefundTransfer returns amount of the completed transfer from its recipient to its sender, in the currency
+// the recipient got. The transfer may be refunded by parts until the refunds reach the money the recipient got,
+// the fee is not refunded. Calls with the same key make one refund, so a retried call doesn't refund twice.
+// The refund is made even if the recipient is blocked. Split transfers can't be refunded. It returns the refund ID, the refund is a transfer
+// with its own entries and GetTransfer of the original transfer shows the refunded total. A refund which
+// failed and was compensated is final, the call with its key is rejected.
+func (s *UserSagaWorkflow) RefundTransfer(ctx context.Context, transferID string, amount int64, key string) (string, error) {
+	if amount <= 0 {
+		return "", fmt.Errorf("amount must be positive")
+	}
+
+	transferUUID, err := uuid.Parse(transferID)
+	if err != nil {
+		return "", apperrors.ErrTransferNotFound
+	}
+
+	transfer, err := s.userService.GetTransfer(ctx, transferID)
+	if err != nil {
+		return "", err
+	}
+	if transfer.Status != models.TransferStatusCompleted {
+		return "", apperrors.ErrRefundNotAllowed
+	}
+	// a split transfer has no single recipient, it is not refunded
+	if transfer.ToID == 0 {
+		return "", apperrors.ErrRefundNotAllowed
+	}
+
+	// the recipient may have got another currency, the refund is made in it
+	credit := models.Money{}
+	for _, entry := range transfer.Entries {
+		if entry.UserID == transfer.ToID &&
+			(entry.Type == models.TransactionTypeIncrease || entry.Type == models.TransactionTypeExchangeIn) {
+			credit = models.NewMoney(entry.Amount, entry.Currency)
+		}
+	}
+	if credit.Amount == 0 {
+		return "", apperrors.ErrRefundNotAllowed
+	}
+	// checked again by the debit, under the recipient's lock
+	if transfer.Refunded+amount > credit.Amount {
+		return "", apperrors.ErrRefundExceedsTransfer
+	}
+
+	params := RefundParams{
+		RefundID:   uuid.NewSHA1(transferUUID, []byte(key)).String(),
+		TransferID: transferID,
+		From:       transfer.ToID,
+		To:         transfer.FromID,
+		Money:      models.NewMoney(amount, credit.Currency),
+	}
+
+	// the workflow id may be reused after a failed run, the refund itself must not be, checked again by the debit
+	status, err := s.userService.GetRefundStatus(ctx, params.RefundID, params.From)
+	if err != nil {
+		return "", err
+	}
+	if status == models.TransactionStatusCompensated {
+		return "", apperrors.ErrRefundNotAllowed
+	}
+
+	workflowOptions := client.StartWorkflowOptions{
+		ID:        "refund-" + params.RefundID,
+		TaskQueue: TransferTaskQueue,
+	}
+
+	we, err := s.temporalClient.ExecuteWorkflow(ctx, workflowOptions, s.RefundWorkflow, params)
+	if err != nil {
+		return "", fmt.Errorf("failed to start workflows: %w", err)
+	}
+
+	err = we.Get(ctx, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to get workflows result: %w", err)
+	}
+
+	return params.RefundID, nil
+}
+
+func (s *UserSagaWorkflow) RefundWorkflow(ctx workflow.Context, params RefundParams) error {
+	ctx = workflow.WithActivityOptions(ctx, s.getDefaultOptions())
+	logger := workflow.GetLogger(ctx)
+	logger.Debug("RefundWorkflow start")
+
+	err := workflow.ExecuteActivity(ctx, s.DebitRefund, params).Get(ctx, nil)
+	if err != nil {
+		return s.RefundCompensations(ctx, refundStepNoCompensations, err, params)
+	}
+
+	err = workflow.ExecuteActivity(ctx, s.CreditRefund, params).Get(ctx, nil)
+	if err != nil {
+		return s.RefundCompensations(ctx, refundStepCreditFailed, err, params)
+	}
+
+	logger.Debug("RefundWorkflow stop")
+	return nil
+}
+
+func (s *UserSagaWorkflow) DebitRefund(ctx context.Context, params RefundParams) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("DebitRefund start")
+	err := s.userService.DecreaseRefund(ctx, params.RefundID, params.TransferID, params.From, params.To, params.Money)
+	if err != nil {
+		logger.Error("DebitRefund fails", zap.Error(err))
+		if errors.Is(err, apperrors.ErrRefundExceedsTransfer) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrRefundExceedsTransfer", apperrors.ErrRefundExceedsTransfer)
+		}
+		if errors.Is(err, apperrors.ErrRefundNotAllowed) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrRefundNotAllowed", apperrors.ErrRefundNotAllowed)
+		}
+		if errors.Is(err, apperrors.ErrInsufficientFunds) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrInsufficientFunds", apperrors.ErrInsufficientFunds)
+		}
+	}
+
+	return err
+}
+
+func (s *UserSagaWorkflow) CreditRefund(ctx context.Context, params RefundParams) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("CreditRefund start")
+	err := s.userService.IncreaseMoneyToUser(ctx, params.RefundID, models.TransactionTypeRefundIn, params.From, params.To, params.Money)
+	if err != nil {
+		logger.Error("CreditRefund fails", zap.Error(err))
+	}
+
+	return err
+}
+
+func (s *UserSagaWorkflow) CompensateRefund(ctx context.Context, params RefundParams) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("CompensateRefund start")
+	err := s.userService.IncreaseMoneyToUser(ctx, params.RefundID, models.TransactionTypeRefundCompensate, params.To, params.From, params.Money)
+	if err != nil {
+		logger.Error("CompensateRefund fails", zap.Error(err))
+	}
+
+	return err
+}
+
+func (s *UserSagaWorkflow) RefundCompensations(
+	ctx workflow.Context,
+	stepNumber step,
+	err error,
+	params RefundParams,
+) error {
+	logger := workflow.GetLogger(ctx)
+	logger.Debug("RefundCompensations start")
+
+	switch stepNumber {
+	case refundStepCreditFailed:
+		logger.Debug("refundStepCreditFailed start")
+		compensateErr := workflow.ExecuteActivity(ctx, s.CompensateRefund, params).Get(ctx, nil)
+		if compensateErr != nil {
+			logger.Debug("refundStepCreditFailed error", zap.Error(compensateErr))
+			return compensateErr
+		}
+		fallthrough
+	case refundStepNoCompensations:
+		logger.Debug("refundStepNoCompensations start")
+		return temporal.NewNonRetryableApplicationError(apperrors.ErrCompensationCompleted.Error(),
+			"apperrors.ErrCompensationCompleted", apperrors.ErrCompensationCompleted)
+	}
+
+	return err
+}
@@ -0,0 +1,299 @@
+package saga
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/workflow"
+	"go.uber.org/zap"
+	"time"
+	"usershards/internal/models"
+)
+
+const SchedulePauseSignal = "schedule-pause"
+const ScheduleResumeSignal = "schedule-resume"
+const ScheduleCancelSignal = "schedule-cancel"
+
+// scheduleRunsPerExecution bounds the history of the schedule workflow, it continues as new after that many runs.
+const scheduleRunsPerExecution = 100
+
+func scheduleWorkflowID(scheduleID string) string {
+	return "schedule-" + scheduleID
+}
+
+// ScheduleTransfer transfers amount in the default currency once at the given time.
+func (s *UserSagaWorkflow) ScheduleTransfer(ctx context.Context, from, to, amount int64, at time.Time) (string, error) {
+	return s.startSchedule(ctx, models.Schedule{
+		FromID:    from,
+		ToID:      to,
+		Amount:    amount,
+		Kind:      models.ScheduleKindOnce,
+		NextRunAt: at,
+	})
+}
+
+// ScheduleRecurringTransfer transfers amount in the default currency by kind, starting at the given time.
+// cronSpec is required for models.ScheduleKindCron only, its first run is the first match after start.
+func (s *UserSagaWorkflow) ScheduleRecurringTransfer(
+	ctx context.Context,
+	from,
+	to,
+	amount int64,
+	kind models.ScheduleKind,
+	cronSpec string,
+	start time.Time,
+) (string, error) {
+	schedule := models.Schedule{
+		FromID:    from,
+		ToID:      to,
+		Amount:    amount,
+		Kind:      kind,
+		Cron:      cronSpec,
+		NextRunAt: start,
+	}
+	if kind == models.ScheduleKindCron {
+		if err := schedule.Validate(); err != nil {
+			return "", err
+		}
+		next, err := schedule.NextRun(start)
+		if err != nil {
+			return "", err
+		}
+		schedule.NextRunAt = next
+	}
+
+	return s.startSchedule(ctx, schedule)
+}
+
+// PauseSchedule skips the runs of the user's schedule until it is resumed.
+func (s *UserSagaWorkflow) PauseSchedule(ctx context.Context, scheduleID string, userID int64) error {
+	return s.signalSchedule(ctx, scheduleID, userID, SchedulePauseSignal)
+}
+
+// ResumeSchedule continues the user's paused schedule from its next run after now.
+func (s *UserSagaWorkflow) ResumeSchedule(ctx context.Context, scheduleID string, userID int64) error {
+	return s.signalSchedule(ctx, scheduleID, userID, ScheduleResumeSignal)
+}
+
+// CancelSchedule stops the user's schedule for good.
+func (s *UserSagaWorkflow) CancelSchedule(ctx context.Context, scheduleID string, userID int64) error {
+	return s.signalSchedule(ctx, scheduleID, userID, ScheduleCancelSignal)
+}
+
+// signalSchedule signals the schedule of the user, the schedules of the others are not found.
+func (s *UserSagaWorkflow) signalSchedule(ctx context.Context, scheduleID string, userID int64, signal string) error {
+	_, err := s.userService.GetSchedule(ctx, scheduleID, userID)
+	if err != nil {
+		return err
+	}
+
+	err = s.temporalClient.SignalWorkflow(ctx, scheduleWorkflowID(scheduleID), "", signal, nil)
+	if err != nil {
+		return fmt.Errorf("failed to signal workflows: %w", err)
+	}
+
+	return nil
+}
+
+func (s *UserSagaWorkflow) startSchedule(ctx context.Context, schedule models.Schedule) (string, error) {
+	if err := schedule.Validate(); err != nil {
+		return "", err
+	}
+	if schedule.NextRunAt.IsZero() {
+		schedule.NextRunAt = time.Now()
+	}
+
+	scheduleID, err := uuid.NewV7()
+	if err != nil {
+		return "", err
+	}
+	schedule.ID = scheduleID.String()
+	schedule.Status = models.ScheduleStatusActive
+	schedule.NextRunAt = schedule.NextRunAt.UTC()
+	schedule.StartAt = schedule.NextRunAt
+
+	// the schedule is saved first, so it is listed as soon as it is created
+	err = s.userService.CreateSchedule(ctx, schedule)
+	if err != nil {
+		return "", fmt.Errorf("failed to create schedule: %w", err)
+	}
+
+	workflowOptions := client.StartWorkflowOptions{
+		ID:        scheduleWorkflowID(schedule.ID),
+		TaskQueue: TransferTaskQueue,
+	}
+
+	_, err = s.temporalClient.ExecuteWorkflow(ctx, workflowOptions, s.ScheduledTransferWorkflow, schedule)
+	if err != nil {
+		return "", fmt.Errorf("failed to start workflows: %w", err)
+	}
+
+	return schedule.ID, nil
+}
+
+// ScheduledTransferWorkflow lives as long as the schedule. Every run is a child TransferMoneyWorkflow,
+// a failed run is recorded in the schedule and doesn't stop it.
+func (s *UserSagaWorkflow) ScheduledTransferWorkflow(ctx workflow.Context, schedule models.Schedule) error {
+	ctx = workflow.WithActivityOptions(ctx, s.getDefaultOptions())
+	logger := workflow.GetLogger(ctx)
+	logger.Debug("ScheduledTransferWorkflow start")
+
+	runs := 0
+	for {
+		if runs >= scheduleRunsPerExecution {
+			// the pending signals are handled before continuing as new, so none of them is lost
+			for s.receiveScheduleSignal(ctx, &schedule) {
+				logger.Debug("schedule signal received")
+			}
+			err := workflow.ExecuteActivity(ctx, s.UpdateSchedule, schedule).Get(ctx, nil)
+			if err != nil {
+				return err
+			}
+			if schedule.Status == models.ScheduleStatusCancelled {
+				return nil
+			}
+			return workflow.NewContinueAsNewError(ctx, s.ScheduledTransferWorkflow, schedule)
+		}
+
+		status := schedule.Status
+		due := s.waitSchedule(ctx, &schedule)
+
+		switch {
+		case schedule.Status == models.ScheduleStatusCancelled:
+			err := workflow.ExecuteActivity(ctx, s.UpdateSchedule, schedule).Get(ctx, nil)
+			if err != nil {
+				return err
+			}
+			logger.Debug("ScheduledTransferWorkflow cancelled")
+			return nil
+		case schedule.Status != status:
+			err := workflow.ExecuteActivity(ctx, s.UpdateSchedule, schedule).Get(ctx, nil)
+			if err != nil {
+				return err
+			}
+			continue
+		case !due:
+			continue
+		}
+
+		s.runScheduledTransfer(ctx, &schedule)
+		runs++
+
+		// a long run must not make the next one late, the runs missed meanwhile are skipped
+		next, err := schedule.NextRunAfter(workflow.Now(ctx))
+		if err != nil {
+			return err
+		}
+		schedule.NextRunAt = next
+		if next.IsZero() {
+			schedule.Status = models.ScheduleStatusCompleted
+		}
+
+		err = workflow.ExecuteActivity(ctx, s.UpdateSchedule, schedule).Get(ctx, nil)
+		if err != nil {
+			return err
+		}
+		if schedule.Status == models.ScheduleStatusCompleted {
+			logger.Debug("ScheduledTransferWorkflow completed")
+			return nil
+		}
+	}
+}
+
+// waitSchedule waits for the next run or a signal. It returns true when the run is due.
+func (s *UserSagaWorkflow) waitSchedule(ctx workflow.Context, schedule *models.Schedule) bool {
+	timerCtx, cancelTimer := workflow.WithCancel(ctx)
+	defer cancelTimer()
+
+	due := false
+	selector := s.scheduleSignalsSelector(ctx, schedule)
+	if schedule.Status == models.ScheduleStatusActive {
+		// a zero timer fires at once, so the runs in the past are made immediately
+		wait := max(schedule.NextRunAt.Sub(workflow.Now(ctx)), 0)
+		selector.AddFuture(workflow.NewTimer(timerCtx, wait), func(f workflow.Future) {
+			due = f.Get(ctx, nil) == nil
+		})
+	}
+	selector.Select(ctx)
+
+	return due
+}
+
+// receiveScheduleSignal handles one pending signal without blocking, it returns false when there are none.
+func (s *UserSagaWorkflow) receiveScheduleSignal(ctx workflow.Context, schedule *models.Schedule) bool {
+	selector := s.scheduleSignalsSelector(ctx, schedule)
+	if !selector.HasPending() {
+		return false
+	}
+	selector.Select(ctx)
+	return true
+}
+
+func (s *UserSagaWorkflow) scheduleSignalsSelector(ctx workflow.Context, schedule *models.Schedule) workflow.Selector {
+	logger := workflow.GetLogger(ctx)
+	selector := workflow.NewSelector(ctx)
+	selector.AddReceive(workflow.GetSignalChannel(ctx, SchedulePauseSignal), func(c workflow.ReceiveChannel, more bool) {
+		c.Receive(ctx, nil)
+		if schedule.Status == models.ScheduleStatusActive {
+			schedule.Status = models.ScheduleStatusPaused
+		}
+	})
+	selector.AddReceive(workflow.GetSignalChannel(ctx, ScheduleResumeSignal), func(c workflow.ReceiveChannel, more bool) {
+		c.Receive(ctx, nil)
+		if schedule.Status != models.ScheduleStatusPaused {
+			return
+		}
+		next, err := schedule.NextRunAfter(workflow.Now(ctx))
+		if err != nil {
+			logger.Error("failed to resume schedule", zap.Error(err))
+			return
+		}
+		schedule.Status = models.ScheduleStatusActive
+		schedule.NextRunAt = next
+	})
+	selector.AddReceive(workflow.GetSignalChannel(ctx, ScheduleCancelSignal), func(c workflow.ReceiveChannel, more bool) {
+		c.Receive(ctx, nil)
+		schedule.Status = models.ScheduleStatusCancelled
+		schedule.NextRunAt = time.Time{}
+	})
+
+	return selector
+}
+
+func (s *UserSagaWorkflow) runScheduledTransfer(ctx workflow.Context, schedule *models.Schedule) {
+	logger := workflow.GetLogger(ctx)
+
+	var transactionID string
+	err := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+		return uuid.Must(uuid.NewV7()).String()
+	}).Get(&transactionID)
+	if err != nil {
+		schedule.LastError = err.Error()
+		return
+	}
+
+	schedule.Runs++
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID: fmt.Sprintf("%s-%d", scheduleWorkflowID(schedule.ID), schedule.Runs),
+		TaskQueue:  TransferTaskQueue,
+	})
+	params := TransferMoneyParams{
+		From:          schedule.FromID,
+		To:            schedule.ToID,
+		TransactionID: transactionID,
+		Amount:        schedule.Amount,
+	}
+
+	err = workflow.ExecuteChildWorkflow(childCtx, s.TransferMoneyWorkflow, params).Get(ctx, nil)
+	if err != nil {
+		logger.Error("scheduled transfer fails", zap.Error(err))
+		schedule.LastError = err.Error()
+		return
+	}
+	schedule.LastError = ""
+}
+
+func (s *UserSagaWorkflow) UpdateSchedule(ctx context.Context, schedule models.Schedule) error {
+	return s.userService.UpdateSchedule(ctx, schedule)
+}
@@ -5,6 +5,7 @@ import (
 	"errors"
 	"fmt"
 	"github.com/google/uuid"
+	"go.opentelemetry.io/otel/attribute"
 	"go.temporal.io/sdk/activity"
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/temporal"
@@ -13,21 +14,162 @@ import (
 	"time"
 	"usershards/internal/apperrors"
 	"usershards/internal/models"
+	"usershards/internal/tracing"
 )
 
 type step int
 
 const stepNoCompensations step = 0
 const stepIncreaseFailed step = 1
+const stepFeeFailed step = 2
+const stepSplitCreditFailed step = 3
+
+// SplitPart is the share of one recipient of the split transfer.
+type SplitPart struct {
+	To     int64
+	Amount int64
+}
 
 type TransferMoneyParams struct {
 	From          int64
 	To            int64
 	TransactionID string
-	Amount        int64
+	Amount        int64           // in minor units of Currency
+	Currency      models.Currency // models.DefaultCurrency if empty
+	ToCurrency    models.Currency // the recipient is credited in Currency if empty
+	Rate          models.Rate     // required when ToCurrency differs from Currency
+	Fee           models.Fee      // calculated by the workflow, charged in Currency
+	HoldID        string          // the amount and the fee are debited from this hold of the sender if set
+	Split         []SplitPart     // the recipients instead of To, Amount is their total
+	Credited      int             // the split parts credited so far, set by the workflow for compensations
+}
+
+// SpanAttributes tags the spans of the transfer with the sender and the recipient and their shards.
+func (p TransferMoneyParams) SpanAttributes() []attribute.KeyValue {
+	return tracing.Transfer(p.TransactionID, p.From, p.To)
+}
+
+// LogFields tags the logs of the transfer the same way.
+func (p TransferMoneyParams) LogFields() []zap.Field {
+	return transferLogFields(p.TransactionID, p.From, p.To)
+}
+
+// Debit is the money taken from the sender, without the fee.
+func (p TransferMoneyParams) Debit() models.Money {
+	if p.Currency == "" {
+		return models.NewMoney(p.Amount, models.DefaultCurrency)
+	}
+	return models.NewMoney(p.Amount, p.Currency)
+}
+
+// Credit is the money the recipient gets, converted with Rate when the currencies differ.
+// The conversion is checked by validateCurrencies, the params are validated before the transfer starts.
+func (p TransferMoneyParams) Credit() models.Money {
+	debit := p.Debit()
+	if p.ToCurrency == "" || p.ToCurrency == debit.Currency {
+		return debit
+	}
+	credit, _ := p.Rate.Convert(p.Amount)
+	return models.NewMoney(credit, p.ToCurrency)
+}
+
+// converts tells whether the recipient is credited in another currency. The FX treasury takes the other side
+// of the conversion then, as in the exchange: it gets the debit and pays the credit.
+func (p TransferMoneyParams) converts() bool {
+	return p.Credit().Currency != p.Debit().Currency
+}
+
+func (p TransferMoneyParams) validateCurrencies() error {
+	debit := p.Debit()
+	if !debit.Currency.Valid() || (p.ToCurrency != "" && !p.ToCurrency.Valid()) {
+		return apperrors.ErrInvalidCurrency
+	}
+	if p.ToCurrency != "" && p.ToCurrency != debit.Currency {
+		if p.Rate <= 0 {
+			return apperrors.ErrCurrencyMismatch
+		}
+		if _, err := p.Rate.Convert(p.Amount); err != nil {
+			return err
+		}
+	}
+	return nil
 }
 
+func (p TransferMoneyParams) validateSplit() error {
+	if len(p.Split) == 0 {
+		return nil
+	}
+	if p.To != 0 || (p.ToCurrency != "" && p.ToCurrency != p.Debit().Currency) {
+		return apperrors.ErrInvalidSplit
+	}
+
+	total := int64(0)
+	recipients := make(map[int64]struct{}, len(p.Split))
+	for _, part := range p.Split {
+		if _, ok := recipients[part.To]; ok || part.Amount <= 0 || part.To == p.From {
+			return apperrors.ErrInvalidSplit
+		}
+		recipients[part.To] = struct{}{}
+		total += part.Amount
+	}
+	if total != p.Amount {
+		return apperrors.ErrInvalidSplit
+	}
+
+	return nil
+}
+
+// TransferMoney moves amount in the default currency.
 func (s *UserSagaWorkflow) TransferMoney(ctx context.Context, from, to int64, amount int64) error {
+	return s.transferMoney(ctx, TransferMoneyParams{
+		From:   from,
+		To:     to,
+		Amount: amount,
+	})
+}
+
+// TransferCurrency moves money from the sender's account in money.Currency. The recipient is credited
+// in toCurrency, which requires a conversion rate when it differs from money.Currency.
+func (s *UserSagaWorkflow) TransferCurrency(
+	ctx context.Context,
+	from,
+	to int64,
+	money models.Money,
+	toCurrency models.Currency,
+	rate models.Rate,
+) error {
+	return s.transferMoney(ctx, TransferMoneyParams{
+		From:       from,
+		To:         to,
+		Amount:     money.Amount,
+		Currency:   money.Currency,
+		ToCurrency: toCurrency,
+		Rate:       rate,
+	})
+}
+
+// TransferSplit pays several recipients in the default currency with one debit of the sender. Either all
+// recipients are credited or the transfer is compensated as a whole, the fee is the sum of the parts' fees.
+func (s *UserSagaWorkflow) TransferSplit(ctx context.Context, from int64, parts []SplitPart) error {
+	params := TransferMoneyParams{
+		From:  from,
+		Split: parts,
+	}
+	for _, part := range parts {
+		params.Amount += part.Amount
+	}
+
+	return s.transferMoney(ctx, params)
+}
+
+func (s *UserSagaWorkflow) transferMoney(ctx context.Context, params TransferMoneyParams) error {
+	if err := params.validateCurrencies(); err != nil {
+		return err
+	}
+	if err := params.validateSplit(); err != nil {
+		return err
+	}
+
 	// move outside
 	transactionID, err := uuid.NewV7()
 	if err != nil {
@@ -39,12 +181,7 @@ func (s *UserSagaWorkflow) TransferMoney(ctx context.Context, from, to int64, am
 		TaskQueue: TransferTaskQueue,
 	}
 
-	params := TransferMoneyParams{
-		From:          from,
-		To:            to,
-		TransactionID: transactionID.String(),
-		Amount:        amount,
-	}
+	params.TransactionID = transactionID.String()
 
 	we, err := s.temporalClient.ExecuteWorkflow(ctx, workflowOptions, s.TransferMoneyWorkflow, params)
 	if err != nil {
@@ -65,12 +202,59 @@ func (s *UserSagaWorkflow) TransferMoneyWorkflow(ctx workflow.Context, params Tr
 	logger := workflow.GetLogger(ctx)
 	logger.Debug("TransferMoneyWorkflow start")
 
-	err := workflow.ExecuteActivity(ctx, s.DecreaseMoney, params).Get(ctx, nil)
+	err := params.validateCurrencies()
+	if err != nil {
+		if errors.Is(err, apperrors.ErrInvalidCurrency) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrInvalidCurrency", err)
+		}
+		if errors.Is(err, apperrors.ErrAmountOverflow) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrAmountOverflow", err)
+		}
+		return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrCurrencyMismatch", err)
+	}
+	err = params.validateSplit()
+	if err != nil {
+		return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrInvalidSplit", err)
+	}
+
+	err = workflow.ExecuteActivity(ctx, s.CalculateFee, params).Get(ctx, &params.Fee)
 	if err != nil {
 		return s.Compensations(ctx, stepNoCompensations, err, params)
 	}
 
-	err = workflow.ExecuteActivity(ctx, s.IncreaseMoney, params).Get(ctx, nil)
+	// a conversion posts the treasury entries too, once the first entry is posted the rest must follow
+	moneyCtx := ctx
+	if params.converts() {
+		moneyCtx = workflow.WithActivityOptions(ctx, s.getLedgerOptions())
+	}
+
+	// the sender pays amount and fee in one debit
+	err = workflow.ExecuteActivity(moneyCtx, s.DecreaseMoney, params).Get(ctx, nil)
+	if err != nil {
+		return s.Compensations(ctx, stepNoCompensations, err, params)
+	}
+
+	if params.Fee.Amount > 0 {
+		err = workflow.ExecuteActivity(ctx, s.CreditFee, params).Get(ctx, nil)
+		if err != nil {
+			return s.Compensations(ctx, stepFeeFailed, err, params)
+		}
+	}
+
+	if len(params.Split) > 0 {
+		for part := range params.Split {
+			err = workflow.ExecuteActivity(ctx, s.CreditSplitPart, params, part).Get(ctx, nil)
+			if err != nil {
+				params.Credited = part
+				return s.Compensations(ctx, stepSplitCreditFailed, err, params)
+			}
+		}
+
+		logger.Debug("TransferMoneyWorkflow stop")
+		return nil
+	}
+
+	err = workflow.ExecuteActivity(moneyCtx, s.IncreaseMoney, params).Get(ctx, nil)
 	if err != nil {
 		return s.Compensations(ctx, stepIncreaseFailed, err, params)
 	}
@@ -85,9 +269,26 @@ func (s *UserSagaWorkflow) DecreaseMoney(
 ) error {
 	logger := activity.GetLogger(ctx)
 	logger.Debug("DecreaseMoney start")
-	err := s.userService.DecreaseMoneyFromUser(ctx, params.TransactionID, models.TransactionTypeDecrease, params.From, params.To, params.Amount)
+	var err error
+	if params.HoldID != "" {
+		err = s.userService.DecreaseHeldMoney(ctx, params.TransactionID, params.HoldID, params.From, params.To, params.Debit(), params.Fee)
+	} else {
+		err = s.userService.DecreaseMoneyFromUser(ctx, params.TransactionID, models.TransactionTypeDecrease, params.From, params.To, params.Debit(), params.Fee)
+	}
+	if err == nil && params.converts() {
+		err = s.userService.IncreaseMoneyToUser(ctx, params.TransactionID, models.TransactionTypeFXTreasuryIn, params.From, s.userService.FXTreasuryAccount(), params.Debit())
+	}
 	if err != nil {
 		logger.Error("DecreaseMoney fails", zap.Error(err))
+		if errors.Is(err, apperrors.ErrLimitExceeded) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrLimitExceeded", apperrors.ErrLimitExceeded)
+		}
+		if errors.Is(err, apperrors.ErrHoldNotActive) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrHoldNotActive", apperrors.ErrHoldNotActive)
+		}
+		if errors.Is(err, apperrors.ErrInsufficientFunds) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrInsufficientFunds", apperrors.ErrInsufficientFunds)
+		}
 	}
 
 	return err
@@ -99,7 +300,10 @@ func (s *UserSagaWorkflow) IncreaseMoney(
 ) error {
 	logger := activity.GetLogger(ctx)
 	logger.Debug("IncreaseMoney start")
-	err := s.userService.IncreaseMoneyToUser(ctx, params.TransactionID, models.TransactionTypeIncrease, params.From, params.To, params.Amount)
+	err := s.userService.IncreaseMoneyToUser(ctx, params.TransactionID, models.TransactionTypeIncrease, params.From, params.To, params.Credit())
+	if err == nil && params.converts() {
+		err = s.userService.DecreaseMoneyFromUser(ctx, params.TransactionID, models.TransactionTypeFXTreasuryOut, s.userService.FXTreasuryAccount(), params.To, params.Credit(), models.Fee{})
+	}
 	if err != nil {
 		logger.Error("IncreaseMoney fails", zap.Error(err))
 		if errors.Is(err, apperrors.ErrUserIsBlocked) {
@@ -110,13 +314,84 @@ func (s *UserSagaWorkflow) IncreaseMoney(
 	return err
 }
 
+func (s *UserSagaWorkflow) CreditSplitPart(
+	ctx context.Context,
+	params TransferMoneyParams,
+	part int,
+) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("CreditSplitPart start")
+	money := models.NewMoney(params.Split[part].Amount, params.Debit().Currency)
+	err := s.userService.IncreaseSplitMoney(ctx, params.TransactionID, params.From, params.Split[part].To, money)
+	if err != nil {
+		logger.Error("CreditSplitPart fails", zap.Error(err))
+		if errors.Is(err, apperrors.ErrUserIsBlocked) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrUserIsBlocked", apperrors.ErrUserIsBlocked)
+		}
+	}
+
+	return err
+}
+
+func (s *UserSagaWorkflow) ReverseSplitPart(
+	ctx context.Context,
+	params TransferMoneyParams,
+	part int,
+) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("ReverseSplitPart start")
+	money := models.NewMoney(params.Split[part].Amount, params.Debit().Currency)
+	err := s.userService.ReverseSplitMoney(ctx, params.TransactionID, params.Split[part].To, params.From, money)
+	if err != nil {
+		logger.Error("ReverseSplitPart fails", zap.Error(err))
+	}
+
+	return err
+}
+
+// FailTransfer tells the sender that the transfer has failed, the money is already returned then.
+func (s *UserSagaWorkflow) FailTransfer(
+	ctx context.Context,
+	params TransferMoneyParams,
+	reason string,
+) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("FailTransfer start")
+	debit := params.Debit()
+	err := s.userService.RecordTransferFailed(ctx, models.TransferFailedEvent{
+		TransferID: params.TransactionID,
+		FromID:     params.From,
+		ToID:       params.To,
+		Amount:     debit.Amount,
+		Currency:   debit.Currency,
+		Reason:     reason,
+	})
+	if err != nil {
+		logger.Error("FailTransfer fails", zap.Error(err))
+	}
+
+	return err
+}
+
 func (s *UserSagaWorkflow) CompensateMoney(
 	ctx context.Context,
 	params TransferMoneyParams,
 ) error {
 	logger := activity.GetLogger(ctx)
 	logger.Debug("CompensateMoney start")
-	err := s.userService.IncreaseMoneyToUser(ctx, params.TransactionID, models.TransactionTypeCompensate, params.To, params.From, params.Amount)
+	var err error
+	if params.converts() {
+		err = s.userService.DecreaseMoneyFromUser(ctx, params.TransactionID, models.TransactionTypeFXTreasuryReversal, s.userService.FXTreasuryAccount(), params.From, params.Debit(), models.Fee{})
+		if err != nil {
+			logger.Error("CompensateMoney fails", zap.Error(err))
+			return err
+		}
+	}
+
+	// the fee was debited together with the amount, so it is returned together too
+	refund := params.Debit()
+	refund.Amount += params.Fee.Amount
+	err = s.userService.IncreaseMoneyToUser(ctx, params.TransactionID, models.TransactionTypeCompensate, params.To, params.From, refund)
 	if err != nil {
 		logger.Error("CompensateMoney fails", zap.Error(err))
 	}
@@ -124,6 +399,54 @@ func (s *UserSagaWorkflow) CompensateMoney(
 	return err
 }
 
+func (s *UserSagaWorkflow) CalculateFee(
+	ctx context.Context,
+	params TransferMoneyParams,
+) (models.Fee, error) {
+	if len(params.Split) == 0 {
+		return s.userService.CalculateTransferFee(params.From, params.To, params.Debit()), nil
+	}
+
+	fee := models.Fee{}
+	for _, part := range params.Split {
+		partFee := s.userService.CalculateTransferFee(params.From, part.To, models.NewMoney(part.Amount, params.Debit().Currency))
+		if partFee.Amount > 0 {
+			fee.Amount += partFee.Amount
+			fee.AccountID = partFee.AccountID
+		}
+	}
+
+	return fee, nil
+}
+
+func (s *UserSagaWorkflow) CreditFee(
+	ctx context.Context,
+	params TransferMoneyParams,
+) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("CreditFee start")
+	err := s.userService.IncreaseMoneyToUser(ctx, params.TransactionID, models.TransactionTypeFeeIncome, params.From, params.Fee.AccountID, models.NewMoney(params.Fee.Amount, params.Debit().Currency))
+	if err != nil {
+		logger.Error("CreditFee fails", zap.Error(err))
+	}
+
+	return err
+}
+
+func (s *UserSagaWorkflow) ReverseFee(
+	ctx context.Context,
+	params TransferMoneyParams,
+) error {
+	logger := activity.GetLogger(ctx)
+	logger.Debug("ReverseFee start")
+	err := s.userService.DecreaseMoneyFromUser(ctx, params.TransactionID, models.TransactionTypeFeeReversal, params.Fee.AccountID, params.From, models.NewMoney(params.Fee.Amount, params.Debit().Currency), models.Fee{})
+	if err != nil {
+		logger.Error("ReverseFee fails", zap.Error(err))
+	}
+
+	return err
+}
+
 func (s *UserSagaWorkflow) Compensations(
 	ctx workflow.Context,
 	stepNumber step,
@@ -133,17 +456,46 @@ func (s *UserSagaWorkflow) Compensations(
 	logger := workflow.GetLogger(ctx)
 	logger.Debug("Compensations start")
 
+	// the reversals post several entries, the reversal of a spent credit or of a conversion
+	// posts to the system accounts too, once started they must complete
+	ledgerCtx := workflow.WithActivityOptions(ctx, s.getLedgerOptions())
+
 	switch stepNumber {
+	case stepSplitCreditFailed:
+		logger.Debug("stepSplitCreditFailed start")
+		for part := params.Credited - 1; part >= 0; part-- {
+			compensateErr := workflow.ExecuteActivity(ledgerCtx, s.ReverseSplitPart, params, part).Get(ctx, nil)
+			if compensateErr != nil {
+				logger.Debug("stepSplitCreditFailed error", zap.Error(compensateErr))
+				return compensateErr
+			}
+		}
+		fallthrough
 	case stepIncreaseFailed:
 		logger.Debug("stepIncreaseFailed start")
-		compensateErr := workflow.ExecuteActivity(ctx, s.CompensateMoney, params).Get(ctx, nil)
+		if params.Fee.Amount > 0 {
+			compensateErr := workflow.ExecuteActivity(ctx, s.ReverseFee, params).Get(ctx, nil)
+			if compensateErr != nil {
+				logger.Debug("stepIncreaseFailed error", zap.Error(compensateErr))
+				return compensateErr
+			}
+		}
+		fallthrough
+	case stepFeeFailed:
+		logger.Debug("stepFeeFailed start")
+		compensateErr := workflow.ExecuteActivity(ledgerCtx, s.CompensateMoney, params).Get(ctx, nil)
 		if compensateErr != nil {
-			logger.Debug("stepIncreaseFailed error", zap.Error(compensateErr))
+			logger.Debug("stepFeeFailed error", zap.Error(compensateErr))
 			return compensateErr
 		}
 		fallthrough
 	case stepNoCompensations:
 		logger.Debug("stepNoCompensations  start")
+		failErr := workflow.ExecuteActivity(ctx, s.FailTransfer, params, err.Error()).Get(ctx, nil)
+		if failErr != nil {
+			logger.Debug("stepNoCompensations error", zap.Error(failErr))
+			return failErr
+		}
 		return temporal.NewNonRetryableApplicationError(apperrors.ErrCompensationCompleted.Error(),
 			"apperrors.ErrCompensationCompleted", apperrors.ErrCompensationCompleted)
 	}
@@ -151,6 +503,16 @@ func (s *UserSagaWorkflow) Compensations(
 	return err
 }
 
+// getLedgerOptions are the options of the activities which post several entries one by one. Once the first
+// entry is posted the rest must follow, so only the business errors stop the retries, not the attempts.
+func (s *UserSagaWorkflow) getLedgerOptions() workflow.ActivityOptions {
+	options := s.getDefaultOptions()
+	options.ScheduleToCloseTimeout = 0
+	options.RetryPolicy.MaximumInterval = time.Minute
+	options.RetryPolicy.MaximumAttempts = 0
+	return options
+}
+
 func (s *UserSagaWorkflow) getDefaultOptions() workflow.ActivityOptions {
 	return workflow.ActivityOptions{
 		ScheduleToCloseTimeout: 10 * time.Second,
@@ -163,6 +525,17 @@ func (s *UserSagaWorkflow) getDefaultOptions() workflow.ActivityOptions {
 			NonRetryableErrorTypes: []string{
 				"apperrors.ErrCompensationCompleted",
 				"apperrors.ErrUserIsBlocked",
+				"apperrors.ErrHoldNotActive",
+				"apperrors.ErrLimitExceeded",
+				"apperrors.ErrCurrencyMismatch",
+				"apperrors.ErrInvalidCurrency",
+				"apperrors.ErrAmountOverflow",
+				"apperrors.ErrQuoteExpired",
+				"apperrors.ErrQuoteNotFound",
+				"apperrors.ErrQuoteUsed",
+				"apperrors.ErrInsufficientFunds",
+				"apperrors.ErrPaymentDeclined",
+				"apperrors.ErrInvalidSplit",
 			},
 		},
 	}
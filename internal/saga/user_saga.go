@@ -3,13 +3,17 @@ package saga
 import (
 	"context"
 	"fmt"
+	"github.com/google/uuid"
 	"go.temporal.io/sdk/client"
 	"go.temporal.io/sdk/temporal"
 	"go.temporal.io/sdk/workflow"
 	"go.uber.org/zap"
 	"usershards/internal/apperrors"
 	"usershards/internal/id"
+	"usershards/internal/models"
+	"usershards/internal/payments"
 	"usershards/internal/shard"
+	"usershards/internal/webhooks"
 )
 
 // User saga step constants
@@ -17,16 +21,22 @@ type userStep int
 
 const userStepNoCompensations userStep = 0
 const userStepEmailCreated userStep = 1
+const userStepUserCreated userStep = 2
+const userStepBonusReserved userStep = 3
 
 type UserSagaWorkflow struct {
-	userService    userService
-	temporalClient client.Client
+	userService     userService
+	temporalClient  client.Client
+	paymentProvider payments.Provider
+	webhookClient   *webhooks.Client
 }
 
-func NewUserSagaWorkflow(userService userService, client client.Client) *UserSagaWorkflow {
+func NewUserSagaWorkflow(userService userService, client client.Client, provider payments.Provider) *UserSagaWorkflow {
 	return &UserSagaWorkflow{
-		userService:    userService,
-		temporalClient: client,
+		userService:     userService,
+		temporalClient:  client,
+		paymentProvider: provider,
+		webhookClient:   webhooks.NewClient(userService.WebhookTimeout()),
 	}
 }
 
@@ -74,14 +84,66 @@ func (s *UserSagaWorkflow) CreateUserWorkflow(ctx workflow.Context, userID int64
 	}
 	logger.Debug("CreateUserRecord stop")
 
+	logger.Debug("ReserveBonus start")
+	var grant models.BonusGrant
+	err = workflow.ExecuteActivity(ctx, s.ReserveBonus, userID, phone).Get(ctx, &grant)
+	if err != nil {
+		logger.Error("ReserveBonus fails", zap.Error(err))
+		return 0, s.UserCompensations(ctx, userStepUserCreated, err, userID, email)
+	}
+	logger.Debug("ReserveBonus stop")
+
+	if grant.Amount > 0 {
+		logger.Debug("TransferBonus start")
+		err = s.transferBonus(ctx, grant)
+		if err != nil {
+			logger.Error("TransferBonus fails", zap.Error(err))
+			return 0, s.UserCompensations(ctx, userStepBonusReserved, err, userID, email)
+		}
+		logger.Debug("TransferBonus stop")
+	}
+
 	logger.Debug("CreateUserWorkflow completed")
 	return userID, nil
 }
 
+// transferBonus pays the reserved bonus from the bonus pool by the usual transfer saga,
+// which compensates itself on failure.
+func (s *UserSagaWorkflow) transferBonus(ctx workflow.Context, grant models.BonusGrant) error {
+	var transactionID string
+	err := workflow.SideEffect(ctx, func(ctx workflow.Context) interface{} {
+		return uuid.Must(uuid.NewV7()).String()
+	}).Get(&transactionID)
+	if err != nil {
+		return err
+	}
+
+	childCtx := workflow.WithChildOptions(ctx, workflow.ChildWorkflowOptions{
+		WorkflowID: fmt.Sprintf("bonus-%d", grant.UserID),
+		TaskQueue:  TransferTaskQueue,
+	})
+	params := TransferMoneyParams{
+		From:          s.userService.SystemAccount(models.SystemAccountBonusPool),
+		To:            grant.UserID,
+		TransactionID: transactionID,
+		Amount:        grant.Amount,
+	}
+
+	return workflow.ExecuteChildWorkflow(childCtx, s.TransferMoneyWorkflow, params).Get(ctx, nil)
+}
+
 func (s *UserSagaWorkflow) CreateUserRecord(ctx context.Context, userID int64, phone, email string) error {
 	return s.userService.CreateUserRecord(ctx, userID, phone, email)
 }
 
+func (s *UserSagaWorkflow) ReserveBonus(ctx context.Context, userID int64, phone string) (models.BonusGrant, error) {
+	return s.userService.ReserveBonus(ctx, userID, phone)
+}
+
+func (s *UserSagaWorkflow) ReleaseBonus(ctx context.Context, userID int64) error {
+	return s.userService.ReleaseBonus(ctx, userID)
+}
+
 func (s *UserSagaWorkflow) DeleteUserRecordIfPresentByUserID(ctx context.Context, userID int64) error {
 	return s.userService.DeleteUserRecordIfPresentByUserID(ctx, userID)
 }
@@ -110,6 +172,22 @@ func (s *UserSagaWorkflow) UserCompensations(
 	logger.Debug("User Compensations start")
 
 	switch stepNumber {
+	case userStepBonusReserved:
+		logger.Debug("userStepBonusReserved compensation start")
+		compensateErr := workflow.ExecuteActivity(ctx, s.ReleaseBonus, userID).Get(ctx, nil)
+		if compensateErr != nil {
+			logger.Debug("userStepBonusReserved compensation error", zap.Error(compensateErr))
+			return compensateErr
+		}
+		fallthrough
+	case userStepUserCreated:
+		logger.Debug("userStepUserCreated compensation start")
+		compensateErr := workflow.ExecuteActivity(ctx, s.DeleteUserRecordIfPresentByUserID, userID).Get(ctx, nil)
+		if compensateErr != nil {
+			logger.Debug("userStepUserCreated compensation error", zap.Error(compensateErr))
+			return compensateErr
+		}
+		fallthrough
 	case userStepEmailCreated:
 		logger.Debug("userStepEmailCreated compensation start")
 		compensateErr := workflow.ExecuteActivity(ctx, s.DeleteEmailRecordIfPresentByUserID, email).Get(ctx, nil)
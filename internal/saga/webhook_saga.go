@@ -0,0 +1,189 @@
+package saga
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/temporal"
+	"go.temporal.io/sdk/workflow"
+	"slices"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/models"
+	"usershards/internal/webhooks"
+)
+
+// webhookInitialInterval is the pause after the first failed attempt, it doubles after every next one.
+const webhookInitialInterval = time.Second
+const webhookMaximumInterval = time.Hour
+
+type WebhookDeliveryParams struct {
+	DeliveryID  string
+	MaxAttempts int
+}
+
+func webhookWorkflowID(deliveryID string) string {
+	return "webhook-" + deliveryID
+}
+
+// DispatchWebhooks logs a delivery of every event to every webhook which accepts it and starts the deliveries.
+// It is an outbox sink, so an event published again gets the same deliveries and they are not sent twice.
+func (s *UserSagaWorkflow) DispatchWebhooks(ctx context.Context, events []models.Event) error {
+	var registered []models.Webhook
+	loaded := false
+
+	for _, event := range events {
+		if !slices.Contains(models.WebhookEventTypes, event.Type) {
+			continue
+		}
+		if !loaded {
+			var err error
+			registered, err = s.userService.ListWebhooks(ctx)
+			if err != nil {
+				return err
+			}
+			loaded = true
+		}
+
+		for _, webhook := range registered {
+			if !webhook.Accepts(event.Type) {
+				continue
+			}
+
+			webhookID, err := uuid.Parse(webhook.ID)
+			if err != nil {
+				return err
+			}
+			deliveryID := uuid.NewSHA1(webhookID, []byte(fmt.Sprintf("%d:%d", event.Shard, event.ID))).String()
+			body, err := webhooks.NewBody(deliveryID, event)
+			if err != nil {
+				return err
+			}
+
+			delivery, err := s.userService.CreateWebhookDelivery(ctx, models.WebhookDelivery{
+				ID:        deliveryID,
+				WebhookID: webhook.ID,
+				EventType: event.Type,
+				Payload:   body,
+			})
+			if err != nil {
+				return err
+			}
+			if delivery.Status != models.WebhookDeliveryStatusPending {
+				continue
+			}
+
+			// a delivery which is already running is left as it is
+			err = s.startWebhookDelivery(ctx, deliveryID)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// RedeliverWebhook sends the logged delivery again with a fresh set of attempts. The delivery which is
+// still being attempted is not started twice, the running attempts go on.
+func (s *UserSagaWorkflow) RedeliverWebhook(ctx context.Context, deliveryID string) error {
+	err := s.userService.SetWebhookDeliveryStatus(ctx, deliveryID, models.WebhookDeliveryStatusPending)
+	if err != nil {
+		return err
+	}
+
+	return s.startWebhookDelivery(ctx, deliveryID)
+}
+
+func (s *UserSagaWorkflow) startWebhookDelivery(ctx context.Context, deliveryID string) error {
+	workflowOptions := client.StartWorkflowOptions{
+		ID:        webhookWorkflowID(deliveryID),
+		TaskQueue: TransferTaskQueue,
+	}
+	params := WebhookDeliveryParams{
+		DeliveryID:  deliveryID,
+		MaxAttempts: s.userService.WebhookMaxAttempts(),
+	}
+
+	_, err := s.temporalClient.ExecuteWorkflow(ctx, workflowOptions, s.WebhookDeliveryWorkflow, params)
+	if err != nil {
+		return fmt.Errorf("failed to start workflows: %w", err)
+	}
+
+	return nil
+}
+
+// WebhookDeliveryWorkflow sends the delivery until the webhook accepts it or the attempts run out,
+// the pause between the attempts grows exponentially. Every attempt is recorded in the delivery log.
+func (s *UserSagaWorkflow) WebhookDeliveryWorkflow(
+	ctx workflow.Context,
+	params WebhookDeliveryParams,
+) (models.WebhookDeliveryStatus, error) {
+	ctx = workflow.WithActivityOptions(ctx, s.getDefaultOptions())
+	logger := workflow.GetLogger(ctx)
+	logger.Debug("WebhookDeliveryWorkflow start")
+
+	sendCtx := workflow.WithActivityOptions(ctx, workflow.ActivityOptions{
+		StartToCloseTimeout: s.userService.WebhookTimeout() + 5*time.Second,
+		RetryPolicy: &temporal.RetryPolicy{
+			InitialInterval:        webhookInitialInterval,
+			BackoffCoefficient:     2.0,
+			MaximumInterval:        webhookMaximumInterval,
+			MaximumAttempts:        int32(params.MaxAttempts),
+			NonRetryableErrorTypes: []string{"apperrors.ErrWebhookNotFound"},
+		},
+	})
+
+	status := models.WebhookDeliveryStatusDelivered
+	err := workflow.ExecuteActivity(sendCtx, s.SendWebhook, params).Get(ctx, nil)
+	if err != nil {
+		logger.Debug("webhook delivery failed", "error", err)
+		status = models.WebhookDeliveryStatusFailed
+	}
+
+	err = workflow.ExecuteActivity(ctx, s.FinishWebhookDelivery, params, status).Get(ctx, nil)
+	if err != nil {
+		return status, err
+	}
+
+	logger.Debug("WebhookDeliveryWorkflow stop")
+	return status, nil
+}
+
+// SendWebhook makes one attempt of the delivery and records it.
+func (s *UserSagaWorkflow) SendWebhook(ctx context.Context, params WebhookDeliveryParams) error {
+	delivery, err := s.userService.GetWebhookDelivery(ctx, params.DeliveryID)
+	if err != nil {
+		return err
+	}
+	webhook, err := s.userService.GetWebhook(ctx, delivery.WebhookID)
+	if err != nil {
+		if errors.Is(err, apperrors.ErrWebhookNotFound) {
+			return temporal.NewNonRetryableApplicationError(err.Error(), "apperrors.ErrWebhookNotFound", err)
+		}
+		return err
+	}
+
+	code, sendErr := s.webhookClient.Send(ctx, *webhook, *delivery)
+	lastError := ""
+	if sendErr != nil {
+		lastError = sendErr.Error()
+	}
+
+	err = s.userService.RecordWebhookAttempt(ctx, delivery.ID, code, lastError)
+	if err != nil {
+		return err
+	}
+
+	return sendErr
+}
+
+func (s *UserSagaWorkflow) FinishWebhookDelivery(
+	ctx context.Context,
+	params WebhookDeliveryParams,
+	status models.WebhookDeliveryStatus,
+) error {
+	return s.userService.SetWebhookDeliveryStatus(ctx, params.DeliveryID, status)
+}
@@ -2,12 +2,16 @@ package saga
 
 import (
 	"context"
+	"fmt"
 	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/interceptor"
 	"go.temporal.io/sdk/worker"
 	"go.temporal.io/sdk/workflow"
 	"go.uber.org/zap"
+	"sync/atomic"
 	"time"
 	"usershards/internal/logger"
+	"usershards/internal/metrics"
 	"usershards/internal/models"
 	"usershards/internal/shard"
 )
@@ -15,6 +19,10 @@ import (
 const TaskQueue = "user-task-queue"
 const TransferTaskQueue = "transfer-task-queue"
 
+// SagaWorkflowTypes are the workflows which roll back on failure, they are expected to finish within minutes.
+var SagaWorkflowTypes = []string{"TransferMoneyWorkflow", "RefundWorkflow", "ExchangeWorkflow", "CreateUserWorkflow",
+	"WithdrawWorkflow", "DepositWorkflow"}
+
 type userService interface {
 	CreateUserRecord(ctx context.Context, userID int64, phone, email string) error
 	DeleteUserRecordIfPresentByUserID(ctx context.Context, userID int64) error
@@ -26,7 +34,17 @@ type userService interface {
 		transactionType models.TransactionType,
 		fromUserID,
 		toUserID int64,
-		amount int64,
+		money models.Money,
+		fee models.Fee,
+	) error
+	DecreaseHeldMoney(
+		ctx context.Context,
+		transactionID string,
+		holdID string,
+		fromUserID,
+		toUserID int64,
+		money models.Money,
+		fee models.Fee,
 	) error
 	IncreaseMoneyToUser(
 		ctx context.Context,
@@ -34,8 +52,60 @@ type userService interface {
 		transactionType models.TransactionType,
 		fromUserID,
 		toUserID int64,
-		amount int64,
+		money models.Money,
+	) error
+	DecreaseRefund(
+		ctx context.Context,
+		refundID,
+		transferID string,
+		recipientID,
+		senderID int64,
+		money models.Money,
 	) error
+	GetRefundStatus(ctx context.Context, refundID string, recipientID int64) (models.TransactionStatus, error)
+	IncreaseSplitMoney(ctx context.Context, transactionID string, fromUserID, toUserID int64, money models.Money) error
+	ReverseSplitMoney(ctx context.Context, transactionID string, recipientID, senderID int64, money models.Money) error
+	GetTransfer(ctx context.Context, transferID string) (*models.Transfer, error)
+	CalculateTransferFee(fromUserID, toUserID int64, money models.Money) models.Fee
+	AcceptQuote(ctx context.Context, quoteID string, userID int64) (*models.FXQuote, error)
+	CompensateQuote(ctx context.Context, quoteID string, userID int64) error
+	FXTreasuryAccount() int64
+	SystemAccount(account models.SystemAccount) int64
+	ReserveBonus(ctx context.Context, userID int64, phone string) (models.BonusGrant, error)
+	ReleaseBonus(ctx context.Context, userID int64) error
+	HoldFunds(ctx context.Context, holdID string, userID, amount int64, expiresAt time.Time, checkLimits bool) error
+	ExtendHold(ctx context.Context, holdID string, userID int64, expiresAt time.Time) error
+	CreateBatch(ctx context.Context, batchID string, fromUserID int64, items []models.BatchItem) (int64, error)
+	GetBatchItems(ctx context.Context, batchID string, fromUserID int64, position, limit int) ([]models.BatchItem, error)
+	FinishBatchItems(ctx context.Context, batchID string, fromUserID int64, items []models.BatchItem) error
+	CaptureHold(ctx context.Context, holdID string, userID, toUserID int64) error
+	ReleaseHold(ctx context.Context, holdID string, userID int64) error
+	CreatePayment(ctx context.Context, payment models.Payment) error
+	UpdatePayment(
+		ctx context.Context,
+		paymentID string,
+		userID int64,
+		status models.PaymentStatus,
+		providerRef,
+		reason string,
+	) error
+	PaymentCallbackTimeout() time.Duration
+	CreateSchedule(ctx context.Context, schedule models.Schedule) error
+	UpdateSchedule(ctx context.Context, schedule models.Schedule) error
+	GetSchedule(ctx context.Context, scheduleID string, userID int64) (*models.Schedule, error)
+	ChargeCreditInterest(ctx context.Context, shardID int, date time.Time, afterID int64, limit int) (int64, error)
+	CreditInterestIncome(ctx context.Context, shardID int, date time.Time) error
+	AccrueDepositInterest(ctx context.Context, shardID int, date time.Time, afterID int64, limit int) (int64, error)
+	DebitInterestExpense(ctx context.Context, shardID int, date time.Time) error
+	RecordTransferFailed(ctx context.Context, event models.TransferFailedEvent) error
+	ListWebhooks(ctx context.Context) ([]models.Webhook, error)
+	GetWebhook(ctx context.Context, webhookID string) (*models.Webhook, error)
+	CreateWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) (*models.WebhookDelivery, error)
+	GetWebhookDelivery(ctx context.Context, deliveryID string) (*models.WebhookDelivery, error)
+	RecordWebhookAttempt(ctx context.Context, deliveryID string, responseCode int, lastError string) error
+	SetWebhookDeliveryStatus(ctx context.Context, deliveryID string, status models.WebhookDeliveryStatus) error
+	WebhookTimeout() time.Duration
+	WebhookMaxAttempts() int
 	GetShardManager() *shard.ShardManager
 }
 
@@ -45,39 +115,123 @@ type userSagaService interface {
 	DeleteEmailRecordIfPresentByUserID(ctx context.Context, email string) error
 	CreateEmailRecord(ctx context.Context, userID int64, email string) error
 	CreateUserWorkflow(ctx workflow.Context, userID int64, phone, email string) (res int64, err error)
+	ReserveBonus(ctx context.Context, userID int64, phone string) (models.BonusGrant, error)
+	ReleaseBonus(ctx context.Context, userID int64) error
 	GetShardManager() *shard.ShardManager
 	TransferMoneyWorkflow(ctx workflow.Context, params TransferMoneyParams) error
 	DecreaseMoney(ctx context.Context, params TransferMoneyParams) error
 	CompensateMoney(ctx context.Context, params TransferMoneyParams) error
 	IncreaseMoney(ctx context.Context, params TransferMoneyParams) error
+	CalculateFee(ctx context.Context, params TransferMoneyParams) (models.Fee, error)
+	CreditFee(ctx context.Context, params TransferMoneyParams) error
+	ReverseFee(ctx context.Context, params TransferMoneyParams) error
+	CreditSplitPart(ctx context.Context, params TransferMoneyParams, part int) error
+	ReverseSplitPart(ctx context.Context, params TransferMoneyParams, part int) error
+	FailTransfer(ctx context.Context, params TransferMoneyParams, reason string) error
+	HoldWorkflow(ctx workflow.Context, params HoldParams) (models.HoldStatus, error)
+	CaptureHeldMoney(ctx context.Context, params CaptureHoldParams) error
+	ReleaseHeldMoney(ctx context.Context, params HoldParams) error
+	ExchangeWorkflow(ctx workflow.Context, params ExchangeParams) error
+	AcceptQuote(ctx context.Context, params ExchangeParams) (ExchangeParams, error)
+	DebitExchange(ctx context.Context, params ExchangeParams) error
+	CreditSpread(ctx context.Context, params ExchangeParams) error
+	CreditExchange(ctx context.Context, params ExchangeParams) error
+	ReverseSpread(ctx context.Context, params ExchangeParams) error
+	CompensateExchange(ctx context.Context, params ExchangeParams) error
+	CompensateQuote(ctx context.Context, params ExchangeParams) error
+	DepositWorkflow(ctx workflow.Context, params PaymentParams) (models.PaymentStatus, error)
+	WithdrawWorkflow(ctx workflow.Context, params PaymentParams) (models.PaymentStatus, error)
+	CreatePayment(ctx context.Context, params PaymentParams, paymentType models.PaymentType) error
+	FinishPayment(ctx context.Context, params PaymentParams, status models.PaymentStatus, result PaymentResult) error
+	RequestCharge(ctx context.Context, params PaymentParams) (PaymentResult, error)
+	RequestPayout(ctx context.Context, params PaymentParams) (PaymentResult, error)
+	RefundPayment(ctx context.Context, params PaymentParams) error
+	CancelPayment(ctx context.Context, params PaymentParams) error
+	HoldMoney(ctx context.Context, params HoldParams) error
+	ScheduledTransferWorkflow(ctx workflow.Context, schedule models.Schedule) error
+	BatchWorkflow(ctx workflow.Context, cursor BatchCursor) (BatchCursor, error)
+	LoadBatchItems(ctx context.Context, cursor BatchCursor, limit int) ([]models.BatchItem, error)
+	FinishBatchItems(ctx context.Context, cursor BatchCursor, items []models.BatchItem) error
+	ExtendHeldMoney(ctx context.Context, params HoldParams) error
+	UpdateSchedule(ctx context.Context, schedule models.Schedule) error
+	RefundWorkflow(ctx workflow.Context, params RefundParams) error
+	DebitRefund(ctx context.Context, params RefundParams) error
+	CreditRefund(ctx context.Context, params RefundParams) error
+	CompensateRefund(ctx context.Context, params RefundParams) error
+	EscrowWorkflow(ctx workflow.Context, params EscrowParams) (models.EscrowStatus, error)
+	InterestWorkflow(ctx workflow.Context, params InterestParams) error
+	ListUserShards(ctx context.Context) ([]int, error)
+	PostInterestPage(ctx context.Context, kind InterestKind, shardID int, date time.Time, after int64) (int64, error)
+	SettleInterest(ctx context.Context, kind InterestKind, shardID int, date time.Time) error
+	WebhookDeliveryWorkflow(ctx workflow.Context, params WebhookDeliveryParams) (models.WebhookDeliveryStatus, error)
+	SendWebhook(ctx context.Context, params WebhookDeliveryParams) error
+	FinishWebhookDelivery(ctx context.Context, params WebhookDeliveryParams, status models.WebhookDeliveryStatus) error
+}
+
+// Worker is a Temporal worker which knows whether it is running, for the readiness checks.
+type Worker struct {
+	worker.Worker
+	name    string
+	running atomic.Bool
+}
+
+// Running is false before the worker has started and after it has stopped or failed.
+func (w *Worker) Running() bool {
+	return w.running.Load()
+}
+
+// Check fails when the worker is not running.
+func (w *Worker) Check(ctx context.Context) error {
+	if !w.Running() {
+		return fmt.Errorf("%s worker is not running", w.name)
+	}
+	return nil
+}
+
+// Stop stops the running worker, stopping it again is a no-op.
+func (w *Worker) Stop() {
+	if w.running.CompareAndSwap(true, false) {
+		w.Worker.Stop()
+	}
 }
 
 // startWorker is a helper function that starts a worker and waits for confirmation
 // that it has started successfully.
-func startWorker(w worker.Worker, workerName string) {
+func startWorker(w worker.Worker, workerName string) *Worker {
+	started := &Worker{Worker: w, name: workerName}
 	workerErrCh := make(chan error, 1)
 	stopChan := make(<-chan interface{}, 1)
 
+	// the flag is cleared only after Run returns, so a worker which fails later is never reported as running
+	started.running.Store(true)
 	go func() {
 		err := w.Run(stopChan)
 		if err != nil {
-			logger.Logger.Error(workerName+" worker error", zap.Error(err))
+			logger.L().Error(workerName+" worker error", zap.Error(err))
 		}
+		started.running.Store(false)
 		workerErrCh <- err
 	}()
 
 	// Wait for confirmation that the worker started
 	select {
 	case err := <-workerErrCh:
-		logger.Logger.Fatal(workerName+" worker failed to start", err)
+		logger.L().Fatal(workerName+" worker failed to start", zap.Error(err))
 	case <-time.After(time.Second): // Give time for worker to start
-		logger.Logger.Info(workerName + " worker started successfully")
+		logger.L().Info(workerName + " worker started successfully")
 	}
+
+	return started
 }
 
-func NewWorker(temporalClient client.Client, service userSagaService) (worker.Worker, worker.Worker) {
+func NewWorker(temporalClient client.Client, service userSagaService) (*Worker, *Worker) {
 	// Create and start user worker
-	userWorker := worker.New(temporalClient, TaskQueue, worker.Options{})
+	// the interceptors count the activity retries and the outcomes of the sagas and tag the logs with the params
+	workerOptions := worker.Options{Interceptors: []interceptor.WorkerInterceptor{
+		metrics.NewWorkerInterceptor(),
+		logger.NewWorkerInterceptor(),
+	}}
+	userWorker := worker.New(temporalClient, TaskQueue, workerOptions)
 
 	// Register user workflow and activities
 	userWorker.RegisterWorkflow(service.CreateUserWorkflow)
@@ -85,21 +239,85 @@ func NewWorker(temporalClient client.Client, service userSagaService) (worker.Wo
 	userWorker.RegisterActivity(service.CreateEmailRecord)
 	userWorker.RegisterActivity(service.DeleteUserRecordIfPresentByUserID)
 	userWorker.RegisterActivity(service.DeleteEmailRecordIfPresentByUserID)
+	userWorker.RegisterActivity(service.ReserveBonus)
+	userWorker.RegisterActivity(service.ReleaseBonus)
 
 	// Start the user worker
-	startWorker(userWorker, "User")
+	startedUserWorker := startWorker(userWorker, "User")
 
 	// Create and start transfer worker
-	transferWorker := worker.New(temporalClient, TransferTaskQueue, worker.Options{})
+	transferWorker := worker.New(temporalClient, TransferTaskQueue, workerOptions)
 
 	// Register transfer workflow and activities
 	transferWorker.RegisterWorkflow(service.TransferMoneyWorkflow)
 	transferWorker.RegisterActivity(service.IncreaseMoney)
 	transferWorker.RegisterActivity(service.DecreaseMoney)
 	transferWorker.RegisterActivity(service.CompensateMoney)
+	transferWorker.RegisterActivity(service.CalculateFee)
+	transferWorker.RegisterActivity(service.CreditFee)
+	transferWorker.RegisterActivity(service.ReverseFee)
+	transferWorker.RegisterActivity(service.CreditSplitPart)
+	transferWorker.RegisterActivity(service.ReverseSplitPart)
+	transferWorker.RegisterActivity(service.FailTransfer)
+
+	// Register hold workflow and activities
+	transferWorker.RegisterWorkflow(service.HoldWorkflow)
+	transferWorker.RegisterActivity(service.CaptureHeldMoney)
+	transferWorker.RegisterActivity(service.ReleaseHeldMoney)
+	transferWorker.RegisterActivity(service.HoldMoney)
+
+	// Register exchange workflow and activities
+	transferWorker.RegisterWorkflow(service.ExchangeWorkflow)
+	transferWorker.RegisterActivity(service.AcceptQuote)
+	transferWorker.RegisterActivity(service.DebitExchange)
+	transferWorker.RegisterActivity(service.CreditSpread)
+	transferWorker.RegisterActivity(service.CreditExchange)
+	transferWorker.RegisterActivity(service.ReverseSpread)
+	transferWorker.RegisterActivity(service.CompensateExchange)
+	transferWorker.RegisterActivity(service.CompensateQuote)
+
+	// Register payment workflows and activities
+	transferWorker.RegisterWorkflow(service.DepositWorkflow)
+	transferWorker.RegisterWorkflow(service.WithdrawWorkflow)
+	transferWorker.RegisterActivity(service.CreatePayment)
+	transferWorker.RegisterActivity(service.FinishPayment)
+	transferWorker.RegisterActivity(service.RequestCharge)
+	transferWorker.RegisterActivity(service.RequestPayout)
+	transferWorker.RegisterActivity(service.RefundPayment)
+	transferWorker.RegisterActivity(service.CancelPayment)
+
+	// Register schedule workflow and activities
+	transferWorker.RegisterWorkflow(service.ScheduledTransferWorkflow)
+	transferWorker.RegisterActivity(service.UpdateSchedule)
+
+	// Register batch workflow and activities
+	transferWorker.RegisterWorkflow(service.BatchWorkflow)
+	transferWorker.RegisterActivity(service.LoadBatchItems)
+	transferWorker.RegisterActivity(service.FinishBatchItems)
+	transferWorker.RegisterActivity(service.ExtendHeldMoney)
+
+	// Register refund workflow and activities
+	transferWorker.RegisterWorkflow(service.RefundWorkflow)
+	transferWorker.RegisterActivity(service.DebitRefund)
+	transferWorker.RegisterActivity(service.CreditRefund)
+	transferWorker.RegisterActivity(service.CompensateRefund)
+
+	// Register escrow workflow
+	transferWorker.RegisterWorkflow(service.EscrowWorkflow)
+
+	// Register interest workflow and activities
+	transferWorker.RegisterWorkflow(service.InterestWorkflow)
+	transferWorker.RegisterActivity(service.ListUserShards)
+	transferWorker.RegisterActivity(service.PostInterestPage)
+	transferWorker.RegisterActivity(service.SettleInterest)
+
+	// Register webhook delivery workflow and activities
+	transferWorker.RegisterWorkflow(service.WebhookDeliveryWorkflow)
+	transferWorker.RegisterActivity(service.SendWebhook)
+	transferWorker.RegisterActivity(service.FinishWebhookDelivery)
 
 	// Start the transfer worker
-	startWorker(transferWorker, "Transfer")
+	startedTransferWorker := startWorker(transferWorker, "Transfer")
 
-	return userWorker, transferWorker
+	return startedUserWorker, startedTransferWorker
 }
@@ -0,0 +1,138 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"maps"
+	"slices"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/id"
+	"usershards/internal/metrics"
+	"usershards/internal/models"
+)
+
+// GetBalances returns the user's balances in every currency, the default currency goes first.
+// In the events mode they are read from the projection of the user's stream.
+func (s *UserService) GetBalances(ctx context.Context, userID int64) ([]models.Money, error) {
+	ctx = metrics.WithMethod(ctx, "GetBalances")
+	if s.storage.Mode == models.StorageModeEvents {
+		state, err := s.GetAccountState(ctx, userID)
+		if err == nil {
+			balances := []models.Money{models.NewMoney(state.Balances[models.DefaultCurrency], models.DefaultCurrency)}
+			for _, currency := range slices.Sorted(maps.Keys(state.Balances)) {
+				if currency != models.DefaultCurrency {
+					balances = append(balances, models.NewMoney(state.Balances[currency], currency))
+				}
+			}
+			return balances, nil
+		}
+		if !errors.Is(err, apperrors.ErrAccountNotFound) {
+			return nil, err
+		}
+	}
+
+	user, err := s.GetUserByID(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, shardID, _ := id.ParseUserID(userID)
+	usersDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return nil, fmt.Errorf("user shard %d not found for id %d", shardID, userID)
+	}
+
+	const query = `SELECT balance, currency FROM accounts WHERE user_id = $1 ORDER BY currency`
+	rows, err := usersDB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select accounts: %w", err)
+	}
+	defer rows.Close()
+
+	balances := []models.Money{models.NewMoney(user.Balance, models.DefaultCurrency)}
+	for rows.Next() {
+		var balance models.Money
+		if err = rows.Scan(&balance.Amount, &balance.Currency); err != nil {
+			return nil, fmt.Errorf("failed to scan account: %w", err)
+		}
+		balances = append(balances, balance)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read accounts: %w", err)
+	}
+
+	return balances, nil
+}
+
+// lockedAccount is the state of the user's account in one currency, locked until the end of transaction.
+type lockedAccount struct {
+	available int64
+	isBlocked bool
+	isSystem  bool
+}
+
+// lockAccount locks the user and the user's account in the currency. The user's row is always locked first,
+// so money operations in different currencies of the same user are serialized too.
+func lockAccount(ctx context.Context, tx pgx.Tx, userID int64, currency models.Currency) (lockedAccount, error) {
+	account := lockedAccount{}
+
+	// money on hold is reserved for captures and can't be spent, the credit line in the default currency can
+	const selectUser = `SELECT balance - held_balance + credit_limit, is_blocked, is_system FROM users
+						WHERE id = $1 FOR UPDATE`
+	err := tx.QueryRow(ctx, selectUser, userID).Scan(&account.available, &account.isBlocked, &account.isSystem)
+	if err != nil {
+		return account, fmt.Errorf("failed to select user: %w", err)
+	}
+	if currency == models.DefaultCurrency {
+		return account, nil
+	}
+
+	const selectAccount = `SELECT balance FROM accounts WHERE user_id = $1 AND currency = $2 FOR UPDATE`
+	err = tx.QueryRow(ctx, selectAccount, userID, currency).Scan(&account.available)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			// no account yet, nothing to spend
+			account.available = 0
+			return account, nil
+		}
+		return account, fmt.Errorf("failed to select account: %w", err)
+	}
+
+	return account, nil
+}
+
+// changeBalance adds delta to the user's balance in the currency and returns the new balance.
+// The account in a non default currency is opened on the first credit.
+func changeBalance(
+	ctx context.Context,
+	tx pgx.Tx,
+	userID int64,
+	currency models.Currency,
+	delta int64,
+	now time.Time,
+) (int64, error) {
+	var balanceAfter int64
+	if currency == models.DefaultCurrency {
+		const changeUserBalance = `UPDATE users SET balance = balance + $1 WHERE id = $2 RETURNING balance`
+		err := tx.QueryRow(ctx, changeUserBalance, delta, userID).Scan(&balanceAfter)
+		if err != nil {
+			return 0, fmt.Errorf("failed to update balance: %w", err)
+		}
+		return balanceAfter, nil
+	}
+
+	const changeAccountBalance = `INSERT INTO accounts (user_id, currency, balance, created_at, updated_at)
+								  VALUES ($1, $2, $3, $4, $4)
+								  ON CONFLICT (user_id, currency) DO UPDATE
+								  SET balance = accounts.balance + EXCLUDED.balance, updated_at = EXCLUDED.updated_at
+								  RETURNING balance`
+	err := tx.QueryRow(ctx, changeAccountBalance, userID, currency, delta, now).Scan(&balanceAfter)
+	if err != nil {
+		return 0, fmt.Errorf("failed to update account balance: %w", err)
+	}
+
+	return balanceAfter, nil
+}
@@ -0,0 +1,235 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/id"
+	"usershards/internal/metrics"
+	"usershards/internal/models"
+	"usershards/internal/shard"
+)
+
+const defaultSnapshotEvery = 100
+
+// GetAccountState returns the account as its event stream left it, starting with the latest snapshot.
+func (s *UserService) GetAccountState(ctx context.Context, userID int64) (*models.AccountState, error) {
+	ctx = metrics.WithMethod(ctx, "GetAccountState")
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return nil, fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	var state *models.AccountState
+	err := shard.WithTransaction(ctx, userDB, func(tx pgx.Tx) error {
+		var err error
+		state, err = loadAccountState(ctx, tx, userID, true)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// RebuildAccountState replays the whole event stream of the account, ignoring the snapshots,
+// and saves the result as the latest snapshot.
+func (s *UserService) RebuildAccountState(ctx context.Context, userID int64) (*models.AccountState, error) {
+	ctx = metrics.WithMethod(ctx, "RebuildAccountState")
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return nil, fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	var state *models.AccountState
+	err := shard.WithTransaction(ctx, userDB, func(tx pgx.Tx) error {
+		var err error
+		state, err = loadAccountState(ctx, tx, userID, false)
+		if err != nil {
+			return err
+		}
+		return saveAccountSnapshot(ctx, tx, state, time.Now().UTC())
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return state, nil
+}
+
+// ListAccountEvents returns the events of the account after the version in the order they happened.
+func (s *UserService) ListAccountEvents(
+	ctx context.Context,
+	userID int64,
+	afterVersion int64,
+	limit int,
+) ([]models.AccountEvent, error) {
+	ctx = metrics.WithMethod(ctx, "ListAccountEvents")
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return nil, fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	if limit <= 0 {
+		limit = defaultTransactionsLimit
+	}
+
+	const query = `SELECT ` + accountEventColumns + ` FROM account_events WHERE user_id = $1 AND version > $2
+				   ORDER BY version LIMIT $3`
+	rows, err := userDB.Query(ctx, query, userID, afterVersion, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select account events: %w", err)
+	}
+	events, err := pgx.CollectRows(rows, scanAccountEvent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read account events: %w", err)
+	}
+
+	return events, nil
+}
+
+// appendAccountEvent adds the event to the user's stream in the transaction which makes the change, the user's
+// row is locked by then, so the versions don't race. A stream starts with the opened event: the accounts opened
+// before the events mode was on have no stream and stay served by the rows.
+func (s *UserService) appendAccountEvent(ctx context.Context, tx pgx.Tx, event models.AccountEvent) error {
+	if s.storage.Mode != models.StorageModeEvents {
+		return nil
+	}
+
+	var version int64
+	const selectVersion = `SELECT COALESCE(MAX(version), 0) FROM account_events WHERE user_id = $1`
+	err := tx.QueryRow(ctx, selectVersion, event.UserID).Scan(&version)
+	if err != nil {
+		return fmt.Errorf("failed to select account version: %w", err)
+	}
+	if version == 0 && event.Type != models.AccountEventTypeOpened {
+		return nil
+	}
+	event.Version = version + 1
+
+	const insertEvent = `INSERT INTO account_events
+						 (user_id, version, type, transfer_id, transaction_type, amount, currency, created_at)
+						 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`
+	_, err = tx.Exec(ctx, insertEvent, event.UserID, event.Version, event.Type, event.TransferID,
+		event.TransactionType, event.Amount, event.Currency, event.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("failed to insert account event: %w", err)
+	}
+
+	snapshotEvery := s.storage.SnapshotEvery
+	if snapshotEvery <= 0 {
+		snapshotEvery = defaultSnapshotEvery
+	}
+	if event.Version%snapshotEvery != 0 {
+		return nil
+	}
+
+	state, err := loadAccountState(ctx, tx, event.UserID, true)
+	if err != nil {
+		return err
+	}
+	return saveAccountSnapshot(ctx, tx, state, event.CreatedAt)
+}
+
+// appendBalanceEvent tells the stream of the user whose balance the history entry has changed.
+func (s *UserService) appendBalanceEvent(
+	ctx context.Context,
+	tx pgx.Tx,
+	transferID string,
+	transactionType models.TransactionType,
+	fromUserID,
+	toUserID int64,
+	money models.Money,
+	now time.Time,
+) error {
+	event := models.AccountEvent{
+		UserID:          toUserID,
+		Type:            models.AccountEventTypeCredited,
+		TransferID:      transferID,
+		TransactionType: transactionType,
+		Amount:          money.Amount,
+		Currency:        money.Currency,
+		CreatedAt:       now,
+	}
+	if isOutgoing(transactionType) {
+		event.UserID = fromUserID
+		event.Type = models.AccountEventTypeDebited
+	}
+
+	return s.appendAccountEvent(ctx, tx, event)
+}
+
+// loadAccountState applies the events to the latest snapshot, or to an empty account without useSnapshot.
+func loadAccountState(ctx context.Context, tx pgx.Tx, userID int64, useSnapshot bool) (*models.AccountState, error) {
+	state := models.NewAccountState(userID)
+	if useSnapshot {
+		var data []byte
+		const selectSnapshot = `SELECT state FROM account_snapshots WHERE user_id = $1`
+		err := tx.QueryRow(ctx, selectSnapshot, userID).Scan(&data)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return nil, fmt.Errorf("failed to select account snapshot: %w", err)
+		}
+		if err == nil {
+			if err = json.Unmarshal(data, state); err != nil {
+				return nil, fmt.Errorf("failed to unmarshal account snapshot: %w", err)
+			}
+		}
+	}
+
+	const selectEvents = `SELECT ` + accountEventColumns + ` FROM account_events WHERE user_id = $1 AND version > $2
+						  ORDER BY version`
+	rows, err := tx.Query(ctx, selectEvents, userID, state.Version)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select account events: %w", err)
+	}
+	events, err := pgx.CollectRows(rows, scanAccountEvent)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read account events: %w", err)
+	}
+	for _, event := range events {
+		state.Apply(event)
+	}
+
+	if state.Version == 0 {
+		return nil, apperrors.ErrAccountNotFound
+	}
+	return state, nil
+}
+
+func saveAccountSnapshot(ctx context.Context, tx pgx.Tx, state *models.AccountState, now time.Time) error {
+	data, err := json.Marshal(state)
+	if err != nil {
+		return fmt.Errorf("failed to marshal account snapshot: %w", err)
+	}
+
+	const query = `INSERT INTO account_snapshots (user_id, version, state, created_at) VALUES ($1, $2, $3, $4)
+				   ON CONFLICT (user_id) DO UPDATE
+				   SET version = EXCLUDED.version, state = EXCLUDED.state, created_at = EXCLUDED.created_at`
+	_, err = tx.Exec(ctx, query, state.UserID, state.Version, data, now)
+	if err != nil {
+		return fmt.Errorf("failed to save account snapshot: %w", err)
+	}
+
+	return nil
+}
+
+const accountEventColumns = `user_id, version, type, COALESCE(transfer_id, ''), COALESCE(transaction_type, ''),
+							 amount, COALESCE(currency, ''), created_at`
+
+func scanAccountEvent(row pgx.CollectableRow) (models.AccountEvent, error) {
+	var event models.AccountEvent
+	err := row.Scan(&event.UserID, &event.Version, &event.Type, &event.TransferID, &event.TransactionType,
+		&event.Amount, &event.Currency, &event.CreatedAt)
+	if err != nil {
+		return event, fmt.Errorf("failed to scan account event: %w", err)
+	}
+	return event, nil
+}
@@ -0,0 +1,118 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+	"usershards/internal/id"
+	"usershards/internal/metrics"
+	"usershards/internal/models"
+	"usershards/internal/shard"
+)
+
+const batchItemColumns = `position, to_id, amount, fee, transaction_id, status, error`
+
+// CreateBatch saves the items of the batch on the sender's shard with their fees and returns the total
+// to reserve on the sender, the fees included. The batch workflow reads the items by parts.
+func (s *UserService) CreateBatch(ctx context.Context, batchID string, fromUserID int64, items []models.BatchItem) (int64, error) {
+	ctx = metrics.WithMethod(ctx, "CreateBatch")
+	_, shardID, _ := id.ParseUserID(fromUserID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return 0, fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	var total int64
+	now := time.Now().UTC()
+	batch := &pgx.Batch{}
+	const query = `INSERT INTO batch_items (batch_id, position, to_id, amount, fee, transaction_id, status, error,
+				   created_at, updated_at)
+				   VALUES ($1, $2, $3, $4, $5, $6, $7, '', $8, $8)`
+	for i, item := range items {
+		fee := s.CalculateTransferFee(fromUserID, item.To, models.NewMoney(item.Amount, models.DefaultCurrency))
+		total += item.Amount + fee.Amount
+		batch.Queue(query, batchID, i, item.To, item.Amount, fee.Amount, item.TransactionID,
+			models.BatchItemStatusPending, now)
+	}
+
+	err := shard.WithTransaction(ctx, userDB, func(tx pgx.Tx) error {
+		return tx.SendBatch(ctx, batch).Close()
+	})
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert batch items: %w", err)
+	}
+
+	return total, nil
+}
+
+// GetBatchItems returns at most limit items of the batch from the position on, in their order.
+// A limit of 0 returns all the items left.
+func (s *UserService) GetBatchItems(
+	ctx context.Context,
+	batchID string,
+	fromUserID int64,
+	position,
+	limit int,
+) ([]models.BatchItem, error) {
+	ctx = metrics.WithMethod(ctx, "GetBatchItems")
+	_, shardID, _ := id.ParseUserID(fromUserID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return nil, fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	query := `SELECT ` + batchItemColumns + ` FROM batch_items
+			  WHERE batch_id = $1 AND position >= $2
+			  ORDER BY position`
+	args := []any{batchID, position}
+	if limit > 0 {
+		query += ` LIMIT $3`
+		args = append(args, limit)
+	}
+
+	rows, err := userDB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select batch items: %w", err)
+	}
+	defer rows.Close()
+
+	var items []models.BatchItem
+	for rows.Next() {
+		item := models.BatchItem{}
+		err = rows.Scan(&item.Position, &item.To, &item.Amount, &item.Fee, &item.TransactionID, &item.Status,
+			&item.Error)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan batch item: %w", err)
+		}
+		items = append(items, item)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read batch items: %w", err)
+	}
+
+	return items, nil
+}
+
+// FinishBatchItems saves the results of the processed items of the batch.
+func (s *UserService) FinishBatchItems(ctx context.Context, batchID string, fromUserID int64, items []models.BatchItem) error {
+	ctx = metrics.WithMethod(ctx, "FinishBatchItems")
+	_, shardID, _ := id.ParseUserID(fromUserID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	now := time.Now().UTC()
+	return shard.WithTransaction(ctx, userDB, func(tx pgx.Tx) error {
+		const query = `UPDATE batch_items SET status = $1, error = $2, updated_at = $3
+					   WHERE batch_id = $4 AND position = $5`
+		for _, item := range items {
+			_, err := tx.Exec(ctx, query, item.Status, item.Error, now, batchID, item.Position)
+			if err != nil {
+				return fmt.Errorf("failed to update batch item: %w", err)
+			}
+		}
+		return nil
+	})
+}
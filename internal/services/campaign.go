@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"time"
+	"usershards/internal/id"
+	"usershards/internal/metrics"
+	"usershards/internal/models"
+	"usershards/internal/shard"
+)
+
+// ReserveBonus picks the first campaign the new user matches and takes the bonus from its budget.
+// The user gets at most one bonus, calling it again returns the same grant. Zero amount means no bonus.
+func (s *UserService) ReserveBonus(ctx context.Context, userID int64, phone string) (models.BonusGrant, error) {
+	ctx = metrics.WithMethod(ctx, "ReserveBonus")
+	grant := models.BonusGrant{UserID: userID}
+
+	// budgets live with the bonus pool, so all workers reserve them on the same shard
+	bonusDB, err := s.bonusPoolDB()
+	if err != nil {
+		return grant, err
+	}
+
+	now := time.Now().UTC()
+	err = shard.WithTransaction(ctx, bonusDB, func(tx pgx.Tx) error {
+		const selectGrant = `SELECT campaign_id, amount, status FROM campaign_grants WHERE user_id = $1`
+		err := tx.QueryRow(ctx, selectGrant, userID).Scan(&grant.CampaignID, &grant.Amount, &grant.Status)
+		if err == nil {
+			return nil
+		}
+		if !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("failed to select bonus grant: %w", err)
+		}
+
+		for _, campaign := range s.campaigns {
+			if !campaign.Matches(phone, now) {
+				continue
+			}
+
+			const insertBudget = `INSERT INTO campaign_budgets (campaign_id, spent, updated_at) VALUES ($1, 0, $2)
+								  ON CONFLICT (campaign_id) DO NOTHING`
+			_, err = tx.Exec(ctx, insertBudget, campaign.ID, now)
+			if err != nil {
+				return fmt.Errorf("failed to insert campaign budget: %w", err)
+			}
+
+			// the row lock serializes concurrent reservations and the budget is rechecked after the lock is taken
+			const spendBudget = `UPDATE campaign_budgets SET spent = spent + $1, updated_at = $2
+								 WHERE campaign_id = $3 AND ($4::bigint = 0 OR spent + $1 <= $4)`
+			rows, err := tx.Exec(ctx, spendBudget, campaign.Amount, now, campaign.ID, campaign.Budget)
+			if err != nil {
+				return fmt.Errorf("failed to spend campaign budget: %w", err)
+			}
+			if rows.RowsAffected() == 0 {
+				continue
+			}
+
+			grant.CampaignID = campaign.ID
+			grant.Amount = campaign.Amount
+			grant.Status = models.BonusGrantStatusGranted
+			const insertGrant = `INSERT INTO campaign_grants (user_id, campaign_id, amount, status, created_at, updated_at)
+								 VALUES ($1, $2, $3, $4, $5, $5)`
+			_, err = tx.Exec(ctx, insertGrant, userID, grant.CampaignID, grant.Amount, grant.Status, now)
+			if err != nil {
+				return fmt.Errorf("failed to insert bonus grant: %w", err)
+			}
+
+			return nil
+		}
+
+		return nil
+	})
+	if err != nil {
+		return grant, err
+	}
+
+	return grant, nil
+}
+
+// ReleaseBonus returns the user's bonus to the campaign budget. Releasing a released bonus is a no-op.
+func (s *UserService) ReleaseBonus(ctx context.Context, userID int64) error {
+	ctx = metrics.WithMethod(ctx, "ReleaseBonus")
+	bonusDB, err := s.bonusPoolDB()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	return shard.WithTransaction(ctx, bonusDB, func(tx pgx.Tx) error {
+		var campaignID string
+		var amount int64
+		const releaseGrant = `UPDATE campaign_grants SET status = $1, updated_at = $2
+							  WHERE user_id = $3 AND status = $4 RETURNING campaign_id, amount`
+		err := tx.QueryRow(ctx, releaseGrant, models.BonusGrantStatusReleased, now, userID,
+			models.BonusGrantStatusGranted).Scan(&campaignID, &amount)
+		if err != nil {
+			if errors.Is(err, pgx.ErrNoRows) {
+				return nil
+			}
+			return fmt.Errorf("failed to release bonus grant: %w", err)
+		}
+
+		const returnBudget = `UPDATE campaign_budgets SET spent = spent - $1, updated_at = $2 WHERE campaign_id = $3`
+		_, err = tx.Exec(ctx, returnBudget, amount, now, campaignID)
+		if err != nil {
+			return fmt.Errorf("failed to return campaign budget: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// GetCampaignSpent returns the total of bonuses reserved from the campaign budget.
+func (s *UserService) GetCampaignSpent(ctx context.Context, campaignID string) (int64, error) {
+	ctx = metrics.WithMethod(ctx, "GetCampaignSpent")
+	bonusDB, err := s.bonusPoolDB()
+	if err != nil {
+		return 0, err
+	}
+
+	var spent int64
+	const query = `SELECT spent FROM campaign_budgets WHERE campaign_id = $1`
+	err = bonusDB.QueryRow(ctx, query, campaignID).Scan(&spent)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("failed to select campaign budget: %w", err)
+	}
+
+	return spent, nil
+}
+
+func (s *UserService) bonusPoolDB() (*pgxpool.Pool, error) {
+	_, shardID, _ := id.ParseUserID(s.SystemAccount(models.SystemAccountBonusPool))
+	bonusDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return nil, fmt.Errorf("user shard %d not found", shardID)
+	}
+	return bonusDB, nil
+}
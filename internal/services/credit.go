@@ -0,0 +1,225 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samber/lo"
+	"time"
+	"usershards/internal/id"
+	"usershards/internal/metrics"
+	"usershards/internal/models"
+	"usershards/internal/shard"
+)
+
+// interestSettlement is the sum of the interest entries posted to the treasury at once.
+type interestSettlement struct {
+	ID     string
+	Amount int64
+}
+
+// SetCreditLimit lets the user's balance in the default currency go below zero down to -limit.
+// Lowering the limit doesn't touch the balance which is already below the new limit.
+func (s *UserService) SetCreditLimit(ctx context.Context, userID, limit int64) error {
+	ctx = metrics.WithMethod(ctx, "SetCreditLimit")
+	if limit < 0 {
+		return fmt.Errorf("credit limit cannot be negative")
+	}
+
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	const query = `UPDATE users SET credit_limit = $1, updated_at = $2 WHERE id = $3 AND NOT is_system`
+	_, err := userDB.Exec(ctx, query, limit, time.Now().UTC(), userID)
+	if err != nil {
+		return fmt.Errorf("failed to set credit limit: %w", err)
+	}
+
+	return nil
+}
+
+// ChargeCreditInterest charges the day's interest to up to limit users of the shard with a credit line and
+// negative balance, starting after the user afterID. It returns the last user it has looked at, 0 when the shard is done.
+// Every user is charged at most once a day, the charges go to the treasury by CreditInterestIncome.
+func (s *UserService) ChargeCreditInterest(
+	ctx context.Context,
+	shardID int,
+	date time.Time,
+	afterID int64,
+	limit int,
+) (int64, error) {
+	ctx = metrics.WithMethod(ctx, "ChargeCreditInterest")
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return 0, fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	const selectUsers = `SELECT id FROM users WHERE balance < 0 AND credit_limit > 0 AND NOT is_system AND id > $1 ORDER BY id LIMIT $2`
+	rows, err := userDB.Query(ctx, selectUsers, afterID, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select users: %w", err)
+	}
+	userIDs, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return 0, fmt.Errorf("failed to read users: %w", err)
+	}
+
+	for _, userID := range userIDs {
+		err = s.chargeUserInterest(ctx, userDB, shardID, userID, date)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if len(userIDs) < limit {
+		return 0, nil
+	}
+	return userIDs[len(userIDs)-1], nil
+}
+
+func (s *UserService) chargeUserInterest(
+	ctx context.Context,
+	userDB *pgxpool.Pool,
+	shardID int,
+	userID int64,
+	date time.Time,
+) error {
+	treasury := s.SystemAccount(models.SystemAccountTreasury)
+	transferID := interestTransferID("credit-interest", shardID, date)
+
+	now := time.Now().UTC()
+	return shard.WithTransaction(ctx, userDB, func(tx pgx.Tx) error {
+		// one charge per user and day
+		const query = `INSERT INTO idempotence (id, type, created_at) VALUES ($1, $2, $3)`
+		_, err := tx.Exec(ctx, query, dailyIdempotenceID(userID, date), models.TransactionTypeInterestCharge, now)
+		if err != nil {
+			if pgErr, ok := lo.ErrorsAs[*pgconn.PgError](err); ok {
+				if pgErr.Code == pgerrcode.UniqueViolation {
+					return nil
+				}
+			}
+			return fmt.Errorf("failed to insert idempotetency: %w", err)
+		}
+
+		var balance int64
+		const selectUser = `SELECT balance FROM users WHERE id = $1 FOR UPDATE`
+		err = tx.QueryRow(ctx, selectUser, userID).Scan(&balance)
+		if err != nil {
+			return fmt.Errorf("failed to select user: %w", err)
+		}
+
+		interest := dailyInterest(-balance, s.credit.InterestBasisPoints)
+		if interest <= 0 {
+			return nil
+		}
+
+		// the interest is charged on the whole debt of the credit line, even beyond its limit
+		money := models.NewMoney(interest, models.DefaultCurrency)
+		balanceAfter, err := changeBalance(ctx, tx, userID, money.Currency, -money.Amount, now)
+		if err != nil {
+			return err
+		}
+
+		return s.insertTransaction(ctx, tx, transferID, models.TransactionTypeInterestCharge, userID, treasury, money,
+			balanceAfter, now)
+	})
+}
+
+// CreditInterestIncome credits the treasury with the interest charged on the shard for the day.
+// It settles the charges made since the last call, so it is called again after every run of the charges.
+func (s *UserService) CreditInterestIncome(ctx context.Context, shardID int, date time.Time) error {
+	ctx = metrics.WithMethod(ctx, "CreditInterestIncome")
+	transferID := interestTransferID("credit-interest", shardID, date)
+	return s.settleInterest(ctx, shardID, transferID, models.TransactionTypeInterestCharge,
+		func(ctx context.Context, settlementID string, money models.Money) error {
+			return s.increaseMoney(ctx, settlementID, transferID, models.TransactionTypeInterestIncome, 0,
+				s.SystemAccount(models.SystemAccountTreasury), money)
+		})
+}
+
+// settleInterest sums the shard's unsettled interest entries of the transfer and marks them with a new
+// settlement in one transaction, then posts every pending settlement of the transfer to the treasury under
+// its own idempotency key. The entries added after a settlement go to the next one.
+func (s *UserService) settleInterest(
+	ctx context.Context,
+	shardID int,
+	transferID string,
+	entryType models.TransactionType,
+	post func(ctx context.Context, settlementID string, money models.Money) error,
+) error {
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	settlementID, err := uuid.NewV7()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC()
+	err = shard.WithTransaction(ctx, userDB, func(tx pgx.Tx) error {
+		const markEntries = `UPDATE transaction SET settlement_id = $1
+							 WHERE transfer_id = $2 AND type = $3 AND settlement_id IS NULL
+							 RETURNING amount`
+		rows, err := tx.Query(ctx, markEntries, settlementID, transferID, entryType)
+		if err != nil {
+			return fmt.Errorf("failed to mark interest entries: %w", err)
+		}
+		amounts, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+		if err != nil {
+			return fmt.Errorf("failed to read interest entries: %w", err)
+		}
+
+		total := lo.Sum(amounts)
+		if total == 0 {
+			return nil
+		}
+
+		const insertSettlement = `INSERT INTO settlements (id, transfer_id, type, amount, posted, created_at)
+								  VALUES ($1, $2, $3, $4, false, $5)`
+		_, err = tx.Exec(ctx, insertSettlement, settlementID, transferID, entryType, total, now)
+		if err != nil {
+			return fmt.Errorf("failed to insert settlement: %w", err)
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// the settlements of the failed calls are posted too
+	const selectPending = `SELECT id::text, amount FROM settlements
+						   WHERE transfer_id = $1 AND type = $2 AND NOT posted ORDER BY created_at`
+	rows, err := userDB.Query(ctx, selectPending, transferID, entryType)
+	if err != nil {
+		return fmt.Errorf("failed to select settlements: %w", err)
+	}
+	settlements, err := pgx.CollectRows(rows, pgx.RowToStructByPos[interestSettlement])
+	if err != nil {
+		return fmt.Errorf("failed to read settlements: %w", err)
+	}
+
+	for _, settlement := range settlements {
+		err = post(ctx, settlement.ID, models.NewMoney(settlement.Amount, models.DefaultCurrency))
+		if err != nil {
+			return err
+		}
+
+		const markPosted = `UPDATE settlements SET posted = true WHERE id = $1`
+		_, err = userDB.Exec(ctx, markPosted, settlement.ID)
+		if err != nil {
+			return fmt.Errorf("failed to update settlement: %w", err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,185 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/id"
+	"usershards/internal/metrics"
+	"usershards/internal/models"
+	"usershards/internal/shard"
+)
+
+const defaultQuoteTTL = 30 * time.Second
+
+// QuoteFX locks the rate for exchanging source into the target currency. The user's rate is the mid rate
+// lowered by the configured spread. The quote has to be accepted by the exchange workflow before it expires.
+func (s *UserService) QuoteFX(
+	ctx context.Context,
+	userID int64,
+	source models.Money,
+	to models.Currency,
+) (*models.FXQuote, error) {
+	ctx = metrics.WithMethod(ctx, "QuoteFX")
+	if s.rates == nil {
+		return nil, fmt.Errorf("currency exchange is not configured")
+	}
+	if !source.Currency.Valid() || !to.Valid() || source.Currency == to {
+		return nil, apperrors.ErrInvalidCurrency
+	}
+	if source.Amount <= 0 {
+		return nil, fmt.Errorf("amount must be positive")
+	}
+
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return nil, fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	midRate, err := s.rates.MidRate(ctx, source.Currency, to)
+	if err != nil {
+		return nil, err
+	}
+
+	quoteID, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := s.fx.QuoteTTL
+	if ttl <= 0 {
+		ttl = defaultQuoteTTL
+	}
+
+	now := time.Now().UTC()
+	rate, err := midRate.ApplySpread(s.fx.SpreadBasisPoints)
+	if err != nil {
+		return nil, err
+	}
+	target, err := rate.Convert(source.Amount)
+	if err != nil {
+		return nil, err
+	}
+	mid, err := midRate.Convert(source.Amount)
+	if err != nil {
+		return nil, err
+	}
+	quote := &models.FXQuote{
+		ID:        quoteID.String(),
+		UserID:    userID,
+		Source:    source,
+		Target:    models.NewMoney(target, to),
+		Spread:    models.NewMoney(mid-target, to),
+		Rate:      rate,
+		MidRate:   midRate,
+		Status:    models.FXQuoteStatusOpen,
+		ExpiresAt: now.Add(ttl),
+		CreatedAt: now,
+	}
+	if quote.Target.Amount <= 0 {
+		return nil, fmt.Errorf("amount is too small to exchange")
+	}
+
+	const query = `INSERT INTO fx_quotes (id, user_id, from_currency, to_currency, source_amount, target_amount,
+				   spread_amount, rate, mid_rate, status, expires_at, created_at)
+				   VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`
+	_, err = userDB.Exec(ctx, query, quote.ID, userID, source.Currency, to, source.Amount, quote.Target.Amount,
+		quote.Spread.Amount, quote.Rate, quote.MidRate, quote.Status, quote.ExpiresAt, quote.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert quote: %w", err)
+	}
+
+	return quote, nil
+}
+
+// AcceptQuote marks the quote as used, so it can't be exchanged twice. Accepting an accepted quote is a no-op
+// for the retries of the exchange, an expired quote and the quote of a compensated exchange are rejected.
+func (s *UserService) AcceptQuote(ctx context.Context, quoteID string, userID int64) (*models.FXQuote, error) {
+	ctx = metrics.WithMethod(ctx, "AcceptQuote")
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return nil, fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	var quote *models.FXQuote
+	now := time.Now().UTC()
+	err := shard.WithTransaction(ctx, userDB, func(tx pgx.Tx) error {
+		const query = `SELECT id, user_id, from_currency, to_currency, source_amount, target_amount, spread_amount,
+					   rate, mid_rate, status, expires_at, created_at
+					   FROM fx_quotes WHERE id = $1 AND user_id = $2 FOR UPDATE`
+		var err error
+		quote, err = scanQuote(tx.QueryRow(ctx, query, quoteID, userID))
+		if err != nil {
+			return err
+		}
+
+		if quote.Status == models.FXQuoteStatusAccepted {
+			return nil
+		}
+		if quote.Status == models.FXQuoteStatusCompensated {
+			return apperrors.ErrQuoteUsed
+		}
+		if quote.ExpiresAt.Before(now) {
+			return apperrors.ErrQuoteExpired
+		}
+
+		const accept = `UPDATE fx_quotes SET status = $1 WHERE id = $2`
+		_, err = tx.Exec(ctx, accept, models.FXQuoteStatusAccepted, quoteID)
+		if err != nil {
+			return fmt.Errorf("failed to accept quote: %w", err)
+		}
+		quote.Status = models.FXQuoteStatusAccepted
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return quote, nil
+}
+
+// CompensateQuote marks the quote of the compensated exchange, so the exchange isn't repeated by it.
+func (s *UserService) CompensateQuote(ctx context.Context, quoteID string, userID int64) error {
+	ctx = metrics.WithMethod(ctx, "CompensateQuote")
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	const query = `UPDATE fx_quotes SET status = $1 WHERE id = $2 AND user_id = $3 AND status = $4`
+	_, err := userDB.Exec(ctx, query, models.FXQuoteStatusCompensated, quoteID, userID, models.FXQuoteStatusAccepted)
+	if err != nil {
+		return fmt.Errorf("failed to compensate quote: %w", err)
+	}
+
+	return nil
+}
+
+// FXTreasuryAccount is the account which takes the other side of currency exchanges.
+func (s *UserService) FXTreasuryAccount() int64 {
+	return s.fx.TreasuryAccount
+}
+
+func scanQuote(row pgx.Row) (*models.FXQuote, error) {
+	quote := models.FXQuote{}
+	err := row.Scan(&quote.ID, &quote.UserID, &quote.Source.Currency, &quote.Target.Currency, &quote.Source.Amount,
+		&quote.Target.Amount, &quote.Spread.Amount, &quote.Rate, &quote.MidRate, &quote.Status, &quote.ExpiresAt,
+		&quote.CreatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperrors.ErrQuoteNotFound
+		}
+		return nil, fmt.Errorf("failed to select quote: %w", err)
+	}
+	quote.Spread.Currency = quote.Target.Currency
+
+	return &quote, nil
+}
@@ -0,0 +1,318 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/samber/lo"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/id"
+	"usershards/internal/metrics"
+	"usershards/internal/models"
+	"usershards/internal/shard"
+)
+
+// HoldFunds reserves amount on the user's balance in the default currency. The hold is checked against
+// the transfer limits as the transfer it is going to pay, unless checkLimits is false for the holds whose
+// transfers are checked one by one. Calling it again with the same holdID is a no-op.
+func (s *UserService) HoldFunds(
+	ctx context.Context,
+	holdID string,
+	userID int64,
+	amount int64,
+	expiresAt time.Time,
+	checkLimits bool,
+) error {
+	ctx = metrics.WithMethod(ctx, "HoldFunds")
+	if amount <= 0 {
+		return fmt.Errorf("hold amount must be positive")
+	}
+
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	now := time.Now().UTC()
+	return shard.WithTransaction(ctx, userDB, func(tx pgx.Tx) error {
+		var availableBalance int64
+		var isBlocked bool
+		const selectUser = `SELECT balance - held_balance + credit_limit, is_blocked FROM users WHERE id = $1 FOR UPDATE`
+		err := tx.QueryRow(ctx, selectUser, userID).Scan(&availableBalance, &isBlocked)
+		if err != nil {
+			return fmt.Errorf("failed to select user: %w", err)
+		}
+
+		// hold id works as an idempotency key
+		const insertHold = `INSERT INTO holds (id, user_id, amount, status, expires_at, created_at, updated_at)
+							VALUES ($1, $2, $3, $4, $5, $6, $7)
+							ON CONFLICT (id) DO NOTHING`
+		rows, err := tx.Exec(ctx, insertHold, holdID, userID, amount, models.HoldStatusActive,
+			expiresAt.UTC(), now, now)
+		if err != nil {
+			return fmt.Errorf("failed to insert hold: %w", err)
+		}
+		if rows.RowsAffected() == 0 {
+			return nil
+		}
+
+		if isBlocked {
+			return apperrors.ErrUserIsBlocked
+		}
+
+		if availableBalance < amount {
+			return apperrors.ErrInsufficientFunds
+		}
+
+		// the capture is not checked, so the held money counts against the limits from now on
+		if checkLimits {
+			err = s.checkTransferLimits(ctx, tx, userID, models.NewMoney(amount, models.DefaultCurrency), holdID, now)
+			if err != nil {
+				return err
+			}
+		}
+
+		const holdMoney = `UPDATE users SET held_balance = held_balance + $1, updated_at = $2 WHERE id = $3`
+		_, err = tx.Exec(ctx, holdMoney, amount, now, userID)
+		if err != nil {
+			return fmt.Errorf("failed to update held balance: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// CaptureHold debits the held money from the user in favour of toUserID.
+// The debit is recorded in history under the hold id, so the recipient must be credited with the same transfer id.
+func (s *UserService) CaptureHold(ctx context.Context, holdID string, userID, toUserID int64) error {
+	ctx = metrics.WithMethod(ctx, "CaptureHold")
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	now := time.Now().UTC()
+	return shard.WithTransaction(ctx, userDB, func(tx pgx.Tx) error {
+		hold, err := selectHoldForUpdate(ctx, tx, holdID, userID)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case hold.Status == models.HoldStatusCaptured:
+			return nil
+		case hold.Status != models.HoldStatusActive:
+			return apperrors.ErrHoldNotActive
+		case hold.ExpiresAt.Before(now):
+			return apperrors.ErrHoldNotActive
+		}
+
+		var balanceAfter int64
+		const captureMoney = `UPDATE users SET balance = balance - $1, held_balance = held_balance - $1, updated_at = $2
+							  WHERE id = $3 RETURNING balance`
+		err = tx.QueryRow(ctx, captureMoney, hold.Remaining(), now, userID).Scan(&balanceAfter)
+		if err != nil {
+			return fmt.Errorf("failed to update balance: %w", err)
+		}
+
+		err = s.insertTransaction(ctx, tx, holdID, models.TransactionTypeDecrease, userID, toUserID,
+			models.NewMoney(hold.Remaining(), models.DefaultCurrency), balanceAfter, now)
+		if err != nil {
+			return err
+		}
+
+		const captureHold = `UPDATE holds SET status = $1, to_id = $2, captured = amount, updated_at = $3 WHERE id = $4`
+		_, err = tx.Exec(ctx, captureHold, models.HoldStatusCaptured, toUserID, now, holdID)
+		if err != nil {
+			return fmt.Errorf("failed to update hold: %w", err)
+		}
+
+		return nil
+	})
+}
+
+// DecreaseHeldMoney debits money and the fee from the hold, the rest of the hold stays reserved.
+// It is DecreaseMoneyFromUser for money which is already reserved, so the balance is not checked again.
+// The limits are, since the hold of a batch is not checked as a whole.
+func (s *UserService) DecreaseHeldMoney(
+	ctx context.Context,
+	transactionID string,
+	holdID string,
+	fromUserID,
+	toUserID int64,
+	money models.Money,
+	fee models.Fee,
+) error {
+	ctx = metrics.WithMethod(ctx, "DecreaseHeldMoney")
+	if money.Amount < 0 || fee.Amount < 0 {
+		return fmt.Errorf("amount cannot be negative")
+	}
+	if money.Currency != models.DefaultCurrency {
+		return apperrors.ErrCurrencyMismatch
+	}
+
+	_, shardID, _ := id.ParseUserID(fromUserID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	now := time.Now().UTC()
+	return shard.WithTransaction(ctx, userDB, func(tx pgx.Tx) error {
+		// check idempotentency key
+		const query = `INSERT INTO idempotence (id, type, created_at) VALUES ($1, $2, $3)`
+		_, err := tx.Exec(ctx, query, transactionID, models.TransactionTypeDecrease, now)
+		if err != nil {
+			if pgErr, ok := lo.ErrorsAs[*pgconn.PgError](err); ok {
+				if pgErr.Code == pgerrcode.UniqueViolation {
+					return nil
+				}
+			}
+			return fmt.Errorf("failed to insert idempotetency: %w", err)
+		}
+
+		hold, err := selectHoldForUpdate(ctx, tx, holdID, fromUserID)
+		if err != nil {
+			return err
+		}
+		if hold.Status != models.HoldStatusActive || hold.ExpiresAt.Before(now) {
+			return apperrors.ErrHoldNotActive
+		}
+
+		total := money.Amount + fee.Amount
+		if hold.Remaining() < total {
+			return apperrors.ErrInsufficientFunds
+		}
+
+		// the rest of the hold is the items to come, it doesn't count against this one
+		err = s.checkTransferLimits(ctx, tx, fromUserID, money, holdID, now)
+		if err != nil {
+			return err
+		}
+
+		var balanceAfter int64
+		const debitMoney = `UPDATE users SET balance = balance - $1, held_balance = held_balance - $1, updated_at = $2
+							WHERE id = $3 RETURNING balance`
+		err = tx.QueryRow(ctx, debitMoney, total, now, fromUserID).Scan(&balanceAfter)
+		if err != nil {
+			return fmt.Errorf("failed to update balance: %w", err)
+		}
+
+		const captureHold = `UPDATE holds SET captured = captured + $1, updated_at = $2 WHERE id = $3`
+		_, err = tx.Exec(ctx, captureHold, total, now, holdID)
+		if err != nil {
+			return fmt.Errorf("failed to update hold: %w", err)
+		}
+
+		return s.insertDebitTransactions(ctx, tx, transactionID, models.TransactionTypeDecrease, fromUserID, toUserID,
+			money, fee, balanceAfter, now)
+	})
+}
+
+// ExtendHold moves the expiration of the active hold to expiresAt, an earlier expiresAt is ignored.
+func (s *UserService) ExtendHold(ctx context.Context, holdID string, userID int64, expiresAt time.Time) error {
+	ctx = metrics.WithMethod(ctx, "ExtendHold")
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	const query = `UPDATE holds SET expires_at = GREATEST(expires_at, $1), updated_at = $2
+				   WHERE id = $3 AND user_id = $4 AND status = $5`
+	rows, err := userDB.Exec(ctx, query, expiresAt.UTC(), time.Now().UTC(), holdID, userID, models.HoldStatusActive)
+	if err != nil {
+		return fmt.Errorf("failed to extend hold: %w", err)
+	}
+	if rows.RowsAffected() == 0 {
+		return apperrors.ErrHoldNotActive
+	}
+
+	return nil
+}
+
+// ReleaseHold returns the rest of the held money to the available balance. Releasing a released hold is a no-op.
+func (s *UserService) ReleaseHold(ctx context.Context, holdID string, userID int64) error {
+	ctx = metrics.WithMethod(ctx, "ReleaseHold")
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	now := time.Now().UTC()
+	return shard.WithTransaction(ctx, userDB, func(tx pgx.Tx) error {
+		hold, err := selectHoldForUpdate(ctx, tx, holdID, userID)
+		if err != nil {
+			return err
+		}
+
+		switch hold.Status {
+		case models.HoldStatusReleased:
+			return nil
+		case models.HoldStatusCaptured:
+			return apperrors.ErrHoldNotActive
+		}
+
+		const releaseMoney = `UPDATE users SET held_balance = held_balance - $1, updated_at = $2 WHERE id = $3`
+		_, err = tx.Exec(ctx, releaseMoney, hold.Remaining(), now, userID)
+		if err != nil {
+			return fmt.Errorf("failed to update held balance: %w", err)
+		}
+
+		const releaseHold = `UPDATE holds SET status = $1, updated_at = $2 WHERE id = $3`
+		_, err = tx.Exec(ctx, releaseHold, models.HoldStatusReleased, now, holdID)
+		if err != nil {
+			return fmt.Errorf("failed to update hold: %w", err)
+		}
+
+		return nil
+	})
+}
+
+func (s *UserService) GetHold(ctx context.Context, holdID string, userID int64) (*models.Hold, error) {
+	ctx = metrics.WithMethod(ctx, "GetHold")
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return nil, fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	const query = `SELECT id, user_id, COALESCE(to_id, 0), amount, captured, status, expires_at, created_at, updated_at
+				   FROM holds WHERE id = $1 AND user_id = $2`
+	return scanHold(userDB.QueryRow(ctx, query, holdID, userID))
+}
+
+func selectHoldForUpdate(ctx context.Context, tx pgx.Tx, holdID string, userID int64) (*models.Hold, error) {
+	// lock the user first, the same order as in the other money operations
+	const lockUser = `SELECT id FROM users WHERE id = $1 FOR UPDATE`
+	var lockedID int64
+	err := tx.QueryRow(ctx, lockUser, userID).Scan(&lockedID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select user: %w", err)
+	}
+
+	const query = `SELECT id, user_id, COALESCE(to_id, 0), amount, captured, status, expires_at, created_at, updated_at
+				   FROM holds WHERE id = $1 AND user_id = $2 FOR UPDATE`
+	return scanHold(tx.QueryRow(ctx, query, holdID, userID))
+}
+
+func scanHold(row pgx.Row) (*models.Hold, error) {
+	hold := models.Hold{}
+	err := row.Scan(&hold.ID, &hold.UserID, &hold.ToID, &hold.Amount, &hold.Captured, &hold.Status, &hold.ExpiresAt,
+		&hold.CreatedAt, &hold.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperrors.ErrHoldNotFound
+		}
+		return nil, fmt.Errorf("failed to select hold: %w", err)
+	}
+
+	return &hold, nil
+}
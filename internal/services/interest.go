@@ -0,0 +1,142 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/samber/lo"
+	"strconv"
+	"time"
+	"usershards/internal/metrics"
+	"usershards/internal/models"
+	"usershards/internal/shard"
+)
+
+const interestDateLayout = time.DateOnly
+
+// AccrueDepositInterest pays the day's interest to up to limit users of the shard with positive balance,
+// starting after the user afterID. It returns the last user it has looked at, 0 when the shard is done.
+// Every user is paid at most once a day, the treasury pays the total by DebitInterestExpense.
+func (s *UserService) AccrueDepositInterest(
+	ctx context.Context,
+	shardID int,
+	date time.Time,
+	afterID int64,
+	limit int,
+) (int64, error) {
+	ctx = metrics.WithMethod(ctx, "AccrueDepositInterest")
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return 0, fmt.Errorf("user shard %d not found", shardID)
+	}
+	if len(s.interest.Rates) == 0 {
+		return 0, nil
+	}
+
+	const selectUsers = `SELECT id FROM users WHERE balance > 0 AND NOT is_system AND id > $1 ORDER BY id LIMIT $2`
+	rows, err := userDB.Query(ctx, selectUsers, afterID, limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select users: %w", err)
+	}
+	userIDs, err := pgx.CollectRows(rows, pgx.RowTo[int64])
+	if err != nil {
+		return 0, fmt.Errorf("failed to read users: %w", err)
+	}
+
+	for _, userID := range userIDs {
+		err = s.accrueUserInterest(ctx, userDB, shardID, userID, date)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if len(userIDs) < limit {
+		return 0, nil
+	}
+	return userIDs[len(userIDs)-1], nil
+}
+
+func (s *UserService) accrueUserInterest(
+	ctx context.Context,
+	userDB *pgxpool.Pool,
+	shardID int,
+	userID int64,
+	date time.Time,
+) error {
+	treasury := s.SystemAccount(models.SystemAccountTreasury)
+	transferID := interestTransferID("deposit-interest", shardID, date)
+
+	now := time.Now().UTC()
+	return shard.WithTransaction(ctx, userDB, func(tx pgx.Tx) error {
+		// one payment per user and day
+		const query = `INSERT INTO idempotence (id, type, created_at) VALUES ($1, $2, $3)`
+		_, err := tx.Exec(ctx, query, dailyIdempotenceID(userID, date), models.TransactionTypeInterestCredit, now)
+		if err != nil {
+			if pgErr, ok := lo.ErrorsAs[*pgconn.PgError](err); ok {
+				if pgErr.Code == pgerrcode.UniqueViolation {
+					return nil
+				}
+			}
+			return fmt.Errorf("failed to insert idempotetency: %w", err)
+		}
+
+		var balance int64
+		const selectUser = `SELECT balance FROM users WHERE id = $1 FOR UPDATE`
+		err = tx.QueryRow(ctx, selectUser, userID).Scan(&balance)
+		if err != nil {
+			return fmt.Errorf("failed to select user: %w", err)
+		}
+
+		// paid interest is rounded down, unlike the charged one
+		interest := balance * models.InterestBasisPoints(s.interest.Rates, balance) / (365 * 10_000)
+		if interest <= 0 {
+			return nil
+		}
+
+		money := models.NewMoney(interest, models.DefaultCurrency)
+		balanceAfter, err := changeBalance(ctx, tx, userID, money.Currency, money.Amount, now)
+		if err != nil {
+			return err
+		}
+
+		return s.insertTransaction(ctx, tx, transferID, models.TransactionTypeInterestCredit, treasury, userID, money,
+			balanceAfter, now)
+	})
+}
+
+// DebitInterestExpense debits the treasury with the interest paid on the shard for the day.
+// It settles the payments made since the last call, so it is called again after every run of the payments.
+func (s *UserService) DebitInterestExpense(ctx context.Context, shardID int, date time.Time) error {
+	ctx = metrics.WithMethod(ctx, "DebitInterestExpense")
+	transferID := interestTransferID("deposit-interest", shardID, date)
+	return s.settleInterest(ctx, shardID, transferID, models.TransactionTypeInterestCredit,
+		func(ctx context.Context, settlementID string, money models.Money) error {
+			return s.decreaseMoney(ctx, settlementID, transferID, models.TransactionTypeInterestExpense,
+				s.SystemAccount(models.SystemAccountTreasury), 0, money, models.Fee{})
+		})
+}
+
+// dailyInterest is the interest of one day on amount at the annual rate, rounded up to a kopeck.
+func dailyInterest(amount, basisPoints int64) int64 {
+	const denominator = 365 * 10_000
+	if amount <= 0 || basisPoints <= 0 {
+		return 0
+	}
+	return (amount*basisPoints + denominator - 1) / denominator
+}
+
+// interestTransferID joins the interest postings of the shard and the day into one transfer.
+func interestTransferID(kind string, shardID int, date time.Time) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(kind+":"+strconv.Itoa(shardID)+":"+
+		date.UTC().Format(interestDateLayout))).String()
+}
+
+// dailyIdempotenceID is the idempotency key of the user's daily posting.
+func dailyIdempotenceID(userID int64, date time.Time) string {
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(strconv.FormatInt(userID, 10)+":"+
+		date.UTC().Format(interestDateLayout))).String()
+}
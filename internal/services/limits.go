@@ -0,0 +1,128 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/id"
+	"usershards/internal/metrics"
+	"usershards/internal/models"
+)
+
+// SetUserLimits overrides the configured transfer limits for the user.
+func (s *UserService) SetUserLimits(ctx context.Context, userID int64, limits models.TransferLimits) error {
+	ctx = metrics.WithMethod(ctx, "SetUserLimits")
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	const query = `INSERT INTO user_limits (user_id, single_limit, daily_limit, monthly_limit, updated_at)
+				   VALUES ($1, $2, $3, $4, $5)
+				   ON CONFLICT (user_id) DO UPDATE
+				   SET single_limit = $2, daily_limit = $3, monthly_limit = $4, updated_at = $5`
+	_, err := userDB.Exec(ctx, query, userID, limits.Single, limits.Daily, limits.Monthly, time.Now().UTC())
+	if err != nil {
+		return fmt.Errorf("failed to set user limits: %w", err)
+	}
+
+	return nil
+}
+
+// GetUserLimits returns the limits applied to the user: the override if present, otherwise the configured ones.
+func (s *UserService) GetUserLimits(ctx context.Context, userID int64) (models.TransferLimits, error) {
+	ctx = metrics.WithMethod(ctx, "GetUserLimits")
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return models.TransferLimits{}, fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	return s.selectUserLimits(ctx, userDB, userID)
+}
+
+type queryRower interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+}
+
+func (s *UserService) selectUserLimits(ctx context.Context, db queryRower, userID int64) (models.TransferLimits, error) {
+	limits := models.TransferLimits{}
+	const query = `SELECT single_limit, daily_limit, monthly_limit FROM user_limits WHERE user_id = $1`
+	err := db.QueryRow(ctx, query, userID).Scan(&limits.Single, &limits.Daily, &limits.Monthly)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return s.limits, nil
+		}
+		return limits, fmt.Errorf("failed to select user limits: %w", err)
+	}
+
+	return limits, nil
+}
+
+// checkTransferLimits must be called inside the transaction that holds the user's row lock,
+// otherwise concurrent debits could pass the check together. Totals are counted per currency.
+// The money reserved by the active holds counts as spent, except the hold excludeHoldID the money is paid from.
+func (s *UserService) checkTransferLimits(
+	ctx context.Context,
+	tx pgx.Tx,
+	userID int64,
+	money models.Money,
+	excludeHoldID string,
+	now time.Time,
+) error {
+	amount := money.Amount
+	limits, err := s.selectUserLimits(ctx, tx, userID)
+	if err != nil {
+		return err
+	}
+
+	if limits.Single > 0 && amount > limits.Single {
+		return fmt.Errorf("%w: single transfer limit is %d", apperrors.ErrLimitExceeded, limits.Single)
+	}
+	if limits.Daily <= 0 && limits.Monthly <= 0 {
+		return nil
+	}
+
+	dayStart := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	monthStart := time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+
+	// compensated debits returned their money, so they don't count
+	var dailyTotal, monthlyTotal int64
+	const query = `SELECT COALESCE(SUM(amount) FILTER (WHERE created_at >= $1), 0), COALESCE(SUM(amount), 0)
+				   FROM transaction
+				   WHERE from_id = $2 AND type = $3 AND status = $4 AND currency = $5 AND created_at >= $6`
+	err = tx.QueryRow(ctx, query, dayStart, userID, models.TransactionTypeDecrease, models.TransactionStatusPosted,
+		money.Currency, monthStart).Scan(&dailyTotal, &monthlyTotal)
+	if err != nil {
+		return fmt.Errorf("failed to sum outgoing transfers: %w", err)
+	}
+
+	// holds are in the default currency, their captured part is already among the transfers
+	if money.Currency == models.DefaultCurrency {
+		var dailyHeld, monthlyHeld int64
+		const selectHeld = `SELECT COALESCE(SUM(amount - captured) FILTER (WHERE created_at >= $1), 0),
+							COALESCE(SUM(amount - captured), 0)
+							FROM holds
+							WHERE user_id = $2 AND status = $3 AND id::text <> $4 AND created_at >= $5`
+		err = tx.QueryRow(ctx, selectHeld, dayStart, userID, models.HoldStatusActive, excludeHoldID,
+			monthStart).Scan(&dailyHeld, &monthlyHeld)
+		if err != nil {
+			return fmt.Errorf("failed to sum active holds: %w", err)
+		}
+		dailyTotal += dailyHeld
+		monthlyTotal += monthlyHeld
+	}
+
+	if limits.Daily > 0 && dailyTotal+amount > limits.Daily {
+		return fmt.Errorf("%w: daily limit is %d, already spent %d", apperrors.ErrLimitExceeded, limits.Daily, dailyTotal)
+	}
+	if limits.Monthly > 0 && monthlyTotal+amount > limits.Monthly {
+		return fmt.Errorf("%w: monthly limit is %d, already spent %d", apperrors.ErrLimitExceeded, limits.Monthly, monthlyTotal)
+	}
+
+	return nil
+}
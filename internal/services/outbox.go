@@ -0,0 +1,91 @@
+package services
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+	"usershards/internal/id"
+	"usershards/internal/metrics"
+	"usershards/internal/models"
+	"usershards/internal/shard"
+)
+
+// insertEvent adds the event to the outbox of the user's shard in the transaction which makes the change,
+// so the event is published if and only if the change is committed.
+func insertEvent(
+	ctx context.Context,
+	tx pgx.Tx,
+	userID int64,
+	eventType models.EventType,
+	payload any,
+	now time.Time,
+) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %w", err)
+	}
+
+	const query = `INSERT INTO outbox (user_id, type, payload, created_at) VALUES ($1, $2, $3, $4)`
+	_, err = tx.Exec(ctx, query, userID, eventType, data, now)
+	if err != nil {
+		return fmt.Errorf("failed to insert event: %w", err)
+	}
+
+	return nil
+}
+
+// insertBalanceEvent tells about the history entry to the user whose balance it has changed.
+func insertBalanceEvent(
+	ctx context.Context,
+	tx pgx.Tx,
+	transferID string,
+	transactionType models.TransactionType,
+	fromUserID,
+	toUserID int64,
+	money models.Money,
+	balanceAfter int64,
+	now time.Time,
+) error {
+	event := models.BalanceChangedEvent{
+		UserID:          toUserID,
+		CounterpartyID:  fromUserID,
+		TransferID:      transferID,
+		TransactionType: transactionType,
+		Amount:          money.Amount,
+		Currency:        money.Currency,
+		BalanceAfter:    balanceAfter,
+	}
+	eventType := models.EventTypeBalanceCredited
+	if isOutgoing(transactionType) {
+		event.UserID, event.CounterpartyID = fromUserID, toUserID
+		eventType = models.EventTypeBalanceDebited
+	}
+
+	return insertEvent(ctx, tx, event.UserID, eventType, event, now)
+}
+
+// RecordTransferFailed publishes the failure of the transfer to the sender. Recording it again is a no-op.
+func (s *UserService) RecordTransferFailed(ctx context.Context, event models.TransferFailedEvent) error {
+	ctx = metrics.WithMethod(ctx, "RecordTransferFailed")
+	_, shardID, _ := id.ParseUserID(event.FromID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	now := time.Now().UTC()
+	return shard.WithTransaction(ctx, userDB, func(tx pgx.Tx) error {
+		const query = `INSERT INTO idempotence (id, type, created_at) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING`
+		rows, err := tx.Exec(ctx, query, event.TransferID, models.EventTypeTransferFailed, now)
+		if err != nil {
+			return fmt.Errorf("failed to insert idempotetency: %w", err)
+		}
+		if rows.RowsAffected() == 0 {
+			return nil
+		}
+
+		return insertEvent(ctx, tx, event.FromID, models.EventTypeTransferFailed, event, now)
+	})
+}
@@ -0,0 +1,98 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/id"
+	"usershards/internal/metrics"
+	"usershards/internal/models"
+)
+
+const defaultPaymentCallbackTimeout = 24 * time.Hour
+
+// CreatePayment saves the pending payment. Calling it again with the same payment id is a no-op.
+func (s *UserService) CreatePayment(ctx context.Context, payment models.Payment) error {
+	ctx = metrics.WithMethod(ctx, "CreatePayment")
+	_, shardID, _ := id.ParseUserID(payment.UserID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	now := time.Now().UTC()
+	const query = `INSERT INTO payments (id, user_id, type, amount, currency, status, created_at, updated_at)
+				   VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+				   ON CONFLICT (id) DO NOTHING`
+	_, err := userDB.Exec(ctx, query, payment.ID, payment.UserID, payment.Type, payment.Money.Amount,
+		payment.Money.Currency, models.PaymentStatusPending, now)
+	if err != nil {
+		return fmt.Errorf("failed to insert payment: %w", err)
+	}
+
+	return nil
+}
+
+// UpdatePayment changes the pending payment. The finished payments are not changed anymore,
+// an empty providerRef keeps the saved one.
+func (s *UserService) UpdatePayment(
+	ctx context.Context,
+	paymentID string,
+	userID int64,
+	status models.PaymentStatus,
+	providerRef,
+	reason string,
+) error {
+	ctx = metrics.WithMethod(ctx, "UpdatePayment")
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	const query = `UPDATE payments SET status = $1, provider_ref = COALESCE(NULLIF($2, ''), provider_ref),
+				   reason = $3, updated_at = $4
+				   WHERE id = $5 AND user_id = $6 AND status = $7`
+	_, err := userDB.Exec(ctx, query, status, providerRef, reason, time.Now().UTC(), paymentID, userID,
+		models.PaymentStatusPending)
+	if err != nil {
+		return fmt.Errorf("failed to update payment: %w", err)
+	}
+
+	return nil
+}
+
+func (s *UserService) GetPayment(ctx context.Context, paymentID string, userID int64) (*models.Payment, error) {
+	ctx = metrics.WithMethod(ctx, "GetPayment")
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return nil, fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	payment := models.Payment{}
+	const query = `SELECT id, user_id, type, amount, currency, status, provider_ref, reason, created_at, updated_at
+				   FROM payments WHERE id = $1 AND user_id = $2`
+	err := userDB.QueryRow(ctx, query, paymentID, userID).Scan(&payment.ID, &payment.UserID, &payment.Type,
+		&payment.Money.Amount, &payment.Money.Currency, &payment.Status, &payment.ProviderRef, &payment.Reason,
+		&payment.CreatedAt, &payment.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperrors.ErrPaymentNotFound
+		}
+		return nil, fmt.Errorf("failed to select payment: %w", err)
+	}
+
+	return &payment, nil
+}
+
+// PaymentCallbackTimeout is how long the payment waits for the provider before it is failed.
+func (s *UserService) PaymentCallbackTimeout() time.Duration {
+	if s.payments.CallbackTimeout <= 0 {
+		return defaultPaymentCallbackTimeout
+	}
+	return s.payments.CallbackTimeout
+}
@@ -0,0 +1,160 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/samber/lo"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/id"
+	"usershards/internal/metrics"
+	"usershards/internal/models"
+	"usershards/internal/shard"
+)
+
+// DecreaseRefund debits the refund from the recipient of the transfer. The refunds of one transfer
+// never exceed the money the recipient got, the fee of the transfer is not refunded.
+// The recipient pays even when blocked, refunds are made by operators. A compensated refund is final,
+// it is not debited again by the same refund ID.
+func (s *UserService) DecreaseRefund(
+	ctx context.Context,
+	refundID,
+	transferID string,
+	recipientID,
+	senderID int64,
+	money models.Money,
+) error {
+	ctx = metrics.WithMethod(ctx, "DecreaseRefund")
+	if money.Amount <= 0 {
+		return fmt.Errorf("amount must be positive")
+	}
+
+	_, shardID, _ := id.ParseUserID(recipientID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	now := time.Now().UTC()
+	return shard.WithTransaction(ctx, userDB, func(tx pgx.Tx) error {
+		status, err := refundStatus(ctx, tx, refundID, recipientID)
+		if err != nil {
+			return err
+		}
+		if status == models.TransactionStatusCompensated {
+			return apperrors.ErrRefundNotAllowed
+		}
+
+		// check idempotentency key
+		const query = `INSERT INTO idempotence (id, type, created_at) VALUES ($1, $2, $3)`
+		_, err = tx.Exec(ctx, query, refundID, models.TransactionTypeRefundOut, now)
+		if err != nil {
+			if pgErr, ok := lo.ErrorsAs[*pgconn.PgError](err); ok {
+				if pgErr.Code == pgerrcode.UniqueViolation {
+					return nil
+				}
+			}
+			return fmt.Errorf("failed to insert idempotetency: %w", err)
+		}
+
+		// the recipient's row lock serializes the refunds of the transfer
+		account, err := lockAccount(ctx, tx, recipientID, money.Currency)
+		if err != nil {
+			return err
+		}
+
+		var received int64
+		const selectCredit = `SELECT COALESCE(SUM(amount), 0) FROM transaction
+							  WHERE transfer_id = $1 AND to_id = $2 AND from_id = $3 AND currency = $4
+							  AND type = ANY($5) AND status = $6`
+		err = tx.QueryRow(ctx, selectCredit, transferID, recipientID, senderID, money.Currency,
+			typesToStrings([]models.TransactionType{models.TransactionTypeIncrease, models.TransactionTypeExchangeIn}),
+			models.TransactionStatusPosted).Scan(&received)
+		if err != nil {
+			return fmt.Errorf("failed to select transfer credit: %w", err)
+		}
+		if received == 0 {
+			return apperrors.ErrRefundNotAllowed
+		}
+
+		var refunded int64
+		err = tx.QueryRow(ctx, selectRefunded, transferID, recipientID, models.TransactionStatusPosted).Scan(&refunded)
+		if err != nil {
+			return fmt.Errorf("failed to select refunds: %w", err)
+		}
+		if refunded+money.Amount > received {
+			return apperrors.ErrRefundExceedsTransfer
+		}
+
+		if !account.isSystem && account.available < money.Amount {
+			return apperrors.ErrInsufficientFunds
+		}
+
+		const insertRefund = `INSERT INTO refunds (id, transfer_id, from_id, to_id, amount, currency, status,
+							  created_at, updated_at)
+							  VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $8)`
+		_, err = tx.Exec(ctx, insertRefund, refundID, transferID, recipientID, senderID, money.Amount, money.Currency,
+			models.TransactionStatusPosted, now)
+		if err != nil {
+			return fmt.Errorf("failed to insert refund: %w", err)
+		}
+
+		balanceAfter, err := changeBalance(ctx, tx, recipientID, money.Currency, -money.Amount, now)
+		if err != nil {
+			return err
+		}
+
+		return s.insertTransaction(ctx, tx, refundID, models.TransactionTypeRefundOut, recipientID, senderID, money,
+			balanceAfter, now)
+	})
+}
+
+// GetRefundStatus returns the status of the refund, it is empty when the refund wasn't debited.
+func (s *UserService) GetRefundStatus(ctx context.Context, refundID string, recipientID int64) (models.TransactionStatus, error) {
+	ctx = metrics.WithMethod(ctx, "GetRefundStatus")
+	_, shardID, _ := id.ParseUserID(recipientID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return "", fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	return refundStatus(ctx, userDB, refundID, recipientID)
+}
+
+func refundStatus(ctx context.Context, db queryRower, refundID string, recipientID int64) (models.TransactionStatus, error) {
+	var status models.TransactionStatus
+	const query = `SELECT status FROM refunds WHERE id = $1 AND from_id = $2`
+	err := db.QueryRow(ctx, query, refundID, recipientID).Scan(&status)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to select refund: %w", err)
+	}
+
+	return status, nil
+}
+
+// GetRefunded returns the total refunded by the recipient of the transfer, the compensated refunds don't count.
+func (s *UserService) GetRefunded(ctx context.Context, transferID string, recipientID int64) (int64, error) {
+	ctx = metrics.WithMethod(ctx, "GetRefunded")
+	_, shardID, _ := id.ParseUserID(recipientID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return 0, fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	var refunded int64
+	err := userDB.QueryRow(ctx, selectRefunded, transferID, recipientID, models.TransactionStatusPosted).Scan(&refunded)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select refunds: %w", err)
+	}
+
+	return refunded, nil
+}
+
+const selectRefunded = `SELECT COALESCE(SUM(amount), 0) FROM refunds WHERE transfer_id = $1 AND from_id = $2 AND status = $3`
@@ -0,0 +1,123 @@
+package services
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/id"
+	"usershards/internal/metrics"
+	"usershards/internal/models"
+)
+
+const scheduleColumns = `id, from_id, to_id, amount, kind, cron, status, start_at, next_run_at, runs, last_error, created_at, updated_at`
+
+// CreateSchedule saves the schedule of the sender. Calling it again with the same id is a no-op.
+func (s *UserService) CreateSchedule(ctx context.Context, schedule models.Schedule) error {
+	ctx = metrics.WithMethod(ctx, "CreateSchedule")
+	_, shardID, _ := id.ParseUserID(schedule.FromID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	now := time.Now().UTC()
+	const query = `INSERT INTO schedules (id, from_id, to_id, amount, kind, cron, status, start_at, next_run_at, created_at, updated_at)
+				   VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $10)
+				   ON CONFLICT (id) DO NOTHING`
+	_, err := userDB.Exec(ctx, query, schedule.ID, schedule.FromID, schedule.ToID, schedule.Amount, schedule.Kind,
+		schedule.Cron, schedule.Status, schedule.StartAt.UTC(), schedule.NextRunAt.UTC(), now)
+	if err != nil {
+		return fmt.Errorf("failed to insert schedule: %w", err)
+	}
+
+	return nil
+}
+
+// UpdateSchedule saves the state of the schedule kept by its workflow.
+func (s *UserService) UpdateSchedule(ctx context.Context, schedule models.Schedule) error {
+	ctx = metrics.WithMethod(ctx, "UpdateSchedule")
+	_, shardID, _ := id.ParseUserID(schedule.FromID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	var nextRunAt *time.Time
+	if !schedule.NextRunAt.IsZero() {
+		nextRunAt = &schedule.NextRunAt
+	}
+
+	const query = `UPDATE schedules SET status = $1, next_run_at = $2, runs = $3, last_error = $4, updated_at = $5
+				   WHERE id = $6 AND from_id = $7`
+	_, err := userDB.Exec(ctx, query, schedule.Status, nextRunAt, schedule.Runs, schedule.LastError,
+		time.Now().UTC(), schedule.ID, schedule.FromID)
+	if err != nil {
+		return fmt.Errorf("failed to update schedule: %w", err)
+	}
+
+	return nil
+}
+
+func (s *UserService) GetSchedule(ctx context.Context, scheduleID string, userID int64) (*models.Schedule, error) {
+	ctx = metrics.WithMethod(ctx, "GetSchedule")
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return nil, fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	const query = `SELECT ` + scheduleColumns + ` FROM schedules WHERE id = $1 AND from_id = $2`
+	return scanSchedule(userDB.QueryRow(ctx, query, scheduleID, userID))
+}
+
+// ListSchedules returns the schedules of the sender, the newest first.
+func (s *UserService) ListSchedules(ctx context.Context, userID int64) ([]models.Schedule, error) {
+	ctx = metrics.WithMethod(ctx, "ListSchedules")
+	_, shardID, _ := id.ParseUserID(userID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return nil, fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	const query = `SELECT ` + scheduleColumns + ` FROM schedules WHERE from_id = $1 ORDER BY created_at DESC, id DESC`
+	rows, err := userDB.Query(ctx, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select schedules: %w", err)
+	}
+	defer rows.Close()
+
+	schedules := make([]models.Schedule, 0)
+	for rows.Next() {
+		schedule, err := scanSchedule(rows)
+		if err != nil {
+			return nil, err
+		}
+		schedules = append(schedules, *schedule)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read schedules: %w", err)
+	}
+
+	return schedules, nil
+}
+
+func scanSchedule(row pgx.Row) (*models.Schedule, error) {
+	schedule := models.Schedule{}
+	var nextRunAt *time.Time
+	err := row.Scan(&schedule.ID, &schedule.FromID, &schedule.ToID, &schedule.Amount, &schedule.Kind, &schedule.Cron,
+		&schedule.Status, &schedule.StartAt, &nextRunAt, &schedule.Runs, &schedule.LastError, &schedule.CreatedAt, &schedule.UpdatedAt)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperrors.ErrScheduleNotFound
+		}
+		return nil, fmt.Errorf("failed to select schedule: %w", err)
+	}
+	if nextRunAt != nil {
+		schedule.NextRunAt = *nextRunAt
+	}
+
+	return &schedule, nil
+}
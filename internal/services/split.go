@@ -0,0 +1,84 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/google/uuid"
+	"strconv"
+	"usershards/internal/id"
+	"usershards/internal/metrics"
+	"usershards/internal/models"
+)
+
+// IncreaseSplitMoney credits one recipient of the split transfer. All credits of the transfer are entries
+// of the same transfer, the idempotency key is made per recipient since several recipients may share a shard.
+func (s *UserService) IncreaseSplitMoney(
+	ctx context.Context,
+	transactionID string,
+	fromUserID,
+	toUserID int64,
+	money models.Money,
+) error {
+	ctx = metrics.WithMethod(ctx, "IncreaseSplitMoney")
+	return s.increaseMoney(ctx, splitIdempotenceID(transactionID, toUserID), transactionID,
+		models.TransactionTypeIncrease, fromUserID, toUserID, money)
+}
+
+// ReverseSplitMoney takes the credit back from the recipient of the compensated split transfer, even when
+// the recipient is blocked. The recipient who has spent the money pays down to the end of the credit line,
+// the suspense account pays the rest to the sender and keeps the recipient's debt.
+func (s *UserService) ReverseSplitMoney(
+	ctx context.Context,
+	transactionID string,
+	recipientID,
+	senderID int64,
+	money models.Money,
+) error {
+	ctx = metrics.WithMethod(ctx, "ReverseSplitMoney")
+	err := s.decreaseMoney(ctx, splitIdempotenceID(transactionID, recipientID), transactionID,
+		models.TransactionTypeCreditReversal, recipientID, senderID, money, models.Fee{})
+	if err != nil {
+		return err
+	}
+
+	// the reversal may be replayed, so the amount taken is read back from the entry
+	reversed, err := s.reversedSplitAmount(ctx, transactionID, recipientID)
+	if err != nil {
+		return err
+	}
+	if reversed >= money.Amount {
+		return nil
+	}
+
+	shortfall := models.NewMoney(money.Amount-reversed, money.Currency)
+	return s.decreaseMoney(ctx, splitIdempotenceID(transactionID, recipientID, "suspense"), transactionID,
+		models.TransactionTypeCreditReversal, s.SystemAccount(models.SystemAccountSuspense), senderID, shortfall,
+		models.Fee{})
+}
+
+func (s *UserService) reversedSplitAmount(ctx context.Context, transactionID string, recipientID int64) (int64, error) {
+	_, shardID, _ := id.ParseUserID(recipientID)
+	userDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return 0, fmt.Errorf("user shard %d not found", shardID)
+	}
+
+	var amount int64
+	const query = `SELECT amount FROM transaction WHERE transfer_id = $1 AND type = $2 AND from_id = $3`
+	err := userDB.QueryRow(ctx, query, transactionID, models.TransactionTypeCreditReversal, recipientID).Scan(&amount)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select credit reversal: %w", err)
+	}
+
+	return amount, nil
+}
+
+// splitIdempotenceID is the key of the entry of one recipient, the suffix tells apart the other entries
+// made for the recipient.
+func splitIdempotenceID(transactionID string, userID int64, suffix ...string) string {
+	name := transactionID + ":" + strconv.FormatInt(userID, 10)
+	for _, part := range suffix {
+		name += ":" + part
+	}
+	return uuid.NewSHA1(uuid.NameSpaceOID, []byte(name)).String()
+}
@@ -0,0 +1,99 @@
+package services
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"time"
+	"usershards/internal/id"
+	"usershards/internal/metrics"
+	"usershards/internal/models"
+	"usershards/internal/shard"
+)
+
+func newSystemAccounts(shards map[models.SystemAccount]int) map[models.SystemAccount]int64 {
+	accounts := make(map[models.SystemAccount]int64, len(models.SystemAccounts))
+	for i, account := range models.SystemAccounts {
+		accounts[account] = id.SystemAccountID(shards[account], int64(i+1))
+	}
+	return accounts
+}
+
+// SystemAccount returns the id of the system account.
+func (s *UserService) SystemAccount(account models.SystemAccount) int64 {
+	return s.systemAccounts[account]
+}
+
+// EnsureSystemAccounts creates the system accounts which don't exist yet. It must be called on startup,
+// before any money is moved.
+func (s *UserService) EnsureSystemAccounts(ctx context.Context) error {
+	ctx = metrics.WithMethod(ctx, "EnsureSystemAccounts")
+	now := time.Now().UTC()
+	for _, account := range models.SystemAccounts {
+		accountID := s.systemAccounts[account]
+		_, shardID, _ := id.ParseUserID(accountID)
+		usersDB, ok := s.ShardManager.UserShards[shardID]
+		if !ok {
+			return fmt.Errorf("user shard %d not found", shardID)
+		}
+
+		// phone and email are unique, so they are filled with the account name
+		const query = `INSERT INTO users (id, phone_number, email, balance, created_at, updated_at, is_system)
+					   VALUES ($1, $2, $2, 0, $3, $3, true)
+					   ON CONFLICT (id) DO NOTHING`
+		err := shard.WithTransaction(ctx, usersDB, func(tx pgx.Tx) error {
+			rows, err := tx.Exec(ctx, query, accountID, "system:"+string(account), now)
+			if err != nil {
+				return err
+			}
+			if rows.RowsAffected() == 0 {
+				return nil
+			}
+
+			return s.appendAccountEvent(ctx, tx, models.AccountEvent{
+				UserID:    accountID,
+				Type:      models.AccountEventTypeOpened,
+				CreatedAt: now,
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("failed to create system account %s: %w", account, err)
+		}
+	}
+
+	return nil
+}
+
+// LedgerTotals sums balances of all accounts on all shards per currency. Every movement of money has
+// two sides, so the totals are zero when no transfer is in flight.
+func (s *UserService) LedgerTotals(ctx context.Context) (map[models.Currency]int64, error) {
+	ctx = metrics.WithMethod(ctx, "LedgerTotals")
+	totals := make(map[models.Currency]int64)
+	for shardID, usersDB := range s.ShardManager.UserShards {
+		var total int64
+		err := usersDB.QueryRow(ctx, `SELECT COALESCE(SUM(balance), 0) FROM users`).Scan(&total)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sum balances on shard %d: %w", shardID, err)
+		}
+		totals[models.DefaultCurrency] += total
+
+		rows, err := usersDB.Query(ctx, `SELECT currency, SUM(balance) FROM accounts GROUP BY currency`)
+		if err != nil {
+			return nil, fmt.Errorf("failed to sum accounts on shard %d: %w", shardID, err)
+		}
+		for rows.Next() {
+			var currency models.Currency
+			if err = rows.Scan(&currency, &total); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("failed to scan account totals: %w", err)
+			}
+			totals[currency] += total
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read account totals: %w", err)
+		}
+	}
+
+	return totals, nil
+}
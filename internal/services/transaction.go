@@ -0,0 +1,317 @@
+package services
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/id"
+	"usershards/internal/metrics"
+	"usershards/internal/models"
+)
+
+const defaultTransactionsLimit = 20
+const maxTransactionsLimit = 100
+
+// ListTransactions returns the user's statement, newest entries first.
+// Pagination is keyset based on (created_at, id): pass NextCursor of the previous page to get the next one.
+func (s *UserService) ListTransactions(
+	ctx context.Context,
+	userID int64,
+	cursor string,
+	limit int,
+	filter models.TransactionFilter,
+) (*models.TransactionPage, error) {
+	ctx = metrics.WithMethod(ctx, "ListTransactions")
+	_, shardID, _ := id.ParseUserID(userID)
+	usersDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return nil, fmt.Errorf("user shard %d not found for id %d", shardID, userID)
+	}
+
+	if limit <= 0 {
+		limit = defaultTransactionsLimit
+	}
+	if limit > maxTransactionsLimit {
+		limit = maxTransactionsLimit
+	}
+
+	// debit entries of the user are stored with from_id = user, credit entries with to_id = user.
+	// Both sides of a transfer may live on the same shard, so the entry type decides whose entry it is.
+	args := []any{userID}
+	var sides []string
+	if filter.Direction == "" || filter.Direction == models.TransactionDirectionOut {
+		args = append(args, typesToStrings(models.OutgoingTransactionTypes))
+		sides = append(sides, fmt.Sprintf("(from_id = $1 AND type = ANY($%d))", len(args)))
+	}
+	if filter.Direction == "" || filter.Direction == models.TransactionDirectionIn {
+		args = append(args, typesToStrings(models.IncomingTransactionTypes))
+		sides = append(sides, fmt.Sprintf("(to_id = $1 AND type = ANY($%d))", len(args)))
+	}
+	if len(sides) == 0 {
+		return nil, fmt.Errorf("unknown transaction direction %q", filter.Direction)
+	}
+
+	conditions := []string{"(" + strings.Join(sides, " OR ") + ")"}
+	if filter.Currency != "" {
+		args = append(args, filter.Currency)
+		conditions = append(conditions, fmt.Sprintf("currency = $%d", len(args)))
+	}
+	if len(filter.Types) > 0 {
+		args = append(args, typesToStrings(filter.Types))
+		conditions = append(conditions, fmt.Sprintf("type = ANY($%d)", len(args)))
+	}
+	if !filter.Since.IsZero() {
+		args = append(args, filter.Since.UTC())
+		conditions = append(conditions, fmt.Sprintf("created_at >= $%d", len(args)))
+	}
+	if !filter.Until.IsZero() {
+		args = append(args, filter.Until.UTC())
+		conditions = append(conditions, fmt.Sprintf("created_at < $%d", len(args)))
+	}
+	if cursor != "" {
+		createdAt, entryID, err := decodeTransactionCursor(cursor)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, createdAt, entryID)
+		conditions = append(conditions, fmt.Sprintf("(created_at, id) < ($%d, $%d)", len(args)-1, len(args)))
+	}
+
+	// fetch one extra row to know whether there is a next page
+	args = append(args, limit+1)
+	query := `SELECT ` + transactionColumns + ` FROM transaction
+			  WHERE ` + strings.Join(conditions, " AND ") + `
+			  ORDER BY created_at DESC, id DESC
+			  LIMIT $` + strconv.Itoa(len(args))
+
+	rows, err := usersDB.Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select transactions: %w", err)
+	}
+	defer rows.Close()
+
+	page := &models.TransactionPage{Transactions: make([]models.Transaction, 0, limit)}
+	for rows.Next() {
+		transaction, err := scanTransaction(rows)
+		if err != nil {
+			return nil, err
+		}
+		page.Transactions = append(page.Transactions, transaction)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read transactions: %w", err)
+	}
+
+	if len(page.Transactions) > limit {
+		page.Transactions = page.Transactions[:limit]
+		last := page.Transactions[limit-1]
+		page.NextCursor = encodeTransactionCursor(last.CreatedAt, last.ID)
+	}
+
+	return page, nil
+}
+
+// GetTransfer assembles the transfer from its entries. The transfer ID doesn't say where the entries are,
+// so every user shard is asked.
+func (s *UserService) GetTransfer(ctx context.Context, transferID string) (*models.Transfer, error) {
+	ctx = metrics.WithMethod(ctx, "GetTransfer")
+	const query = `SELECT ` + transactionColumns + ` FROM transaction WHERE transfer_id = $1`
+
+	var entries []models.Transaction
+	for shardID, usersDB := range s.ShardManager.UserShards {
+		rows, err := usersDB.Query(ctx, query, transferID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to select transfer entries on shard %d: %w", shardID, err)
+		}
+
+		for rows.Next() {
+			transaction, err := scanTransaction(rows)
+			if err != nil {
+				rows.Close()
+				return nil, err
+			}
+			entries = append(entries, transaction)
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, fmt.Errorf("failed to read transfer entries on shard %d: %w", shardID, err)
+		}
+	}
+
+	if len(entries) == 0 {
+		return nil, apperrors.ErrTransferNotFound
+	}
+
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].CreatedAt.Before(entries[j].CreatedAt)
+	})
+
+	transfer := &models.Transfer{
+		ID:        transferID,
+		Status:    models.TransferStatusPending,
+		CreatedAt: entries[0].CreatedAt,
+		Entries:   entries,
+	}
+	for _, entry := range entries {
+		switch entry.Type {
+		case models.TransactionTypeDecrease, models.TransactionTypeExchangeOut, models.TransactionTypeRefundOut:
+			transfer.FromID = entry.UserID
+			transfer.ToID = entry.CounterpartyID
+			transfer.Amount = entry.Amount
+			transfer.Currency = entry.Currency
+		case models.TransactionTypeFee:
+			transfer.Fee = entry.Amount
+		case models.TransactionTypeIncrease, models.TransactionTypeExchangeIn, models.TransactionTypeRefundIn:
+			if transfer.Status == models.TransferStatusPending {
+				transfer.Status = models.TransferStatusCompleted
+			}
+		case models.TransactionTypeCompensate, models.TransactionTypeRefundCompensate:
+			transfer.Status = models.TransferStatusCompensated
+		}
+	}
+
+	// refunds are kept with the recipient, who pays them
+	if transfer.ToID != 0 {
+		refunded, err := s.GetRefunded(ctx, transferID, transfer.ToID)
+		if err != nil {
+			return nil, err
+		}
+		transfer.Refunded = refunded
+	}
+
+	return transfer, nil
+}
+
+// insertDebitTransactions records the debit and the fee paid with it, balanceAfter is the balance after both.
+func (s *UserService) insertDebitTransactions(
+	ctx context.Context,
+	tx pgx.Tx,
+	transactionID string,
+	transactionType models.TransactionType,
+	fromUserID,
+	toUserID int64,
+	money models.Money,
+	fee models.Fee,
+	balanceAfter int64,
+	now time.Time,
+) error {
+	err := s.insertTransaction(ctx, tx, transactionID, transactionType, fromUserID, toUserID, money,
+		balanceAfter+fee.Amount, now)
+	if err != nil {
+		return err
+	}
+
+	if fee.Amount > 0 {
+		err = s.insertTransaction(ctx, tx, transactionID, models.TransactionTypeFee, fromUserID, fee.AccountID,
+			models.NewMoney(fee.Amount, money.Currency), balanceAfter, now)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// insertTransaction adds a posted history entry of the transfer and the events of the balance change.
+func (s *UserService) insertTransaction(
+	ctx context.Context,
+	tx pgx.Tx,
+	transferID string,
+	transactionType models.TransactionType,
+	fromUserID,
+	toUserID int64,
+	money models.Money,
+	balanceAfter int64,
+	now time.Time,
+) error {
+	const query = `INSERT INTO transaction
+				   (id, transfer_id, type, from_id, to_id, amount, currency, balance_after, status, created_at)
+				   VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+	_, err := tx.Exec(ctx, query, uuid.NewString(), transferID, transactionType, fromUserID, toUserID,
+		money.Amount, money.Currency, balanceAfter, models.TransactionStatusPosted, now)
+	if err != nil {
+		return fmt.Errorf("failed to insert transaction history: %w", err)
+	}
+
+	err = insertBalanceEvent(ctx, tx, transferID, transactionType, fromUserID, toUserID, money, balanceAfter, now)
+	if err != nil {
+		return err
+	}
+
+	return s.appendBalanceEvent(ctx, tx, transferID, transactionType, fromUserID, toUserID, money, now)
+}
+
+const transactionColumns = `id, transfer_id, type, from_id, to_id, amount, currency, COALESCE(balance_after, 0),
+							status, created_at`
+
+// scanTransaction reads a row selected with transactionColumns and resolves whose entry it is.
+func scanTransaction(row pgx.Row) (models.Transaction, error) {
+	var transaction models.Transaction
+	var fromID, toID int64
+	err := row.Scan(&transaction.ID, &transaction.TransferID, &transaction.Type, &fromID, &toID,
+		&transaction.Amount, &transaction.Currency, &transaction.BalanceAfter, &transaction.Status,
+		&transaction.CreatedAt)
+	if err != nil {
+		return transaction, fmt.Errorf("failed to scan transaction: %w", err)
+	}
+
+	if isOutgoing(transaction.Type) {
+		transaction.Direction = models.TransactionDirectionOut
+		transaction.UserID = fromID
+		transaction.CounterpartyID = toID
+	} else {
+		transaction.Direction = models.TransactionDirectionIn
+		transaction.UserID = toID
+		transaction.CounterpartyID = fromID
+	}
+
+	return transaction, nil
+}
+
+func isOutgoing(transactionType models.TransactionType) bool {
+	for _, t := range models.OutgoingTransactionTypes {
+		if t == transactionType {
+			return true
+		}
+	}
+	return false
+}
+
+func typesToStrings(types []models.TransactionType) []string {
+	res := make([]string, 0, len(types))
+	for _, t := range types {
+		res = append(res, string(t))
+	}
+	return res
+}
+
+func encodeTransactionCursor(createdAt time.Time, entryID string) string {
+	raw := strconv.FormatInt(createdAt.UnixNano(), 10) + ":" + entryID
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeTransactionCursor(cursor string) (time.Time, string, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return time.Time{}, "", apperrors.ErrInvalidCursor
+	}
+
+	nanos, entryID, found := strings.Cut(string(raw), ":")
+	if !found || entryID == "" {
+		return time.Time{}, "", apperrors.ErrInvalidCursor
+	}
+
+	unixNano, err := strconv.ParseInt(nanos, 10, 64)
+	if err != nil {
+		return time.Time{}, "", apperrors.ErrInvalidCursor
+	}
+
+	return time.Unix(0, unixNano).UTC(), entryID, nil
+}
@@ -4,31 +4,74 @@ import (
 	"context"
 	"errors"
 	"fmt"
-	"github.com/google/uuid"
 	"github.com/jackc/pgerrcode"
 	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/samber/lo"
+	"go.uber.org/zap"
+	"slices"
 	"time"
 	"usershards/internal/apperrors"
+	"usershards/internal/config"
+	"usershards/internal/fees"
+	"usershards/internal/fx"
 	"usershards/internal/id"
+	"usershards/internal/logger"
+	"usershards/internal/metrics"
 	"usershards/internal/models"
 	"usershards/internal/shard"
 )
 
-const WelcomeBonus = 1000_00
+// NewUserService creates the service. rates may be nil, then currency exchange is not available.
+func NewUserService(manager *shard.ShardManager, conf *config.Config, rates fx.RateSource) *UserService {
+	systemAccounts := newSystemAccounts(conf.SystemAccounts)
+
+	// fees and spreads go to the system accounts unless other accounts are configured,
+	// and moving money between system accounts is free
+	feesConf := conf.Fees
+	if feesConf.RevenueAccount == 0 {
+		feesConf.RevenueAccount = systemAccounts[models.SystemAccountFeeRevenue]
+	}
+	feesConf.InternalAccounts = append(slices.Clone(feesConf.InternalAccounts), lo.Values(systemAccounts)...)
+
+	fxConf := conf.FX
+	if fxConf.TreasuryAccount == 0 {
+		fxConf.TreasuryAccount = systemAccounts[models.SystemAccountTreasury]
+	}
 
-func NewUserService(manager *shard.ShardManager) *UserService {
 	return &UserService{
-		ShardManager: manager,
+		ShardManager:   manager,
+		limits:         conf.Limits,
+		fees:           fees.NewCalculator(feesConf),
+		fx:             fxConf,
+		rates:          rates,
+		systemAccounts: systemAccounts,
+		campaigns:      conf.Campaigns,
+		payments:       conf.Payments,
+		credit:         conf.Credit,
+		interest:       conf.Interest,
+		webhooks:       conf.Webhooks,
+		storage:        conf.Storage,
 	}
 }
 
 type UserService struct {
-	ShardManager *shard.ShardManager
+	ShardManager   *shard.ShardManager
+	limits         models.TransferLimits
+	fees           *fees.Calculator
+	fx             config.FX
+	rates          fx.RateSource
+	systemAccounts map[models.SystemAccount]int64
+	campaigns      []models.Campaign
+	payments       config.Payments
+	credit         config.Credit
+	interest       config.Interest
+	webhooks       config.Webhooks
+	storage        config.Storage
 }
 
 func (s *UserService) GetUserByID(ctx context.Context, userID int64) (*models.User, error) {
+	ctx = metrics.WithMethod(ctx, "GetUserByID")
 	_, shardID, _ := id.ParseUserID(userID)
 
 	usersDB, ok := s.ShardManager.UserShards[shardID]
@@ -36,11 +79,13 @@ func (s *UserService) GetUserByID(ctx context.Context, userID int64) (*models.Us
 		return nil, fmt.Errorf("user shard %d not found for id %d", shardID, userID)
 	}
 
-	const query = `SELECT id, phone_number, email, balance, created_at, updated_at FROM users WHERE id = $1`
+	const query = `SELECT id, phone_number, email, balance, balance - held_balance + credit_limit, credit_limit,
+				   created_at, updated_at
+				   FROM users WHERE id = $1`
 
 	user := models.User{}
 	err := usersDB.QueryRow(ctx, query, userID).Scan(&user.ID, &user.Phone, &user.Email,
-		&user.Balance, &user.CreatedAt, &user.UpdatedAt)
+		&user.Balance, &user.AvailableBalance, &user.CreditLimit, &user.CreatedAt, &user.UpdatedAt)
 	if err != nil {
 		return nil, fmt.Errorf("failed to get user: %w", err)
 	}
@@ -49,6 +94,7 @@ func (s *UserService) GetUserByID(ctx context.Context, userID int64) (*models.Us
 }
 
 func (s *UserService) MarkUserAsBlocked(ctx context.Context, userID int64) error {
+	ctx = metrics.WithMethod(ctx, "MarkUserAsBlocked")
 	_, shardID, _ := id.ParseUserID(userID)
 
 	usersDB, ok := s.ShardManager.UserShards[shardID]
@@ -56,52 +102,110 @@ func (s *UserService) MarkUserAsBlocked(ctx context.Context, userID int64) error
 		return fmt.Errorf("user shard %d not found for id %d", shardID, userID)
 	}
 
-	const query = `UPDATE users SET is_blocked = true WHERE id = $1`
+	ctx = logger.WithFields(ctx, logger.UserID(userID), logger.ShardID(shardID))
+	now := time.Now().UTC()
+	err := shard.WithTransaction(ctx, usersDB, func(tx pgx.Tx) error {
+		var isBlocked bool
+		const selectUser = `SELECT is_blocked FROM users WHERE id = $1 FOR UPDATE`
+		err := tx.QueryRow(ctx, selectUser, userID).Scan(&isBlocked)
+		if err != nil {
+			return fmt.Errorf("failed to get user: %w", err)
+		}
+		if isBlocked {
+			return nil
+		}
 
-	rows, err := usersDB.Exec(ctx, query, userID)
+		const query = `UPDATE users SET is_blocked = true, updated_at = $1 WHERE id = $2`
+		_, err = tx.Exec(ctx, query, now, userID)
+		if err != nil {
+			return fmt.Errorf("failed to block user: %w", err)
+		}
+
+		err = insertEvent(ctx, tx, userID, models.EventTypeUserBlocked, models.UserBlockedEvent{UserID: userID}, now)
+		if err != nil {
+			return err
+		}
+
+		return s.appendAccountEvent(ctx, tx, models.AccountEvent{
+			UserID:    userID,
+			Type:      models.AccountEventTypeBlocked,
+			CreatedAt: now,
+		})
+	})
 	if err != nil {
-		return fmt.Errorf("failed to get user: %w", err)
-	}
-	if rows.RowsAffected() != 1 {
-		return fmt.Errorf("expected 1 rows affected, got %d", rows.RowsAffected())
+		return err
 	}
 
+	logger.FromContext(ctx).Info("user blocked")
 	return nil
 }
 
 func (s *UserService) CreateUserRecord(ctx context.Context, userID int64, phone, email string) error {
+	ctx = metrics.WithMethod(ctx, "CreateUserRecord")
 	userShard := s.ShardManager.HashPhoneNumber(phone)
 	usersDB, ok := s.ShardManager.UserShards[userShard]
 	if !ok {
 		return fmt.Errorf("user shard %d not found", userShard)
 	}
 
+	ctx = logger.WithFields(ctx, logger.UserID(userID), logger.ShardID(userShard))
 	now := time.Now().UTC()
 
-	_, err := usersDB.Exec(ctx, `INSERT INTO users (id, phone_number, email, balance, created_at, updated_at) 
-								 VALUES ($1, $2, $3, $4, $5, $6)`, userID, phone, email, WelcomeBonus, now, now)
+	err := shard.WithTransaction(ctx, usersDB, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `INSERT INTO users (id, phone_number, email, balance, created_at, updated_at) 
+								VALUES ($1, $2, $3, $4, $5, $6)`, userID, phone, email, 0, now, now)
+		if err != nil {
+			return fmt.Errorf("failed to insert user: %w", err)
+		}
+
+		event := models.UserCreatedEvent{UserID: userID, Phone: phone, Email: email}
+		err = insertEvent(ctx, tx, userID, models.EventTypeUserCreated, event, now)
+		if err != nil {
+			return err
+		}
+
+		return s.appendAccountEvent(ctx, tx, models.AccountEvent{
+			UserID:    userID,
+			Type:      models.AccountEventTypeOpened,
+			CreatedAt: now,
+		})
+	})
 	if err != nil {
-		return fmt.Errorf("failed to insert user: %w", err)
+		return err
 	}
 
+	logger.FromContext(ctx).Info("user created")
 	return nil
 }
 
 func (s *UserService) DeleteUserRecordIfPresentByUserID(ctx context.Context, userID int64) error {
+	ctx = metrics.WithMethod(ctx, "DeleteUserRecordIfPresentByUserID")
 	_, shardID, _ := id.ParseUserID(userID)
 	shardDB, ok := s.ShardManager.UserShards[shardID]
 	if !ok {
 		return fmt.Errorf("shard not found %d", shardID)
 	}
-	_, err := shardDB.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
-	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
-		return fmt.Errorf("failed to delete user record for userID %d: %w", userID, err)
-	}
+	// the creation of the user is undone, so the user's stream goes away with the row
+	return shard.WithTransaction(ctx, shardDB, func(tx pgx.Tx) error {
+		_, err := tx.Exec(ctx, `DELETE FROM users WHERE id = $1`, userID)
+		if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+			return fmt.Errorf("failed to delete user record for userID %d: %w", userID, err)
+		}
+		_, err = tx.Exec(ctx, `DELETE FROM account_events WHERE user_id = $1`, userID)
+		if err != nil {
+			return fmt.Errorf("failed to delete account events for userID %d: %w", userID, err)
+		}
+		_, err = tx.Exec(ctx, `DELETE FROM account_snapshots WHERE user_id = $1`, userID)
+		if err != nil {
+			return fmt.Errorf("failed to delete account snapshot for userID %d: %w", userID, err)
+		}
 
-	return nil
+		return nil
+	})
 }
 
 func (s *UserService) DeleteEmailRecordIfPresentByUserID(ctx context.Context, email string) error {
+	ctx = metrics.WithMethod(ctx, "DeleteEmailRecordIfPresentByUserID")
 	shardID := s.ShardManager.HashEmail(email)
 	shardDB, ok := s.ShardManager.EmailShards[shardID]
 	if !ok {
@@ -116,6 +220,7 @@ func (s *UserService) DeleteEmailRecordIfPresentByUserID(ctx context.Context, em
 }
 
 func (s *UserService) CreateEmailRecord(ctx context.Context, userID int64, email string) error {
+	ctx = metrics.WithMethod(ctx, "CreateEmailRecord")
 	emailShard := s.ShardManager.HashEmail(email)
 	emailsDB, ok := s.ShardManager.EmailShards[emailShard]
 	if !ok {
@@ -132,18 +237,40 @@ func (s *UserService) CreateEmailRecord(ctx context.Context, userID int64, email
 	return nil
 }
 
+// DecreaseMoneyFromUser debits money and the fee from the user's account in money.Currency.
+// The fee is charged in the same currency.
 func (s *UserService) DecreaseMoneyFromUser(
 	ctx context.Context,
 	transactionID string,
 	transactionType models.TransactionType,
 	fromUserID,
 	toUserID int64,
-	amount int64,
+	money models.Money,
+	fee models.Fee,
+) error {
+	ctx = metrics.WithMethod(ctx, "DecreaseMoneyFromUser")
+	return s.decreaseMoney(ctx, transactionID, transactionID, transactionType, fromUserID, toUserID, money, fee)
+}
+
+// decreaseMoney is DecreaseMoneyFromUser with its own idempotency key, for transfers which post several
+// entries of the same type on one shard.
+func (s *UserService) decreaseMoney(
+	ctx context.Context,
+	idempotenceID,
+	transactionID string,
+	transactionType models.TransactionType,
+	fromUserID,
+	toUserID int64,
+	money models.Money,
+	fee models.Fee,
 ) error {
 	// Check for negative amount
-	if amount < 0 {
+	if money.Amount < 0 || fee.Amount < 0 {
 		return fmt.Errorf("amount cannot be negative")
 	}
+	if !money.Currency.Valid() {
+		return apperrors.ErrInvalidCurrency
+	}
 
 	_, shardID, _ := id.ParseUserID(fromUserID)
 	userDB, ok := s.ShardManager.UserShards[shardID]
@@ -151,14 +278,17 @@ func (s *UserService) DecreaseMoneyFromUser(
 		return fmt.Errorf("user shard %d not found", shardID)
 	}
 
+	ctx = logger.WithFields(ctx, logger.TransferID(transactionID), logger.UserID(fromUserID), logger.ShardID(shardID))
+	replayed := false
 	now := time.Now().UTC()
 	err := shard.WithTransaction(ctx, userDB, func(tx pgx.Tx) error {
 		// check idempotentency key
 		const query = `INSERT INTO idempotence (id, type, created_at) VALUES ($1, $2, $3)`
-		_, err := tx.Exec(ctx, query, transactionID, transactionType, now)
+		_, err := tx.Exec(ctx, query, idempotenceID, transactionType, now)
 		if err != nil {
 			if pgErr, ok := lo.ErrorsAs[*pgconn.PgError](err); ok {
 				if pgErr.Code == pgerrcode.UniqueViolation {
+					replayed = true
 					return nil
 				}
 			}
@@ -166,63 +296,105 @@ func (s *UserService) DecreaseMoneyFromUser(
 		}
 
 		// select user to check balance and blocked status
-		var userIDFromDB uint64
-		var balance int64
-		var isBlocked bool
-		const selectUser = `SELECT id, balance, is_blocked FROM users WHERE id = $1 FOR UPDATE`
-		err = tx.QueryRow(ctx, selectUser, fromUserID).Scan(&userIDFromDB, &balance, &isBlocked)
+		account, err := lockAccount(ctx, tx, fromUserID, money.Currency)
 		if err != nil {
-			return fmt.Errorf("failed to select user: %w", err)
+			return err
 		}
 
-		if isBlocked {
+		if account.isBlocked && !slices.Contains(models.BlockOverrideTransactionTypes, transactionType) {
 			return apperrors.ErrUserIsBlocked
 		}
 
-		if balance < amount {
-			return fmt.Errorf("balance lower than amount of user")
+		// a reversed credit is taken back as far as the recipient's money and credit line allow,
+		// ReverseSplitMoney leaves the rest to the suspense account
+		if transactionType == models.TransactionTypeCreditReversal && !account.isSystem {
+			money.Amount = min(money.Amount, max(account.available, 0))
+		}
+
+		// system accounts are the source of money, so they may go below zero
+		if !account.isSystem && account.available < money.Amount+fee.Amount {
+			return apperrors.ErrInsufficientFunds
+		}
+
+		// limits restrict transfers only, not the internal movements like fee reversals
+		if transactionType == models.TransactionTypeDecrease && !account.isSystem {
+			err = s.checkTransferLimits(ctx, tx, fromUserID, money, "", now)
+			if err != nil {
+				return err
+			}
 		}
 
 		// decrease user money
-		const decreaseMoney = `UPDATE users SET balance = balance - $1 WHERE id = $2`
-		rows, err := tx.Exec(ctx, decreaseMoney, amount, fromUserID)
+		balanceAfter, err := changeBalance(ctx, tx, fromUserID, money.Currency, -(money.Amount + fee.Amount), now)
 		if err != nil {
-			return fmt.Errorf("failed to update balance: %w", err)
-		}
-		if rows.RowsAffected() != 1 {
-			return fmt.Errorf("failed to update balance: expected 1 row affected, got %d", rows.RowsAffected())
+			return err
 		}
 
 		// add transaction history
-		const addHistoryQuery = `INSERT INTO transaction 
-    							 (id, from_id, to_id, amount, created_at) 
-								 VALUES ($1, $2, $3, $4, $5)`
-		_, err = tx.Exec(ctx, addHistoryQuery, uuid.NewString(), fromUserID, toUserID, amount, now)
+		err = s.insertDebitTransactions(ctx, tx, transactionID, transactionType, fromUserID, toUserID, money, fee,
+			balanceAfter, now)
 		if err != nil {
-			return fmt.Errorf("failed to insert transaction history: %w", err)
+			return err
+		}
+
+		// the reversed credit lives on the same shard, since the reversal takes the money from the recipient
+		if transactionType == models.TransactionTypeCreditReversal {
+			const markReversed = `UPDATE transaction SET status = $1
+								  WHERE transfer_id = $2 AND type = $3 AND to_id = $4`
+			_, err = tx.Exec(ctx, markReversed, models.TransactionStatusCompensated, transactionID,
+				models.TransactionTypeIncrease, fromUserID)
+			if err != nil {
+				return fmt.Errorf("failed to mark credit as compensated: %w", err)
+			}
 		}
 
 		return nil
 	})
+	log := logger.FromContext(ctx).With(zap.String("type", string(transactionType)))
 	if err != nil {
+		log.Warn("failed to decrease money", zap.Error(err))
 		return err
 	}
+	if replayed {
+		log.Debug("money already decreased")
+		return nil
+	}
 
+	log.Info("money decreased", zap.Int64("amount", money.Amount), zap.Int64("fee", fee.Amount),
+		zap.String("currency", string(money.Currency)))
 	return nil
 }
 
+// IncreaseMoneyToUser credits money to the user's account in money.Currency.
 func (s *UserService) IncreaseMoneyToUser(
 	ctx context.Context,
 	transactionID string,
 	transactionType models.TransactionType,
 	fromUserID,
 	toUserID int64,
-	amount int64,
+	money models.Money,
+) error {
+	ctx = metrics.WithMethod(ctx, "IncreaseMoneyToUser")
+	return s.increaseMoney(ctx, transactionID, transactionID, transactionType, fromUserID, toUserID, money)
+}
+
+// increaseMoney is IncreaseMoneyToUser with its own idempotency key, see decreaseMoney.
+func (s *UserService) increaseMoney(
+	ctx context.Context,
+	idempotenceID,
+	transactionID string,
+	transactionType models.TransactionType,
+	fromUserID,
+	toUserID int64,
+	money models.Money,
 ) error {
 	// Check for negative amount
-	if amount < 0 {
+	if money.Amount < 0 {
 		return fmt.Errorf("amount cannot be negative")
 	}
+	if !money.Currency.Valid() {
+		return apperrors.ErrInvalidCurrency
+	}
 
 	_, shardID, _ := id.ParseUserID(toUserID)
 	userDB, ok := s.ShardManager.UserShards[shardID]
@@ -230,60 +402,112 @@ func (s *UserService) IncreaseMoneyToUser(
 		return fmt.Errorf("user shard %d not found", shardID)
 	}
 
+	ctx = logger.WithFields(ctx, logger.TransferID(transactionID), logger.UserID(toUserID), logger.ShardID(shardID))
+	replayed := false
 	now := time.Now().UTC()
 	err := shard.WithTransaction(ctx, userDB, func(tx pgx.Tx) error {
 		// check idempotentency key
 		const query = `INSERT INTO idempotence (id, type, created_at) VALUES ($1, $2, $3)`
-		_, err := tx.Exec(ctx, query, transactionID, transactionType, now)
+		_, err := tx.Exec(ctx, query, idempotenceID, transactionType, now)
 		if err != nil {
 			if pgErr, ok := lo.ErrorsAs[*pgconn.PgError](err); ok {
 				if pgErr.Code == pgerrcode.UniqueViolation {
+					replayed = true
 					return nil
 				}
 			}
 			return fmt.Errorf("failed to insert idempotetency: %w", err)
 		}
 
-		// select user to check balance
-		var isBlocked bool
-		const selectUser = `SELECT is_blocked FROM users WHERE id = $1 FOR UPDATE`
-		err = tx.QueryRow(ctx, selectUser, toUserID).Scan(&isBlocked)
+		// select user to check blocked status
+		account, err := lockAccount(ctx, tx, toUserID, money.Currency)
 		if err != nil {
-			return fmt.Errorf("failed to select user: %w", err)
+			return err
 		}
 
-		if isBlocked {
+		if account.isBlocked && !slices.Contains(models.BlockOverrideTransactionTypes, transactionType) {
 			return apperrors.ErrUserIsBlocked
 		}
 
 		// increase user money
-		const increaseMoney = `UPDATE users SET balance = balance + $1 WHERE id = $2`
-		rows, err := tx.Exec(ctx, increaseMoney, amount, toUserID)
+		balanceAfter, err := changeBalance(ctx, tx, toUserID, money.Currency, money.Amount, now)
 		if err != nil {
-			return fmt.Errorf("failed to update balance: %w", err)
-		}
-		if rows.RowsAffected() != 1 {
-			return fmt.Errorf("failed to update balance: expected 1 row affected, got %d", rows.RowsAffected())
+			return err
 		}
 
 		// add transaction history
-		const addHistoryQuery = `INSERT INTO transaction
-    							 (id, from_id, to_id, amount, created_at) 
-								 VALUES ($1, $2, $3, $4, $5)`
-		_, err = tx.Exec(ctx, addHistoryQuery, uuid.NewString(), fromUserID, toUserID, amount, now)
+		err = s.insertTransaction(ctx, tx, transactionID, transactionType, fromUserID, toUserID, money,
+			balanceAfter, now)
 		if err != nil {
-			return fmt.Errorf("failed to insert transaction history: %w", err)
+			return err
+		}
+
+		if slices.Contains(models.CompletingTransactionTypes, transactionType) {
+			event := models.TransferCompletedEvent{
+				TransferID: transactionID,
+				FromID:     fromUserID,
+				ToID:       toUserID,
+				Amount:     money.Amount,
+				Currency:   money.Currency,
+			}
+			err = insertEvent(ctx, tx, toUserID, models.EventTypeTransferCompleted, event, now)
+			if err != nil {
+				return err
+			}
+		}
+		if slices.Contains(models.CompensatingTransactionTypes, transactionType) {
+			event := models.TransferCompensatedEvent{
+				TransferID: transactionID,
+				FromID:     toUserID,
+				ToID:       fromUserID,
+				Amount:     money.Amount,
+				Currency:   money.Currency,
+			}
+			err = insertEvent(ctx, tx, toUserID, models.EventTypeTransferCompensated, event, now)
+			if err != nil {
+				return err
+			}
+		}
+
+		// the compensated debit lives on the same shard, since compensation returns money to the sender
+		if slices.Contains(models.CompensatingTransactionTypes, transactionType) {
+			const markCompensated = `UPDATE transaction SET status = $1
+									 WHERE transfer_id = $2 AND type = ANY($3) AND from_id = $4`
+			_, err = tx.Exec(ctx, markCompensated, models.TransactionStatusCompensated, transactionID,
+				typesToStrings(models.CompensatedTransactionTypes), toUserID)
+			if err != nil {
+				return fmt.Errorf("failed to mark debit as compensated: %w", err)
+			}
+
+			// the compensated refund doesn't count against the refunded transfer anymore
+			const markRefundCompensated = `UPDATE refunds SET status = $1, updated_at = $2 WHERE id = $3 AND from_id = $4`
+			_, err = tx.Exec(ctx, markRefundCompensated, models.TransactionStatusCompensated, now, transactionID, toUserID)
+			if err != nil {
+				return fmt.Errorf("failed to mark refund as compensated: %w", err)
+			}
 		}
 
 		return nil
 	})
+	log := logger.FromContext(ctx).With(zap.String("type", string(transactionType)))
 	if err != nil {
+		log.Warn("failed to increase money", zap.Error(err))
 		return err
 	}
+	if replayed {
+		log.Debug("money already increased")
+		return nil
+	}
 
+	log.Info("money increased", zap.Int64("amount", money.Amount), zap.String("currency", string(money.Currency)))
 	return nil
 }
 
+// CalculateTransferFee returns the fee the sender pays on top of the amount, in the same currency.
+func (s *UserService) CalculateTransferFee(fromUserID, toUserID int64, money models.Money) models.Fee {
+	return s.fees.Calculate(fromUserID, toUserID, money.Amount)
+}
+
 func (s *UserService) GetShardManager() *shard.ShardManager {
 	return s.ShardManager
 }
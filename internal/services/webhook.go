@@ -0,0 +1,313 @@
+package services
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"net/url"
+	"slices"
+	"time"
+	"usershards/internal/apperrors"
+	"usershards/internal/id"
+	"usershards/internal/metrics"
+	"usershards/internal/models"
+)
+
+const defaultWebhookTimeout = 10 * time.Second
+const defaultWebhookMaxAttempts = 10
+
+// RegisterWebhook adds the endpoint which gets the events of eventTypes, all webhook events if empty.
+// The returned webhook has the secret the deliveries are signed with.
+func (s *UserService) RegisterWebhook(
+	ctx context.Context,
+	endpoint string,
+	eventTypes []models.EventType,
+) (*models.Webhook, error) {
+	ctx = metrics.WithMethod(ctx, "RegisterWebhook")
+	parsed, err := url.Parse(endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, apperrors.ErrInvalidWebhook
+	}
+	for _, eventType := range eventTypes {
+		if !slices.Contains(models.WebhookEventTypes, eventType) {
+			return nil, apperrors.ErrInvalidWebhook
+		}
+	}
+
+	webhookID, err := uuid.NewV7()
+	if err != nil {
+		return nil, err
+	}
+	secret := make([]byte, 32)
+	if _, err = rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate webhook secret: %w", err)
+	}
+
+	webhook := &models.Webhook{
+		ID:         webhookID.String(),
+		URL:        endpoint,
+		Secret:     hex.EncodeToString(secret),
+		EventTypes: eventTypes,
+		CreatedAt:  time.Now().UTC(),
+	}
+
+	webhooksDB, err := s.webhooksDB()
+	if err != nil {
+		return nil, err
+	}
+
+	const query = `INSERT INTO webhooks (id, url, secret, event_types, created_at) VALUES ($1, $2, $3, $4, $5)`
+	_, err = webhooksDB.Exec(ctx, query, webhook.ID, webhook.URL, webhook.Secret,
+		eventTypesToStrings(webhook.EventTypes), webhook.CreatedAt)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert webhook: %w", err)
+	}
+
+	return webhook, nil
+}
+
+// DeleteWebhook stops the deliveries to the webhook, the pending ones fail.
+func (s *UserService) DeleteWebhook(ctx context.Context, webhookID string) error {
+	ctx = metrics.WithMethod(ctx, "DeleteWebhook")
+	webhooksDB, err := s.webhooksDB()
+	if err != nil {
+		return err
+	}
+
+	rows, err := webhooksDB.Exec(ctx, `DELETE FROM webhooks WHERE id = $1`, webhookID)
+	if err != nil {
+		return fmt.Errorf("failed to delete webhook: %w", err)
+	}
+	if rows.RowsAffected() == 0 {
+		return apperrors.ErrWebhookNotFound
+	}
+
+	return nil
+}
+
+func (s *UserService) GetWebhook(ctx context.Context, webhookID string) (*models.Webhook, error) {
+	ctx = metrics.WithMethod(ctx, "GetWebhook")
+	webhooksDB, err := s.webhooksDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := webhooksDB.Query(ctx, `SELECT `+webhookColumns+` FROM webhooks WHERE id = $1`, webhookID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select webhook: %w", err)
+	}
+	webhook, err := pgx.CollectExactlyOneRow(rows, scanWebhook)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperrors.ErrWebhookNotFound
+		}
+		return nil, fmt.Errorf("failed to read webhook: %w", err)
+	}
+
+	return &webhook, nil
+}
+
+func (s *UserService) ListWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	ctx = metrics.WithMethod(ctx, "ListWebhooks")
+	webhooksDB, err := s.webhooksDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := webhooksDB.Query(ctx, `SELECT `+webhookColumns+` FROM webhooks ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select webhooks: %w", err)
+	}
+	webhooks, err := pgx.CollectRows(rows, scanWebhook)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhooks: %w", err)
+	}
+
+	return webhooks, nil
+}
+
+// CreateWebhookDelivery logs the pending delivery. Calling it again with the same delivery id
+// returns the logged delivery as it is now.
+func (s *UserService) CreateWebhookDelivery(
+	ctx context.Context,
+	delivery models.WebhookDelivery,
+) (*models.WebhookDelivery, error) {
+	ctx = metrics.WithMethod(ctx, "CreateWebhookDelivery")
+	webhooksDB, err := s.webhooksDB()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	const query = `INSERT INTO webhook_deliveries (id, webhook_id, event_type, payload, status, created_at, updated_at)
+				   VALUES ($1, $2, $3, $4, $5, $6, $6)
+				   ON CONFLICT (id) DO NOTHING`
+	_, err = webhooksDB.Exec(ctx, query, delivery.ID, delivery.WebhookID, delivery.EventType, delivery.Payload,
+		models.WebhookDeliveryStatusPending, now)
+	if err != nil {
+		return nil, fmt.Errorf("failed to insert webhook delivery: %w", err)
+	}
+
+	return s.GetWebhookDelivery(ctx, delivery.ID)
+}
+
+func (s *UserService) GetWebhookDelivery(ctx context.Context, deliveryID string) (*models.WebhookDelivery, error) {
+	ctx = metrics.WithMethod(ctx, "GetWebhookDelivery")
+	webhooksDB, err := s.webhooksDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := webhooksDB.Query(ctx, `SELECT `+deliveryColumns+` FROM webhook_deliveries WHERE id = $1`, deliveryID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select webhook delivery: %w", err)
+	}
+	delivery, err := pgx.CollectExactlyOneRow(rows, scanDelivery)
+	if err != nil {
+		if errors.Is(err, pgx.ErrNoRows) {
+			return nil, apperrors.ErrDeliveryNotFound
+		}
+		return nil, fmt.Errorf("failed to read webhook delivery: %w", err)
+	}
+
+	return &delivery, nil
+}
+
+// ListWebhookDeliveries returns the delivery log of the webhook, newest first.
+func (s *UserService) ListWebhookDeliveries(
+	ctx context.Context,
+	webhookID string,
+	limit int,
+) ([]models.WebhookDelivery, error) {
+	ctx = metrics.WithMethod(ctx, "ListWebhookDeliveries")
+	webhooksDB, err := s.webhooksDB()
+	if err != nil {
+		return nil, err
+	}
+
+	if limit <= 0 {
+		limit = defaultTransactionsLimit
+	}
+
+	const query = `SELECT ` + deliveryColumns + ` FROM webhook_deliveries WHERE webhook_id = $1
+				   ORDER BY created_at DESC, id DESC LIMIT $2`
+	rows, err := webhooksDB.Query(ctx, query, webhookID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select webhook deliveries: %w", err)
+	}
+	deliveries, err := pgx.CollectRows(rows, scanDelivery)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read webhook deliveries: %w", err)
+	}
+
+	return deliveries, nil
+}
+
+// RecordWebhookAttempt logs one attempt of the delivery, responseCode is 0 if there was no response.
+func (s *UserService) RecordWebhookAttempt(ctx context.Context, deliveryID string, responseCode int, lastError string) error {
+	ctx = metrics.WithMethod(ctx, "RecordWebhookAttempt")
+	webhooksDB, err := s.webhooksDB()
+	if err != nil {
+		return err
+	}
+
+	const query = `UPDATE webhook_deliveries SET attempts = attempts + 1, response_code = $1, last_error = $2,
+				   updated_at = $3 WHERE id = $4`
+	_, err = webhooksDB.Exec(ctx, query, responseCode, lastError, time.Now().UTC(), deliveryID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+
+	return nil
+}
+
+// SetWebhookDeliveryStatus finishes the delivery, or makes it pending again for a redelivery.
+func (s *UserService) SetWebhookDeliveryStatus(
+	ctx context.Context,
+	deliveryID string,
+	status models.WebhookDeliveryStatus,
+) error {
+	ctx = metrics.WithMethod(ctx, "SetWebhookDeliveryStatus")
+	webhooksDB, err := s.webhooksDB()
+	if err != nil {
+		return err
+	}
+
+	const query = `UPDATE webhook_deliveries SET status = $1, updated_at = $2 WHERE id = $3`
+	rows, err := webhooksDB.Exec(ctx, query, status, time.Now().UTC(), deliveryID)
+	if err != nil {
+		return fmt.Errorf("failed to update webhook delivery: %w", err)
+	}
+	if rows.RowsAffected() == 0 {
+		return apperrors.ErrDeliveryNotFound
+	}
+
+	return nil
+}
+
+// WebhookTimeout is how long one delivery attempt waits for the response.
+func (s *UserService) WebhookTimeout() time.Duration {
+	if s.webhooks.Timeout <= 0 {
+		return defaultWebhookTimeout
+	}
+	return s.webhooks.Timeout
+}
+
+// WebhookMaxAttempts is how many times a delivery is attempted before it fails.
+func (s *UserService) WebhookMaxAttempts() int {
+	if s.webhooks.MaxAttempts <= 0 {
+		return defaultWebhookMaxAttempts
+	}
+	return s.webhooks.MaxAttempts
+}
+
+// webhooksDB is the shard of the webhooks and their deliveries, they live with the treasury.
+func (s *UserService) webhooksDB() (*pgxpool.Pool, error) {
+	_, shardID, _ := id.ParseUserID(s.SystemAccount(models.SystemAccountTreasury))
+	webhooksDB, ok := s.ShardManager.UserShards[shardID]
+	if !ok {
+		return nil, fmt.Errorf("user shard %d not found", shardID)
+	}
+	return webhooksDB, nil
+}
+
+const webhookColumns = `id, url, secret, event_types, created_at`
+
+func scanWebhook(row pgx.CollectableRow) (models.Webhook, error) {
+	var webhook models.Webhook
+	var eventTypes []string
+	err := row.Scan(&webhook.ID, &webhook.URL, &webhook.Secret, &eventTypes, &webhook.CreatedAt)
+	if err != nil {
+		return webhook, fmt.Errorf("failed to scan webhook: %w", err)
+	}
+	for _, eventType := range eventTypes {
+		webhook.EventTypes = append(webhook.EventTypes, models.EventType(eventType))
+	}
+	return webhook, nil
+}
+
+const deliveryColumns = `id, webhook_id, event_type, payload, status, attempts, response_code, last_error,
+						 created_at, updated_at`
+
+func scanDelivery(row pgx.CollectableRow) (models.WebhookDelivery, error) {
+	var delivery models.WebhookDelivery
+	err := row.Scan(&delivery.ID, &delivery.WebhookID, &delivery.EventType, &delivery.Payload, &delivery.Status,
+		&delivery.Attempts, &delivery.ResponseCode, &delivery.LastError, &delivery.CreatedAt, &delivery.UpdatedAt)
+	if err != nil {
+		return delivery, fmt.Errorf("failed to scan webhook delivery: %w", err)
+	}
+	return delivery, nil
+}
+
+func eventTypesToStrings(types []models.EventType) []string {
+	res := make([]string, 0, len(types))
+	for _, t := range types {
+		res = append(res, string(t))
+	}
+	return res
+}
@@ -4,10 +4,14 @@ import (
 	"context"
 	"fmt"
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/multitracer"
 	"github.com/jackc/pgx/v5/pgxpool"
 	"hash/crc32"
+	"time"
 	"usershards/internal/config"
 	"usershards/internal/logger"
+	"usershards/internal/metrics"
+	"usershards/internal/tracing"
 	"usershards/migrations/emails"
 	"usershards/migrations/users"
 )
@@ -42,6 +46,18 @@ func WithTransaction(ctx context.Context, db *pgxpool.Pool, fn TxFunc) error {
 	return nil
 }
 
+// UserMigrations миграции user-shards по порядку, версия шарда - число примененных миграций
+var UserMigrations = []string{users.Migration1, users.Migration2, users.Migration3, users.Migration4,
+	users.Migration5, users.Migration6, users.Migration7, users.Migration8, users.Migration9,
+	users.Migration10, users.Migration11, users.Migration12, users.Migration13,
+	users.Migration14, users.Migration15, users.Migration16,
+	users.Migration17, users.Migration18, users.Migration19,
+	users.Migration20, users.Migration21, users.Migration22, users.Migration23,
+	users.Migration24}
+
+// EmailMigrations миграции email-shards по порядку
+var EmailMigrations = []string{emails.Migration1, emails.Migration2}
+
 type ShardManager struct {
 	UserShards  map[int]*pgxpool.Pool
 	EmailShards map[int]*pgxpool.Pool
@@ -56,7 +72,7 @@ func NewShardManager(ctx context.Context, config *config.Config) (*ShardManager,
 
 	// Инициализация user-shards
 	for shardID, connStr := range config.DB.UserShards {
-		conn, err := pgxpool.New(ctx, connStr)
+		conn, err := newPool(ctx, connStr, "users", shardID)
 		if err != nil {
 			return nil, fmt.Errorf("failed to connect to user shard %d: %w", shardID, err)
 		}
@@ -66,7 +82,7 @@ func NewShardManager(ctx context.Context, config *config.Config) (*ShardManager,
 			return nil, fmt.Errorf("failed to ping user shard %d: %w", shardID, err)
 		}
 
-		err = RunMigrations(conn, users.Migration1, users.Migration2, users.Migration3)
+		err = RunMigrations(conn, UserMigrations...)
 		if err != nil {
 			return nil, fmt.Errorf("failed to run migrations: %w", err)
 		}
@@ -76,7 +92,7 @@ func NewShardManager(ctx context.Context, config *config.Config) (*ShardManager,
 
 	// Инициализация email-shards
 	for shardID, connStr := range config.DB.EmailShards {
-		conn, err := pgxpool.New(ctx, connStr)
+		conn, err := newPool(ctx, connStr, "emails", shardID)
 		if err != nil {
 			return nil, fmt.Errorf("failed to connect to email shard %d: %w", shardID, err)
 		}
@@ -86,7 +102,7 @@ func NewShardManager(ctx context.Context, config *config.Config) (*ShardManager,
 			return nil, fmt.Errorf("failed to ping email shard %d: %w", shardID, err)
 		}
 
-		err = RunMigrations(conn, emails.Migration1, emails.Migration2)
+		err = RunMigrations(conn, EmailMigrations...)
 		if err != nil {
 			return nil, fmt.Errorf("failed to run migrations: %w", err)
 		}
@@ -96,6 +112,23 @@ func NewShardManager(ctx context.Context, config *config.Config) (*ShardManager,
 	return sm, nil
 }
 
+// newPool подключается к шарду, запросы и пул шарда попадают в метрики, а запросы еще и в трейсы
+func newPool(ctx context.Context, connStr, db string, shardID int) (*pgxpool.Pool, error) {
+	poolConfig, err := pgxpool.ParseConfig(connStr)
+	if err != nil {
+		return nil, err
+	}
+	poolConfig.ConnConfig.Tracer = multitracer.New(metrics.NewQueryTracer(db, shardID), tracing.NewQueryTracer(db, shardID))
+
+	conn, err := pgxpool.NewWithConfig(ctx, poolConfig)
+	if err != nil {
+		return nil, err
+	}
+	metrics.RegisterPool(db, shardID, conn)
+
+	return conn, nil
+}
+
 // Close закрывает все соединения с шардированными базами данных
 func (sm *ShardManager) Close() {
 	// Закрываем соединения для user-shards
@@ -126,25 +159,89 @@ func (sm *ShardManager) HashEmail(email string) int {
 	return int(hash) % len(sm.EmailShards)
 }
 
-// RunMigrations выполняет SQL-скрипты миграции
+// RunMigrations выполняет SQL-скрипты миграции, которых нет в schema_migrations, и записывает их версии
 func RunMigrations(conn *pgxpool.Pool, migrations ...string) error {
-	for _, migration := range migrations {
-		_, err := conn.Exec(context.Background(), migration)
+	ctx := context.Background()
+	const createVersions = `CREATE TABLE IF NOT EXISTS schema_migrations (
+								version    INTEGER PRIMARY KEY,
+								applied_at TIMESTAMPTZ NOT NULL
+							)`
+	_, err := conn.Exec(ctx, createVersions)
+	if err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	for i, migration := range migrations {
+		version := i + 1
+		// the migration and its version are saved together, so a recorded migration is never run again
+		err = WithTransaction(ctx, conn, func(tx pgx.Tx) error {
+			var applied bool
+			const selectVersion = `SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)`
+			err := tx.QueryRow(ctx, selectVersion, version).Scan(&applied)
+			if err != nil {
+				return fmt.Errorf("failed to select migration version: %w", err)
+			}
+			if applied {
+				return nil
+			}
+
+			_, err = tx.Exec(ctx, migration)
+			if err != nil {
+				return fmt.Errorf("ошибка выполнения миграции: %w", err)
+			}
+
+			const insertVersion = `INSERT INTO schema_migrations (version, applied_at) VALUES ($1, $2)`
+			_, err = tx.Exec(ctx, insertVersion, version, time.Now().UTC())
+			if err != nil {
+				return fmt.Errorf("failed to insert migration version: %w", err)
+			}
+
+			return nil
+		})
 		if err != nil {
-			return fmt.Errorf("ошибка выполнения миграции: %w", err)
+			return err
 		}
 	}
 
-	logger.Logger.Infof("migration successfuly executed")
+	logger.L().Info("migration successfuly executed")
 	return nil
 }
 
+// MigrationVersion возвращает версию схемы шарда, 0 - миграции не применялись
+func MigrationVersion(ctx context.Context, conn *pgxpool.Pool) (int, error) {
+	var version int
+	const query = `SELECT COALESCE(MAX(version), 0) FROM schema_migrations`
+	err := conn.QueryRow(ctx, query).Scan(&version)
+	if err != nil {
+		return 0, fmt.Errorf("failed to select migration version: %w", err)
+	}
+
+	return version, nil
+}
+
 // ClearDatabases очищает все данные в шардах
 func (sm *ShardManager) ClearDatabases(ctx context.Context) error {
 	queries := []string{
 		"DELETE FROM users",
 		"DELETE FROM idempotence",
 		"DELETE FROM transaction",
+		"DELETE FROM holds",
+		"DELETE FROM user_limits",
+		"DELETE FROM accounts",
+		"DELETE FROM fx_quotes",
+		"DELETE FROM campaign_budgets",
+		"DELETE FROM campaign_grants",
+		"DELETE FROM payments",
+		"DELETE FROM schedules",
+		"DELETE FROM refunds",
+		"DELETE FROM outbox",
+		"DELETE FROM webhooks",
+		"DELETE FROM webhook_deliveries",
+		"DELETE FROM cdc_checkpoints",
+		"DELETE FROM account_events",
+		"DELETE FROM account_snapshots",
+		"DELETE FROM batch_items",
+		"DELETE FROM settlements",
 	}
 
 	for _, conn := range sm.UserShards {
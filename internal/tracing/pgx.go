@@ -0,0 +1,65 @@
+package tracing
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+	"strings"
+)
+
+type querySpanKey struct{}
+
+// QueryTracer makes a span for every query of one shard's pool.
+type QueryTracer struct {
+	db      string
+	shardID int
+}
+
+func NewQueryTracer(db string, shardID int) *QueryTracer {
+	return &QueryTracer{db: db, shardID: shardID}
+}
+
+// TraceQueryStart starts the span of the query within the caller's trace. The queries out of any trace,
+// like the polling of the outbox relay, are not traced.
+func (t *QueryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return ctx
+	}
+
+	ctx, span := Tracer().Start(ctx, t.db+" "+queryVerb(data.SQL),
+		trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(
+			attribute.String("db.system", "postgresql"),
+			attribute.String("db.name", t.db),
+			attribute.String("db.statement", data.SQL),
+			ShardKey.Int(t.shardID),
+		))
+	// the span is kept by its own key, so the end of an untraced query doesn't end the caller's span
+	return context.WithValue(ctx, querySpanKey{}, span)
+}
+
+func (t *QueryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span, ok := ctx.Value(querySpanKey{}).(trace.Span)
+	if !ok {
+		return
+	}
+
+	if data.Err != nil {
+		span.RecordError(data.Err)
+		span.SetStatus(codes.Error, data.Err.Error())
+	} else {
+		span.SetAttributes(attribute.Int64("db.rows_affected", data.CommandTag.RowsAffected()))
+	}
+	span.End()
+}
+
+// queryVerb is the first word of the query, it names the span since the queries themselves are too long.
+func queryVerb(sql string) string {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return "query"
+	}
+	return strings.ToUpper(fields[0])
+}
@@ -0,0 +1,105 @@
+package tracing
+
+import (
+	"context"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"go.temporal.io/sdk/client"
+	"go.temporal.io/sdk/contrib/opentelemetry"
+	"go.temporal.io/sdk/interceptor"
+	"go.temporal.io/sdk/workflow"
+)
+
+// spanContextKey is where the Temporal tracing interceptor keeps the span of a workflow in its context.
+type spanContextKey struct{}
+
+// ClientInterceptors trace the workflows from ExecuteWorkflow to the activities, the span of the caller's
+// context is the parent. The trace context goes to the workflows and activities in the Temporal headers,
+// the workers of the client get the interceptors too.
+func ClientInterceptors() ([]interceptor.ClientInterceptor, error) {
+	tracingInterceptor, err := opentelemetry.NewTracingInterceptor(opentelemetry.TracerOptions{
+		SpanContextKey: spanContextKey{},
+		SpanStarter: func(ctx context.Context, _ trace.Tracer, spanName string, opts ...trace.SpanStartOption) trace.Span {
+			_, span := Tracer().Start(ctx, spanName, opts...)
+			return span
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// the attributes are set on the spans of the tracing interceptor, so it has to wrap this one
+	return []interceptor.ClientInterceptor{tracingInterceptor, &attributesInterceptor{}}, nil
+}
+
+// attributesInterceptor tags the spans of the workflows and activities by their params which are Attributer.
+type attributesInterceptor struct {
+	interceptor.InterceptorBase
+}
+
+func (a *attributesInterceptor) InterceptClient(next interceptor.ClientOutboundInterceptor) interceptor.ClientOutboundInterceptor {
+	i := &clientOutbound{}
+	i.Next = next
+	return i
+}
+
+func (a *attributesInterceptor) InterceptActivity(
+	ctx context.Context,
+	next interceptor.ActivityInboundInterceptor,
+) interceptor.ActivityInboundInterceptor {
+	i := &activityInbound{}
+	i.Next = next
+	return i
+}
+
+func (a *attributesInterceptor) InterceptWorkflow(
+	ctx workflow.Context,
+	next interceptor.WorkflowInboundInterceptor,
+) interceptor.WorkflowInboundInterceptor {
+	i := &workflowInbound{}
+	i.Next = next
+	return i
+}
+
+type clientOutbound struct {
+	interceptor.ClientOutboundInterceptorBase
+}
+
+func (c *clientOutbound) ExecuteWorkflow(
+	ctx context.Context,
+	in *interceptor.ClientExecuteWorkflowInput,
+) (client.WorkflowRun, error) {
+	trace.SpanFromContext(ctx).SetAttributes(argsAttributes(in.Args)...)
+	return c.Next.ExecuteWorkflow(ctx, in)
+}
+
+type activityInbound struct {
+	interceptor.ActivityInboundInterceptorBase
+}
+
+func (a *activityInbound) ExecuteActivity(ctx context.Context, in *interceptor.ExecuteActivityInput) (interface{}, error) {
+	trace.SpanFromContext(ctx).SetAttributes(argsAttributes(in.Args)...)
+	return a.Next.ExecuteActivity(ctx, in)
+}
+
+type workflowInbound struct {
+	interceptor.WorkflowInboundInterceptorBase
+}
+
+func (w *workflowInbound) ExecuteWorkflow(ctx workflow.Context, in *interceptor.ExecuteWorkflowInput) (interface{}, error) {
+	// the workflow span is not in a Go context, the tracing interceptor keeps it by its own key
+	if span, ok := ctx.Value(spanContextKey{}).(trace.Span); ok {
+		span.SetAttributes(argsAttributes(in.Args)...)
+	}
+	return w.Next.ExecuteWorkflow(ctx, in)
+}
+
+func argsAttributes(args []interface{}) []attribute.KeyValue {
+	var attrs []attribute.KeyValue
+	for _, arg := range args {
+		if attributer, ok := arg.(Attributer); ok {
+			attrs = append(attrs, attributer.SpanAttributes()...)
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,123 @@
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+	"os"
+	"usershards/internal/config"
+	"usershards/internal/id"
+)
+
+const ServiceName = "usershards"
+
+const ExporterNone = ""
+const ExporterOTLP = "otlp"
+const ExporterFile = "file"
+
+// The attributes the spans are tagged with, so the spans of one user or transfer are found across the shards.
+const (
+	TransferIDKey = attribute.Key("usershards.transfer_id")
+	UserIDKey     = attribute.Key("usershards.user_id")
+	FromUserIDKey = attribute.Key("usershards.from_user_id")
+	ToUserIDKey   = attribute.Key("usershards.to_user_id")
+	ShardKey      = attribute.Key("usershards.shard")
+	FromShardKey  = attribute.Key("usershards.from_shard")
+	ToShardKey    = attribute.Key("usershards.to_shard")
+)
+
+// Attributer is implemented by the workflow and activity params which know their users and transfer.
+type Attributer interface {
+	SpanAttributes() []attribute.KeyValue
+}
+
+// Tracer is the tracer of the service. It is taken from the global provider on every call,
+// so the spans go to the provider installed last.
+func Tracer() trace.Tracer {
+	return otel.Tracer(ServiceName)
+}
+
+// Init installs the exporter from the config as the global tracer provider. The returned function
+// flushes the spans left and stops the provider. Without an exporter the spans are not recorded.
+func Init(ctx context.Context, conf config.Tracing) (func(context.Context) error, error) {
+	var exporter sdktrace.SpanExporter
+	switch conf.Exporter {
+	case ExporterNone:
+		return func(context.Context) error { return nil }, nil
+	case ExporterOTLP:
+		options := []otlptracehttp.Option{otlptracehttp.WithEndpoint(conf.Endpoint)}
+		if conf.Insecure {
+			options = append(options, otlptracehttp.WithInsecure())
+		}
+		otlpExporter, err := otlptracehttp.New(ctx, options...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create otlp exporter: %w", err)
+		}
+		exporter = otlpExporter
+	case ExporterFile:
+		out := os.Stdout
+		if conf.File != "" && conf.File != "-" {
+			file, err := os.OpenFile(conf.File, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o644)
+			if err != nil {
+				return nil, fmt.Errorf("failed to open tracing file: %w", err)
+			}
+			out = file
+		}
+		fileExporter, err := stdouttrace.New(stdouttrace.WithWriter(out))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create file exporter: %w", err)
+		}
+		exporter = fileExporter
+	default:
+		return nil, fmt.Errorf("unknown tracing exporter %s", conf.Exporter)
+	}
+
+	provider := Install(exporter, conf.SampleRatio)
+	return provider.Shutdown, nil
+}
+
+// Install sets the global provider to one which batches the spans to the exporter. sampleRatio is the share
+// of the new traces which are recorded, 0 means all; the traces started by a caller follow its decision.
+func Install(exporter sdktrace.SpanExporter, sampleRatio float64) *sdktrace.TracerProvider {
+	sampler := sdktrace.AlwaysSample()
+	if sampleRatio > 0 && sampleRatio < 1 {
+		sampler = sdktrace.TraceIDRatioBased(sampleRatio)
+	}
+
+	provider := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithSampler(sdktrace.ParentBased(sampler)),
+		sdktrace.WithResource(resource.NewSchemaless(attribute.String("service.name", ServiceName))),
+	)
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{}))
+
+	return provider
+}
+
+// User tags the span with the user and the shard the user lives on.
+func User(userID int64) []attribute.KeyValue {
+	_, shardID, _ := id.ParseUserID(userID)
+	return []attribute.KeyValue{UserIDKey.Int64(userID), ShardKey.Int(shardID)}
+}
+
+// Transfer tags the span with the transfer and both sides of it, to is 0 when there is no single recipient.
+func Transfer(transferID string, from, to int64) []attribute.KeyValue {
+	attrs := []attribute.KeyValue{TransferIDKey.String(transferID)}
+	if from != 0 {
+		_, shardID, _ := id.ParseUserID(from)
+		attrs = append(attrs, FromUserIDKey.Int64(from), FromShardKey.Int(shardID))
+	}
+	if to != 0 {
+		_, shardID, _ := id.ParseUserID(to)
+		attrs = append(attrs, ToUserIDKey.Int64(to), ToShardKey.Int(shardID))
+	}
+	return attrs
+}
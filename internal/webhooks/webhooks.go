@@ -0,0 +1,87 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+	"usershards/internal/models"
+)
+
+const SignatureHeader = "X-Webhook-Signature"
+const EventHeader = "X-Webhook-Event"
+const DeliveryHeader = "X-Webhook-Delivery"
+
+const signaturePrefix = "sha256="
+
+// Body is what a webhook receives, Data is the payload of the event.
+type Body struct {
+	ID        string           `json:"id"` // the delivery id, the same for every attempt and redelivery
+	Type      models.EventType `json:"type"`
+	CreatedAt time.Time        `json:"created_at"`
+	Data      json.RawMessage  `json:"data"`
+}
+
+func NewBody(deliveryID string, event models.Event) ([]byte, error) {
+	body, err := json.Marshal(Body{
+		ID:        deliveryID,
+		Type:      event.Type,
+		CreatedAt: event.CreatedAt,
+		Data:      event.Payload,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal webhook body: %w", err)
+	}
+	return body, nil
+}
+
+// Sign returns the value of SignatureHeader, the hex HMAC-SHA256 of the body.
+func Sign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return signaturePrefix + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature the way an integrator does.
+func Verify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(Sign(secret, body)), []byte(signature))
+}
+
+type Client struct {
+	http *http.Client
+}
+
+func NewClient(timeout time.Duration) *Client {
+	return &Client{http: &http.Client{Timeout: timeout}}
+}
+
+// Send posts the delivery to the webhook. It returns the response code, 0 if there was no response,
+// and an error for anything but 2xx.
+func (c *Client) Send(ctx context.Context, webhook models.Webhook, delivery models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, fmt.Errorf("failed to create webhook request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, delivery.Payload))
+	req.Header.Set(EventHeader, string(delivery.EventType))
+	req.Header.Set(DeliveryHeader, delivery.ID)
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to send webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
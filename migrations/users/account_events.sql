@@ -0,0 +1,18 @@
+CREATE TABLE IF NOT EXISTS account_events (
+    user_id bigint NOT NULL,
+    version bigint NOT NULL,
+    type VARCHAR NOT NULL,
+    transfer_id VARCHAR,
+    transaction_type VARCHAR,
+    amount bigint NOT NULL DEFAULT 0,
+    currency VARCHAR(3),
+    created_at timestamp NOT NULL,
+    PRIMARY KEY (user_id, version)
+);
+
+CREATE TABLE IF NOT EXISTS account_snapshots (
+    user_id bigint PRIMARY KEY,
+    version bigint NOT NULL,
+    state jsonb NOT NULL,
+    created_at timestamp NOT NULL
+);
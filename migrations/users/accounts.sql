@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS accounts (
+    user_id bigint NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    balance bigint NOT NULL DEFAULT 0,
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL,
+    PRIMARY KEY (user_id, currency)
+);
+
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS currency VARCHAR(3) NOT NULL DEFAULT 'RUB';
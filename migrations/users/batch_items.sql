@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS batch_items (
+    batch_id uuid NOT NULL,
+    position int NOT NULL,
+    to_id bigint NOT NULL,
+    amount bigint NOT NULL,
+    fee bigint NOT NULL,
+    transaction_id uuid NOT NULL,
+    status VARCHAR NOT NULL,
+    error VARCHAR NOT NULL DEFAULT '',
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL,
+    PRIMARY KEY (batch_id, position)
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS campaign_budgets (
+    campaign_id VARCHAR PRIMARY KEY,
+    spent bigint NOT NULL DEFAULT 0,
+    updated_at timestamp NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS campaign_grants (
+    user_id bigint PRIMARY KEY,
+    campaign_id VARCHAR NOT NULL,
+    amount bigint NOT NULL,
+    status VARCHAR NOT NULL,
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
@@ -0,0 +1,9 @@
+CREATE TABLE IF NOT EXISTS cdc_checkpoints (
+    slot VARCHAR(64) PRIMARY KEY,
+    lsn bigint NOT NULL,
+    updated_at timestamp NOT NULL
+);
+
+-- the change feed carries the whole old row of updates and deletes
+ALTER TABLE users REPLICA IDENTITY FULL;
+ALTER TABLE transaction REPLICA IDENTITY FULL;
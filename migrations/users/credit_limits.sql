@@ -0,0 +1,3 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS credit_limit bigint NOT NULL DEFAULT 0;
+
+CREATE INDEX IF NOT EXISTS users_negative_balance_idx ON users (id) WHERE balance < 0;
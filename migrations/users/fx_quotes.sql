@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS fx_quotes (
+    id uuid PRIMARY KEY,
+    user_id bigint NOT NULL,
+    from_currency VARCHAR(3) NOT NULL,
+    to_currency VARCHAR(3) NOT NULL,
+    source_amount bigint NOT NULL,
+    target_amount bigint NOT NULL,
+    spread_amount bigint NOT NULL,
+    rate bigint NOT NULL,
+    mid_rate bigint NOT NULL,
+    status VARCHAR NOT NULL,
+    expires_at timestamp NOT NULL,
+    created_at timestamp NOT NULL
+);
@@ -0,0 +1,14 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS held_balance bigint NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS holds (
+    id uuid PRIMARY KEY,
+    user_id bigint NOT NULL,
+    to_id bigint,
+    amount bigint NOT NULL,
+    status VARCHAR NOT NULL,
+    expires_at timestamp NOT NULL,
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS holds_user_id_idx ON holds (user_id, status);
@@ -0,0 +1 @@
+ALTER TABLE holds ADD COLUMN IF NOT EXISTS captured bigint NOT NULL DEFAULT 0;
@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS user_limits (
+    user_id bigint PRIMARY KEY,
+    single_limit bigint NOT NULL,
+    daily_limit bigint NOT NULL,
+    monthly_limit bigint NOT NULL,
+    updated_at timestamp NOT NULL
+);
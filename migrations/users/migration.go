@@ -10,3 +10,66 @@ var Migration2 string
 
 //go:embed transactions.sql
 var Migration3 string
+
+//go:embed transactions_history.sql
+var Migration4 string
+
+//go:embed transactions_status.sql
+var Migration5 string
+
+//go:embed holds.sql
+var Migration6 string
+
+//go:embed limits.sql
+var Migration7 string
+
+//go:embed accounts.sql
+var Migration8 string
+
+//go:embed fx_quotes.sql
+var Migration9 string
+
+//go:embed system_accounts.sql
+var Migration10 string
+
+//go:embed campaigns.sql
+var Migration11 string
+
+//go:embed payments.sql
+var Migration12 string
+
+//go:embed schedules.sql
+var Migration13 string
+
+//go:embed holds_captured.sql
+var Migration14 string
+
+//go:embed refunds.sql
+var Migration15 string
+
+//go:embed credit_limits.sql
+var Migration16 string
+
+//go:embed outbox.sql
+var Migration17 string
+
+//go:embed webhooks.sql
+var Migration18 string
+
+//go:embed cdc.sql
+var Migration19 string
+
+//go:embed account_events.sql
+var Migration20 string
+
+//go:embed batch_items.sql
+var Migration21 string
+
+//go:embed settlements.sql
+var Migration22 string
+
+//go:embed transactions_amount.sql
+var Migration23 string
+
+//go:embed schedules_start_at.sql
+var Migration24 string
@@ -0,0 +1,10 @@
+CREATE TABLE IF NOT EXISTS outbox (
+    id bigserial PRIMARY KEY,
+    user_id bigint NOT NULL,
+    type VARCHAR NOT NULL,
+    payload jsonb NOT NULL,
+    created_at timestamp NOT NULL,
+    published_at timestamp
+);
+
+CREATE INDEX IF NOT EXISTS outbox_pending_idx ON outbox (id) WHERE published_at IS NULL;
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS payments (
+    id uuid PRIMARY KEY,
+    user_id bigint NOT NULL,
+    type VARCHAR NOT NULL,
+    amount bigint NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    status VARCHAR NOT NULL,
+    provider_ref VARCHAR NOT NULL DEFAULT '',
+    reason VARCHAR NOT NULL DEFAULT '',
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS payments_user_id_idx ON payments (user_id, created_at DESC);
@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS refunds (
+    id uuid PRIMARY KEY,
+    transfer_id uuid NOT NULL,
+    from_id bigint NOT NULL,
+    to_id bigint NOT NULL,
+    amount bigint NOT NULL,
+    currency VARCHAR(3) NOT NULL,
+    status VARCHAR NOT NULL,
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS refunds_transfer_id_idx ON refunds (transfer_id);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS schedules (
+    id uuid PRIMARY KEY,
+    from_id bigint NOT NULL,
+    to_id bigint NOT NULL,
+    amount bigint NOT NULL,
+    kind VARCHAR NOT NULL,
+    cron VARCHAR NOT NULL DEFAULT '',
+    status VARCHAR NOT NULL,
+    next_run_at timestamp,
+    runs bigint NOT NULL DEFAULT 0,
+    last_error VARCHAR NOT NULL DEFAULT '',
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS schedules_from_id_idx ON schedules (from_id, created_at DESC);
@@ -0,0 +1,3 @@
+ALTER TABLE schedules ADD COLUMN IF NOT EXISTS start_at timestamp;
+UPDATE schedules SET start_at = COALESCE(next_run_at, created_at) WHERE start_at IS NULL;
+ALTER TABLE schedules ALTER COLUMN start_at SET NOT NULL;
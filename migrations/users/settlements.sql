@@ -0,0 +1,17 @@
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS settlement_id uuid;
+
+-- the interest entries of the shard posted to the treasury at once
+CREATE TABLE IF NOT EXISTS settlements (
+    id uuid PRIMARY KEY,
+    transfer_id uuid NOT NULL,
+    type VARCHAR NOT NULL,
+    amount bigint NOT NULL,
+    posted bool NOT NULL DEFAULT false,
+    created_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS settlements_transfer_id_idx ON settlements (transfer_id, type) WHERE NOT posted;
+
+-- the entries before were settled once per day under the transfer id
+UPDATE transaction SET settlement_id = transfer_id
+WHERE type IN ('interest_charge', 'interest_credit') AND settlement_id IS NULL;
@@ -0,0 +1 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS is_system bool NOT NULL DEFAULT false;
@@ -0,0 +1 @@
+ALTER TABLE transaction ALTER COLUMN amount TYPE bigint;
@@ -0,0 +1,5 @@
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS transfer_id uuid;
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS type VARCHAR;
+
+CREATE INDEX IF NOT EXISTS transaction_from_id_idx ON transaction (from_id, created_at DESC, id DESC);
+CREATE INDEX IF NOT EXISTS transaction_to_id_idx ON transaction (to_id, created_at DESC, id DESC);
@@ -0,0 +1,4 @@
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS balance_after bigint;
+ALTER TABLE transaction ADD COLUMN IF NOT EXISTS status VARCHAR NOT NULL DEFAULT 'posted';
+
+CREATE INDEX IF NOT EXISTS transaction_transfer_id_idx ON transaction (transfer_id);
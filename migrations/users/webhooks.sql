@@ -0,0 +1,22 @@
+CREATE TABLE IF NOT EXISTS webhooks (
+    id uuid PRIMARY KEY,
+    url VARCHAR NOT NULL,
+    secret VARCHAR NOT NULL,
+    event_types VARCHAR[] NOT NULL DEFAULT '{}',
+    created_at timestamp NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+    id uuid PRIMARY KEY,
+    webhook_id uuid NOT NULL,
+    event_type VARCHAR NOT NULL,
+    payload jsonb NOT NULL,
+    status VARCHAR NOT NULL,
+    attempts int NOT NULL DEFAULT 0,
+    response_code int NOT NULL DEFAULT 0,
+    last_error VARCHAR NOT NULL DEFAULT '',
+    created_at timestamp NOT NULL,
+    updated_at timestamp NOT NULL
+);
+
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_idx ON webhook_deliveries (webhook_id, created_at DESC);